@@ -3,12 +3,19 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/git"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
+	"github.com/SCKelemen/cpkg/internal/resolver"
+	"github.com/SCKelemen/cpkg/internal/semver"
 )
 
+var addSync bool
+
 var addCmd = clix.NewCommand("add",
 	clix.WithCommandShort("Add or update a dependency constraint"),
 	clix.WithCommandRun(func(ctx *clix.Context) error {
@@ -16,10 +23,32 @@ var addCmd = clix.NewCommand("add",
 	}),
 )
 
+func init() {
+	addCmd.Flags = clix.NewFlagSet("add")
+	addCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "sync",
+			Usage: "Run tidy and sync after adding",
+		},
+		Value: &addSync,
+	})
+}
+
+// addedDependency is a dependency resolved by add, before it is written to
+// the manifest.
+type addedDependency struct {
+	module   string
+	dep      manifest.Dependency
+	resolved string // Version, or commit of a pin, the requirement selects now
+}
+
 func runAdd(ctx *clix.Context) error {
 	if len(ctx.Args) == 0 {
 		return fmt.Errorf("no modules specified")
 	}
+	if IsFrozen() {
+		return fmt.Errorf("cannot add dependencies in frozen mode: %s would change", lockfile.LockfileName)
+	}
 
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -31,45 +60,201 @@ func runAdd(ctx *clix.Context) error {
 		return fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
 	}
 
-	m, err := manifest.Load(manifestPath)
+	doc, err := manifest.LoadDocument(manifestPath)
 	if err != nil {
 		return fmt.Errorf("failed to load manifest: %w", err)
 	}
-
-	if m.Dependencies == nil {
-		m.Dependencies = make(map[string]manifest.Dependency)
+	m, err := doc.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
 	}
 
 	// Parse each module@version argument
-	for _, arg := range ctx.Args {
-		module, version, err := parseModuleVersion(arg)
+	modules := make([]string, len(ctx.Args))
+	specs := make([]string, len(ctx.Args))
+	for i, arg := range ctx.Args {
+		modules[i], specs[i], err = parseModuleVersion(arg)
 		if err != nil {
 			return fmt.Errorf("invalid module specification %q: %w", arg, err)
 		}
+	}
 
-		if version == "" {
-			return fmt.Errorf("version required for %s (e.g., %s@^1.0.0)", module, module)
+	// Resolve every argument before changing anything, so a typo in one
+	// leaves the manifest untouched
+	src, err := newGitSource(m, filepath.Dir(manifestPath))
+	if err != nil {
+		return err
+	}
+	src.Prefetch(modules)
+	added := make([]addedDependency, len(modules))
+	for i, module := range modules {
+		dep, resolved, err := resolveAddSpec(src, module, specs[i])
+		if err != nil {
+			return err
 		}
+		added[i] = addedDependency{module: module, dep: dep, resolved: resolved}
+	}
 
-		oldDep, exists := m.Dependencies[module]
-		if exists {
-			fmt.Fprintf(ctx.App.Out, "~ %s: %s → %s\n", module, oldDep.Version, version)
+	for _, a := range added {
+		suffix := ""
+		if a.resolved != "" {
+			suffix = " (" + a.resolved + ")"
+		}
+		if oldDep, exists := m.Dependencies[a.module]; exists {
+			fmt.Fprintf(ctx.App.Out, "~ %s: %s → %s%s\n", a.module, oldDep.Constraint(), a.dep.Constraint(), suffix)
 		} else {
-			fmt.Fprintf(ctx.App.Out, "+ %s @ %s\n", module, version)
+			fmt.Fprintf(ctx.App.Out, "+ %s @ %s%s\n", a.module, a.dep.Constraint(), suffix)
 		}
 
-		m.Dependencies[module] = manifest.Dependency{
-			Version: version,
+		if err := doc.SetDependency(a.module, a.dep); err != nil {
+			return err
 		}
 	}
 
-	if err := manifest.Save(m, manifestPath); err != nil {
+	if err := doc.Save(manifestPath); err != nil {
 		return fmt.Errorf("failed to save manifest: %w", err)
 	}
 
+	if addSync {
+		fmt.Fprintf(ctx.App.Out, "\nRunning tidy...\n")
+		if err := runTidyInternal(cwd, "", false); err != nil {
+			return fmt.Errorf("tidy failed: %w", err)
+		}
+		fmt.Fprintf(ctx.App.Out, "Syncing submodules...\n")
+		if err := runSyncInternal(ctx.App.Out, ctx.App.Err, cwd, ""); err != nil {
+			return fmt.Errorf("sync failed: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// resolveAddSpec turns the version part of an add argument into the
+// dependency to record, checking against the module's repository that it
+// exists:
+//
+//   - "" or "latest" is ^ the highest released version
+//   - a constraint must be satisfied by a released version
+//   - otherwise spec names a branch, tag or (abbreviated) commit, which is
+//     pinned; the full SHA is recorded for a commit
+//
+// The module's subdirectory must exist at the selected commit. It also
+// returns the version or commit selected, for display. A module replaced by
+// a local directory is not looked up and needs an explicit constraint.
+func resolveAddSpec(src *gitSource, module, spec string) (manifest.Dependency, string, error) {
+	if r, ok := src.replacement(module, ""); ok && r.IsLocal() {
+		if spec == "" || spec == "latest" {
+			return manifest.Dependency{}, "", fmt.Errorf("version required for %s, which is replaced by %s (e.g., %s@^1.0.0)", module, r.Dir, module)
+		}
+		if _, err := semver.ParseConstraint(spec); err != nil {
+			return manifest.Dependency{}, "", fmt.Errorf("invalid constraint %q for %s: %w", spec, module, err)
+		}
+		return manifest.Dependency{Version: spec}, "", nil
+	}
+
+	target, _ := src.target(module, "")
+	mv, err := src.module(target)
+	if err != nil {
+		return manifest.Dependency{}, "", err
+	}
+	versions, err := src.Versions(module)
+	if err != nil {
+		return manifest.Dependency{}, "", err
+	}
+
+	var dep manifest.Dependency
+	var commit, resolved string
+	_, constraintErr := semver.ParseConstraint(spec)
+	switch {
+	case spec == "" || spec == "latest":
+		latest, err := resolver.Select(versions, []string{">=0.0.0"}, resolver.StrategyHighest)
+		if err != nil {
+			return manifest.Dependency{}, "", fmt.Errorf("%s has no released versions; pin a branch, tag or commit instead (e.g., %s@main)", module, module)
+		}
+		v, _ := semver.Parse(latest)
+		dep, resolved = manifest.Dependency{Version: "^" + v.String()}, latest
+	case constraintErr == nil:
+		if version, err := resolver.Select(versions, []string{spec}, resolver.StrategyHighest); err == nil {
+			dep, resolved = manifest.Dependency{Version: spec}, version
+			break
+		}
+		// A branch or tag that happens to parse as a constraint, e.g. "v2"
+		if dep, commit, err = resolvePin(src, mv.RepoURL, spec); err != nil {
+			return manifest.Dependency{}, "", fmt.Errorf("no version of %s satisfies %s%s", module, spec, latestHint(versions))
+		}
+	default:
+		if dep, commit, err = resolvePin(src, mv.RepoURL, spec); err != nil {
+			return manifest.Dependency{}, "", fmt.Errorf("%s has no version, branch, tag or commit %q: %w", module, spec, err)
+		}
+	}
+
+	if commit == "" {
+		versionTarget, version := src.target(module, resolved)
+		if commit, err = src.Commit(versionTarget, version); err != nil {
+			return manifest.Dependency{}, "", err
+		}
+	} else {
+		resolved = commit
+		if len(resolved) > 7 {
+			resolved = resolved[:7]
+		}
+	}
+
+	// Root tags are offered for a subdirectory without tags of its own, so
+	// a misspelled subdirectory is only noticed here
+	exists, err := git.HasPath(mv.RepoURL, commit, mv.Subpath)
+	if err != nil {
+		return manifest.Dependency{}, "", fmt.Errorf("failed to check %s: %w", module, err)
+	}
+	if !exists {
+		if mv.Subpath == "" {
+			return manifest.Dependency{}, "", fmt.Errorf("commit %s not found in %s", commit, mv.RepoURL)
+		}
+		return manifest.Dependency{}, "", fmt.Errorf("%s: directory %s does not exist in %s at %s", module, mv.Subpath, mv.RepoURL, resolved)
+	}
+	return dep, resolved, nil
+}
+
+// resolvePin looks ref up as a branch, then a tag, then a commit of
+// repoURL, and returns the pin for it and its commit.
+func resolvePin(src *gitSource, repoURL, ref string) (manifest.Dependency, string, error) {
+	refs, err := src.refs.Refs(repoURL)
+	if err != nil {
+		return manifest.Dependency{}, "", err
+	}
+	if commit, ok := refs.Branches[ref]; ok {
+		return manifest.Dependency{Branch: ref}, commit, nil
+	}
+	if commit, ok := refs.Tags[ref]; ok {
+		return manifest.Dependency{Tag: ref}, commit, nil
+	}
+	if !looksLikeCommit(ref) {
+		return manifest.Dependency{}, "", fmt.Errorf("no such branch or tag")
+	}
+	commit, err := git.ResolveCommit(repoURL, ref)
+	if err != nil {
+		return manifest.Dependency{}, "", err
+	}
+	return manifest.Dependency{Commit: commit}, commit, nil
+}
+
+// looksLikeCommit reports whether s could be an abbreviated or full SHA.
+func looksLikeCommit(s string) bool {
+	if len(s) < 4 || len(s) > 40 {
+		return false
+	}
+	return strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
+
+// latestHint describes the highest of versions for an error message.
+func latestHint(versions []string) string {
+	latest, err := resolver.Select(versions, []string{">=0.0.0"}, resolver.StrategyHighest)
+	if err != nil {
+		return " (no versions are released)"
+	}
+	return " (latest is " + latest + ")"
+}
+
 func parseModuleVersion(spec string) (module, version string, err error) {
 	parts := strings.Split(spec, "@")
 	if len(parts) == 1 {
@@ -41,6 +41,10 @@ func init() {
 }
 
 func runBuild(ctx *clix.Context) error {
+	if inWorkspace, err := forEachMember(ctx, runBuild); inWorkspace {
+		return err
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -56,15 +60,19 @@ func runBuild(ctx *clix.Context) error {
 		return fmt.Errorf("failed to load manifest: %w", err)
 	}
 
-	// Run tidy first
-	fmt.Fprintf(ctx.App.Out, "Resolving dependencies...\n")
+	// Run tidy first; frozen, this only checks the lockfile
+	if IsFrozen() {
+		fmt.Fprintf(ctx.App.Out, "Checking lockfile...\n")
+	} else {
+		fmt.Fprintf(ctx.App.Out, "Resolving dependencies...\n")
+	}
 	if err := runTidyInternal(cwd, buildDepRoot, false); err != nil {
 		return fmt.Errorf("failed to resolve dependencies: %w", err)
 	}
 
 	// Run sync
 	fmt.Fprintf(ctx.App.Out, "Syncing submodules...\n")
-	if err := runSyncInternal(cwd, buildDepRoot); err != nil {
+	if err := runSyncInternal(ctx.App.Out, ctx.App.Err, cwd, buildDepRoot); err != nil {
 		return fmt.Errorf("failed to sync submodules: %w", err)
 	}
 
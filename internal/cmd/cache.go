@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/format"
+	"github.com/SCKelemen/cpkg/internal/git"
+)
+
+var cacheCmd = clix.NewGroup("cache", "Manage the module cache",
+	cacheListCmd,
+	cacheCleanCmd,
+	cacheVerifyCmd,
+)
+
+var cacheListCmd = clix.NewCommand("list",
+	clix.WithCommandShort("List the repositories in the module cache"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runCacheList(ctx)
+	}),
+)
+
+var cacheCleanCmd = clix.NewCommand("clean",
+	clix.WithCommandShort("Remove all repositories from the module cache"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runCacheClean(ctx)
+	}),
+)
+
+var cacheVerifyCmd = clix.NewCommand("verify",
+	clix.WithCommandShort("Check the integrity of the repositories in the module cache"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runCacheVerify(ctx)
+	}),
+)
+
+type cacheListOutput struct {
+	Dir          string           `json:"dir" yaml:"dir"`
+	Repositories []git.CachedRepo `json:"repositories" yaml:"repositories"`
+}
+
+type cacheVerifyOutput struct {
+	Dir          string            `json:"dir" yaml:"dir"`
+	Repositories []cacheVerifyRepo `json:"repositories" yaml:"repositories"`
+	Verified     bool              `json:"verified" yaml:"verified"`
+}
+
+type cacheVerifyRepo struct {
+	RepoURL string `json:"repo_url" yaml:"repo_url"`
+	Dir     string `json:"dir" yaml:"dir"`
+	OK      bool   `json:"ok" yaml:"ok"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// moduleCacheDir returns the module cache directory, or an error if the
+// cache is disabled.
+func moduleCacheDir() (string, error) {
+	dir, err := git.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return "", fmt.Errorf("the module cache is disabled (%s=off)", git.CacheEnvVar)
+	}
+	return dir, nil
+}
+
+func runCacheList(ctx *clix.Context) error {
+	dir, err := moduleCacheDir()
+	if err != nil {
+		return err
+	}
+	repos, err := git.ListCache(dir)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat := GetFormat(); outputFormat != format.FormatText {
+		if repos == nil {
+			repos = []git.CachedRepo{}
+		}
+		return format.Write(ctx.App.Out, outputFormat, cacheListOutput{Dir: dir, Repositories: repos})
+	}
+
+	fmt.Fprintf(ctx.App.Out, "Module cache: %s\n\n", dir)
+	if len(repos) == 0 {
+		fmt.Fprintf(ctx.App.Out, "No cached repositories.\n")
+		return nil
+	}
+	fmt.Fprintf(ctx.App.Out, "%-70s %10s\n", "REPOSITORY", "SIZE")
+	fmt.Fprintf(ctx.App.Out, "%-70s %10s\n",
+		"──────────────────────────────────────────────────────────────────────",
+		"──────────")
+	var total int64
+	for _, repo := range repos {
+		fmt.Fprintf(ctx.App.Out, "%-70s %10s\n", repo.RepoURL, formatSize(repo.Size))
+		total += repo.Size
+	}
+	fmt.Fprintf(ctx.App.Out, "\n%d repositories, %s\n", len(repos), formatSize(total))
+	return nil
+}
+
+func runCacheClean(ctx *clix.Context) error {
+	dir, err := moduleCacheDir()
+	if err != nil {
+		return err
+	}
+	repos, err := git.ListCache(dir)
+	if err != nil {
+		return err
+	}
+	if err := git.CleanCache(dir); err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.App.Out, "Removed %d repositories from %s\n", len(repos), dir)
+	return nil
+}
+
+func runCacheVerify(ctx *clix.Context) error {
+	dir, err := moduleCacheDir()
+	if err != nil {
+		return err
+	}
+	repos, err := git.ListCache(dir)
+	if err != nil {
+		return err
+	}
+
+	output := cacheVerifyOutput{Dir: dir, Repositories: []cacheVerifyRepo{}, Verified: true}
+	failed := 0
+	for _, repo := range repos {
+		result := cacheVerifyRepo{RepoURL: repo.RepoURL, Dir: repo.Dir, OK: true}
+		if err := git.VerifyMirror(repo.Dir); err != nil {
+			result.OK = false
+			result.Error = err.Error()
+			output.Verified = false
+			failed++
+		}
+		output.Repositories = append(output.Repositories, result)
+	}
+
+	if outputFormat := GetFormat(); outputFormat != format.FormatText {
+		if err := format.Write(ctx.App.Out, outputFormat, output); err != nil {
+			return err
+		}
+	} else {
+		for _, repo := range output.Repositories {
+			if repo.OK {
+				fmt.Fprintf(ctx.App.Out, "✓ %s\n", repo.RepoURL)
+			} else {
+				fmt.Fprintf(ctx.App.Out, "✗ %s\n  %s\n", repo.RepoURL, repo.Error)
+			}
+		}
+		if failed == 0 {
+			fmt.Fprintf(ctx.App.Out, "All %d cached repositories verified.\n", len(repos))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cached repositories are corrupt, run 'cpkg cache clean' to remove them", failed, len(repos))
+	}
+	return nil
+}
+
+// formatSize formats a byte count for display, e.g. "12.3 MB".
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -7,10 +7,8 @@ import (
 
 	"github.com/SCKelemen/clix"
 	"github.com/SCKelemen/cpkg/internal/format"
-	"github.com/SCKelemen/cpkg/internal/git"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
-	"github.com/SCKelemen/cpkg/internal/modulepath"
 	"github.com/SCKelemen/cpkg/internal/semver"
 )
 
@@ -33,9 +31,17 @@ type checkDependency struct {
 	Constraint string `json:"constraint" yaml:"constraint"`
 	Notes      string `json:"notes" yaml:"notes"`
 	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+	Retracted  bool   `json:"retracted,omitempty" yaml:"retracted,omitempty"` // The current version was retracted by its author
 }
 
 func runCheck(ctx *clix.Context) error {
+	if dirs, ok, err := patternDirs(ctx.Args); ok {
+		if err != nil {
+			return err
+		}
+		return runPattern(ctx, dirs, runCheck)
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -60,58 +66,89 @@ func runCheck(ctx *clix.Context) error {
 	outputFormat := GetFormat()
 	deps := make([]checkDependency, 0, len(m.Dependencies))
 	hasUpdates := false
+	hasRetracted := false
+
+	src, err := newGitSource(m, filepath.Dir(manifestPath))
+	if err != nil {
+		return err
+	}
+	src.Prefetch(sortedDependencies(m))
+	if IsOffline() {
+		fmt.Fprintf(ctx.App.Err, "Offline: available versions are read from the module cache and may be out of date\n")
+	}
 
 	for modulePath, dep := range m.Dependencies {
 		lockDep, exists := lock.Dependencies[modulePath]
-		if !exists {
+		if !exists || lockDep.IsLocal() {
 			continue
 		}
 
 		currentVersion := lockDep.Version
-		constraint := dep.Version
+		constraint := dep.Constraint()
 
-		// Parse module path to extract repo URL and subpath
-		mp, err := modulepath.ParseModulePath(modulePath)
-		if err != nil {
+		// A pin has no newer versions, only a ref that may have moved
+		if dep.IsPinned() {
+			latest, err := src.pin(modulePath, dep)
+			if err != nil {
+				deps = append(deps, checkDependency{
+					Module:     modulePath,
+					Current:    currentVersion,
+					Latest:     "ERROR",
+					Constraint: constraint,
+					Error:      err.Error(),
+				})
+				continue
+			}
+			notes := "pinned"
+			if latest != currentVersion {
+				hasUpdates = true
+				if dep.Branch != "" {
+					notes = "new commits on " + dep.Branch
+				} else {
+					notes = "pin changed"
+				}
+			}
 			deps = append(deps, checkDependency{
 				Module:     modulePath,
 				Current:    currentVersion,
-				Latest:     "ERROR",
+				Latest:     latest,
 				Constraint: constraint,
-				Error:      fmt.Sprintf("invalid module path: %v", err),
+				Notes:      notes,
 			})
 			continue
 		}
 
-		repoURL := git.ModulePathToRepoURL(mp.RepoURL)
-		allTags, err := git.LsRemoteTags(repoURL)
+		// Versions excluded in cpkg.yaml or retracted by the author are
+		// never offered as updates
+		versions, err := src.Versions(modulePath)
 		if err != nil {
 			deps = append(deps, checkDependency{
 				Module:     modulePath,
 				Current:    currentVersion,
 				Latest:     "ERROR",
 				Constraint: constraint,
-				Error:      fmt.Sprintf("failed to fetch tags: %v", err),
+				Error:      err.Error(),
 			})
 			continue
 		}
-
-		// Filter tags for this subpath
-		tags := modulepath.FilterTagsForSubpath(allTags, mp.Subpath)
-
-		// Extract version from tags
-		var versionTags []string
-		for _, tag := range tags {
-			version, err := modulepath.ExtractVersionFromTag(tag, mp.Subpath)
-			if err != nil {
-				continue
-			}
-			versionTags = append(versionTags, version)
+		retracted, _ := src.Retracted(modulePath, currentVersion)
+		if retracted {
+			hasRetracted = true
 		}
 
 		// Find latest compatible version
-		latestCompatible, err := findCompatibleVersion(versionTags, constraint)
+		latestCompatible, err := findCompatibleVersion(versions, constraint)
 		if err != nil {
+			if retracted {
+				deps = append(deps, checkDependency{
+					Module:     modulePath,
+					Current:    currentVersion,
+					Latest:     "NONE",
+					Constraint: constraint,
+					Notes:      "retracted, no compatible version",
+					Retracted:  true,
+				})
+			}
 			continue
 		}
 
@@ -140,6 +177,11 @@ func runCheck(ctx *clix.Context) error {
 		} else {
 			notes = "up to date"
 		}
+		if retracted && latestV.Compare(currentV) > 0 {
+			notes = "retracted, " + notes
+		} else if retracted {
+			notes = "retracted"
+		}
 
 		deps = append(deps, checkDependency{
 			Module:     modulePath,
@@ -147,6 +189,7 @@ func runCheck(ctx *clix.Context) error {
 			Latest:     latestCompatible,
 			Constraint: constraint,
 			Notes:      notes,
+			Retracted:  retracted,
 		})
 	}
 
@@ -154,7 +197,7 @@ func runCheck(ctx *clix.Context) error {
 	if outputFormat != format.FormatText {
 		output := checkOutput{
 			Dependencies: deps,
-			AllUpToDate:  !hasUpdates,
+			AllUpToDate:  !hasUpdates && !hasRetracted,
 		}
 		return format.Write(ctx.App.Out, outputFormat, output)
 	}
@@ -175,7 +218,9 @@ func runCheck(ctx *clix.Context) error {
 		}
 	}
 
-	if !hasUpdates {
+	if hasRetracted {
+		fmt.Fprintf(ctx.App.Out, "\nSome locked versions have been retracted by their authors. Run 'cpkg tidy' to move off them.\n")
+	} else if !hasUpdates {
 		fmt.Fprintf(ctx.App.Out, "\nAll dependencies are up to date.\n")
 	}
 
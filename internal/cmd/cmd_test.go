@@ -1,10 +1,19 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/dirhash"
+	"github.com/SCKelemen/cpkg/internal/git"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
 )
 
@@ -78,9 +87,7 @@ func TestAddCommand(t *testing.T) {
 	}
 
 	manifestPath := filepath.Join(tmpDir, manifest.ManifestFileName)
-	if err := manifest.Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Test parsing
 	module, version, err := parseModuleVersion("github.com/test/lib@^1.0.0")
@@ -92,3 +99,1168 @@ func TestAddCommand(t *testing.T) {
 	}
 }
 
+
+func TestVendorCommand_VerifiesSum(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	m := &manifest.Manifest{
+		APIVersion:   "cpkg.ringil.dev/v0",
+		Kind:         "Module",
+		Module:       "test/module",
+		DepRoot:      "deps",
+		Dependencies: map[string]manifest.Dependency{"github.com/test/lib": {Version: "^1.0.0"}},
+	}
+	writeManifest(t, m, filepath.Join(tmpDir, manifest.ManifestFileName))
+
+	source := filepath.Join("deps", "github.com/test/lib")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "lib.h"), []byte("// v1.0.0\n"), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	sum, err := dirhash.HashDir(source)
+	if err != nil {
+		t.Fatalf("HashDir() error = %v", err)
+	}
+
+	lock := &lockfile.Lockfile{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Lockfile",
+		Module:     "test/module",
+		DepRoot:    "deps",
+		Dependencies: map[string]lockfile.Dependency{
+			"github.com/test/lib": {
+				Version:    "v1.0.0",
+				Commit:     "abc123def456abc123def456abc123def456abc1",
+				Sum:        sum,
+				VCS:        lockfile.VCSGit,
+				Path:       source,
+				SourcePath: source,
+			},
+		},
+	}
+	if err := lockfile.Save(lock, filepath.Join(tmpDir, lockfile.LockfileName)); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	vendorCopy = true
+	defer func() { vendorCopy = false }()
+
+	var buf bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+	if err := runVendor(ctx); err != nil {
+		t.Fatalf("runVendor() error = %v", err)
+	}
+
+	// A modified checkout no longer matches the lockfile
+	if err := os.WriteFile(filepath.Join(source, "lib.h"), []byte("// tampered\n"), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	err = runVendor(ctx)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("runVendor() error = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestSyncCommand_ReportsEveryFailure(t *testing.T) {
+	remotes := newTestRemotes(t)
+	modules := []string{"example.com/acme/liba", "example.com/acme/libb", "example.com/acme/libc", "example.com/acme/libd"}
+	m := &manifest.Manifest{
+		APIVersion:   "cpkg.ringil.dev/v0",
+		Kind:         "Module",
+		Module:       "example.com/acme/app",
+		DepRoot:      "deps",
+		Dependencies: make(map[string]manifest.Dependency),
+	}
+	for _, module := range modules {
+		remotes.publish(module, nil, "v1.0.0")
+		m.Dependencies[module] = manifest.Dependency{Version: "^1.0.0"}
+	}
+
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	// Two dependencies are locked at commits that do not exist
+	lockPath := filepath.Join(projectDir, lockfile.LockfileName)
+	lock, err := lockfile.Load(lockPath)
+	if err != nil {
+		t.Fatalf("failed to load lockfile: %v", err)
+	}
+	for _, module := range []string{"example.com/acme/libb", "example.com/acme/libd"} {
+		dep := lock.Dependencies[module]
+		dep.Commit = strings.Repeat("0", 40)
+		lock.Dependencies[module] = dep
+	}
+	if err := lockfile.Save(lock, lockPath); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	GlobalJobsFlag = 4
+	defer func() { GlobalJobsFlag = 0 }()
+
+	var buf bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+	err = runSync(ctx)
+	if err == nil || !strings.Contains(err.Error(), "2 of 4 dependencies failed") ||
+		!strings.Contains(err.Error(), "example.com/acme/libb:") || !strings.Contains(err.Error(), "example.com/acme/libd:") {
+		t.Fatalf("runSync() error = %v, want both failures", err)
+	}
+
+	output := buf.String()
+	for _, want := range []string{"✓ example.com/acme/liba @ v1.0.0", "✓ example.com/acme/libc @ v1.0.0", "✗ example.com/acme/libb", "Synced 2 of 4 dependencies (2 added"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q:\n%s", want, output)
+		}
+	}
+	// The dependencies that did not fail were fully synced
+	for _, module := range []string{"example.com/acme/liba", "example.com/acme/libc"} {
+		if _, err := os.Stat(filepath.Join(projectDir, "deps", module, "lib.h")); err != nil {
+			t.Errorf("%s should be checked out: %v", module, err)
+		}
+	}
+}
+
+func TestSyncCommand_ChecksOutCommittedContent(t *testing.T) {
+	remotes := newTestRemotes(t)
+	// Checked out with CRLF line endings unless conversions are disabled
+	libaDir := filepath.Join(remotes.root, "example.com/acme/liba.git")
+	remotes.git("", "init", "--quiet", libaDir)
+	if err := os.WriteFile(filepath.Join(libaDir, ".gitattributes"), []byte("* text eol=crlf\n"), 0644); err != nil {
+		t.Fatalf("failed to write .gitattributes: %v", err)
+	}
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+		if err := runSync(ctx); err != nil {
+			t.Fatalf("runSync() error = %v\n%s", err, buf.String())
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(projectDir, "deps", "example.com", "acme", "liba", "lib.h"))
+	if err != nil {
+		t.Fatalf("failed to read checkout: %v", err)
+	}
+	if want := "// v1.0.0\n"; string(data) != want {
+		t.Errorf("lib.h = %q, want %q as committed", data, want)
+	}
+}
+
+func TestSyncCommand_PrunesRemovedDependencies(t *testing.T) {
+	remotes := newTestRemotes(t)
+	modules := []string{"example.com/acme/liba", "example.com/acme/libb", "example.com/acme/libc"}
+	m := &manifest.Manifest{
+		APIVersion:   "cpkg.ringil.dev/v0",
+		Kind:         "Module",
+		Module:       "example.com/acme/app",
+		DepRoot:      "deps",
+		Dependencies: make(map[string]manifest.Dependency),
+	}
+	for _, module := range modules {
+		remotes.publish(module, nil, "v1.0.0")
+		m.Dependencies[module] = manifest.Dependency{Version: "^1.0.0"}
+	}
+
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+	manifestPath := filepath.Join(projectDir, manifest.ManifestFileName)
+	writeManifest(t, m, manifestPath)
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	syncWith := func(dryRun, keep bool) (string, error) {
+		syncDryRun, syncKeep = dryRun, keep
+		defer func() { syncDryRun, syncKeep = false, false }()
+		var buf bytes.Buffer
+		ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+		err := runSync(ctx)
+		return buf.String(), err
+	}
+	if output, err := syncWith(false, false); err != nil {
+		t.Fatalf("runSync() error = %v\n%s", err, output)
+	}
+
+	// libb and libc are removed; libc has a local change
+	delete(m.Dependencies, "example.com/acme/libb")
+	delete(m.Dependencies, "example.com/acme/libc")
+	writeManifest(t, m, manifestPath)
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+	libb := filepath.Join(projectDir, "deps", "example.com", "acme", "libb")
+	libc := filepath.Join(projectDir, "deps", "example.com", "acme", "libc")
+	if err := os.WriteFile(filepath.Join(libc, "lib.h"), []byte("// changed\n"), 0644); err != nil {
+		t.Fatalf("failed to change libc: %v", err)
+	}
+
+	for _, keep := range []bool{false, true} {
+		output, err := syncWith(!keep, keep)
+		if err != nil {
+			t.Fatalf("runSync(dryRun=%v, keep=%v) error = %v", !keep, keep, err)
+		}
+		want := "- example.com/acme/libb (would be removed)"
+		if keep {
+			want = "- example.com/acme/libb (kept)"
+		}
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q:\n%s", want, output)
+		}
+		if _, err := os.Stat(filepath.Join(libb, "lib.h")); err != nil {
+			t.Errorf("libb should not be removed: %v", err)
+		}
+	}
+
+	output, err := syncWith(false, false)
+	if err == nil || !strings.Contains(err.Error(), "example.com/acme/libc: not removed, it has local changes") {
+		t.Fatalf("runSync() error = %v, want libc to be kept for its local work", err)
+	}
+	if want := "remove deps/example.com/acme/libc by hand or run sync with --keep"; !strings.Contains(err.Error(), want) {
+		t.Errorf("runSync() error = %v, want the hint %q", err, want)
+	}
+	for _, want := range []string{"✓ example.com/acme/liba @ v1.0.0", "- example.com/acme/libb\n", "✗ example.com/acme/libc", "Removed 1 submodules"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q:\n%s", want, output)
+		}
+	}
+	if _, err := os.Stat(libb); !os.IsNotExist(err) {
+		t.Errorf("libb should be removed, stat error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, ".git", "modules", "deps", "example.com", "acme", "libb")); !os.IsNotExist(err) {
+		t.Errorf("the repository of libb should be removed, stat error = %v", err)
+	}
+	gitmodules, err := os.ReadFile(filepath.Join(projectDir, ".gitmodules"))
+	if err != nil {
+		t.Fatalf("failed to read .gitmodules: %v", err)
+	}
+	if strings.Contains(string(gitmodules), "libb") || !strings.Contains(string(gitmodules), "libc") {
+		t.Errorf(".gitmodules should keep libc but not libb:\n%s", gitmodules)
+	}
+	if _, err := os.Stat(filepath.Join(libc, "lib.h")); err != nil {
+		t.Errorf("libc should be kept: %v", err)
+	}
+}
+
+func TestSyncCommand_PrunesInSubdirectory(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+	remotes.publish("example.com/acme/libb", nil, "v1.0.0")
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+			"example.com/acme/libb": {Version: "^1.0.0"},
+		},
+	}
+
+	// The module is not at the top of its repository
+	repoDir := t.TempDir()
+	remotes.git(repoDir, "init", "--quiet")
+	projectDir := filepath.Join(repoDir, "firmware")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatalf("failed to create module directory: %v", err)
+	}
+	manifestPath := filepath.Join(projectDir, manifest.ManifestFileName)
+	writeManifest(t, m, manifestPath)
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	var buf bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+	if err := runSync(ctx); err != nil {
+		t.Fatalf("runSync() error = %v\n%s", err, buf.String())
+	}
+
+	delete(m.Dependencies, "example.com/acme/libb")
+	writeManifest(t, m, manifestPath)
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+	buf.Reset()
+	if err := runSync(ctx); err != nil {
+		t.Fatalf("runSync() error = %v\n%s", err, buf.String())
+	}
+	if !strings.Contains(buf.String(), "- example.com/acme/libb\n") {
+		t.Errorf("output should report libb as removed:\n%s", buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "deps", "example.com", "acme", "libb")); !os.IsNotExist(err) {
+		t.Errorf("libb should be removed, stat error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, ".git", "modules", "firmware", "deps", "example.com", "acme", "libb")); !os.IsNotExist(err) {
+		t.Errorf("the repository of libb should be removed, stat error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "deps", "example.com", "acme", "liba", "lib.h")); err != nil {
+		t.Errorf("liba should be kept: %v", err)
+	}
+}
+
+func TestSyncCommand_PrunesOnlyItsOwnSubmodules(t *testing.T) {
+	remotes := newTestRemotes(t)
+	for _, module := range []string{"example.com/acme/liba", "example.com/acme/libb", "example.com/acme/tool"} {
+		remotes.publish(module, nil, "v1.0.0")
+	}
+
+	// Two workspace members share a dependency root
+	t.Setenv("CPKG_DEP_ROOT", filepath.Join("..", "deps"))
+	root := t.TempDir()
+	remotes.git(root, "init", "--quiet")
+	write := func(path, data string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	member := func(module, dep string) string {
+		data := "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: " + module + "\ndepRoot: deps\n"
+		if dep != "" {
+			data += "dependencies:\n  " + dep + ":\n    version: ^1.0.0\n"
+		}
+		return data
+	}
+	write(manifest.WorkspaceFileName, "apiVersion: cpkg.ringil.dev/v0\nkind: Workspace\nuse:\n  - a\n  - b\n")
+	write("a/cpkg.yaml", member("example.com/acme/a", "example.com/acme/liba"))
+	write("b/cpkg.yaml", member("example.com/acme/b", "example.com/acme/libb"))
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	var out, errOut bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &out, Err: &errOut}}
+	tidyAndSync := func() {
+		t.Helper()
+		out.Reset()
+		errOut.Reset()
+		if err := runTidy(ctx); err != nil {
+			t.Fatalf("runTidy() error = %v\n%s", err, out.String())
+		}
+		if err := runSync(ctx); err != nil {
+			t.Fatalf("runSync() error = %v\n%s", err, out.String())
+		}
+	}
+	tidyAndSync()
+
+	// A submodule added by hand under the dependency root
+	remotes.git(root, "submodule", "add", "--quiet", git.ModulePathToRepoURL("example.com/acme/tool"), "deps/example.com/acme/tool")
+
+	deps := filepath.Join(root, "deps", "example.com", "acme")
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(deps, name, "lib.h"))
+		return err == nil
+	}
+
+	// Neither member prunes the other's dependency or the hand-added one
+	tidyAndSync()
+	if !exists("liba") || !exists("libb") || !exists("tool") {
+		t.Fatalf("no submodule should be removed:\n%s", out.String())
+	}
+	if want := "Warning: deps/example.com/acme/tool was not added by cpkg"; !strings.Contains(errOut.String(), want) {
+		t.Errorf("errors should contain %q:\n%s", want, errOut.String())
+	}
+
+	write("a/cpkg.yaml", member("example.com/acme/a", ""))
+	tidyAndSync()
+	if exists("liba") || !exists("libb") || !exists("tool") {
+		t.Errorf("only liba should be removed:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "- example.com/acme/liba\n") {
+		t.Errorf("output should report liba as removed:\n%s", out.String())
+	}
+
+	syncPruneUnknown = true
+	defer func() { syncPruneUnknown = false }()
+	tidyAndSync()
+	if exists("tool") || !exists("libb") {
+		t.Errorf("--prune-unknown should remove tool only:\n%s", out.String())
+	}
+}
+
+func TestRemoveCommand(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libz", nil, "v1.0.0")
+	remotes.publish("example.com/acme/liba", map[string]string{"example.com/acme/libz": "^1.0.0"}, "v1.0.0")
+	remotes.publish("example.com/acme/libb", nil, "v1.0.0")
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+			"example.com/acme/libb": {Version: "^1.0.0"},
+		},
+	}
+
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+	manifestPath := filepath.Join(projectDir, manifest.ManifestFileName)
+	writeManifest(t, m, manifestPath)
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	var buf bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+	if err := runSync(ctx); err != nil {
+		t.Fatalf("runSync() error = %v", err)
+	}
+
+	// libb is kept on disk with --keep-files
+	removeKeepFiles = true
+	buf.Reset()
+	ctx.Args = []string{"example.com/acme/libb"}
+	err := runRemove(ctx)
+	removeKeepFiles = false
+	if err != nil {
+		t.Fatalf("runRemove(--keep-files) error = %v", err)
+	}
+	libb := filepath.Join(projectDir, "deps", "example.com", "acme", "libb")
+	if _, err := os.Stat(filepath.Join(libb, "lib.h")); err != nil {
+		t.Errorf("libb should be kept: %v", err)
+	}
+
+	// liba goes, and with it libz, which only liba required
+	buf.Reset()
+	ctx.Args = []string{"example.com/acme/liba"}
+	if err := runRemove(ctx); err != nil {
+		t.Fatalf("runRemove() error = %v", err)
+	}
+	output := buf.String()
+	for _, want := range []string{"- example.com/acme/liba\n", "- example.com/acme/libz (no longer required)", "Removed deps/example.com/acme/liba", "Removed deps/example.com/acme/libz"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output should contain %q:\n%s", want, output)
+		}
+	}
+
+	m, err = manifest.Load(manifestPath)
+	if err != nil {
+		t.Fatalf("failed to load manifest: %v", err)
+	}
+	if len(m.Dependencies) != 0 {
+		t.Errorf("manifest dependencies = %v, want none", m.Dependencies)
+	}
+	lock, err := lockfile.Load(filepath.Join(projectDir, lockfile.LockfileName))
+	if err != nil {
+		t.Fatalf("failed to load lockfile: %v", err)
+	}
+	if len(lock.Dependencies) != 0 {
+		t.Errorf("lockfile dependencies = %v, want none", lock.Dependencies)
+	}
+	for _, module := range []string{"liba", "libz"} {
+		if _, err := os.Stat(filepath.Join(projectDir, "deps", "example.com", "acme", module)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed, stat error = %v", module, err)
+		}
+		if _, err := os.Stat(filepath.Join(projectDir, ".git", "modules", "deps", "example.com", "acme", module)); !os.IsNotExist(err) {
+			t.Errorf("the repository of %s should be removed, stat error = %v", module, err)
+		}
+	}
+
+	ctx.Args = []string{"example.com/acme/liba"}
+	if err := runRemove(ctx); err == nil || !strings.Contains(err.Error(), "is not a dependency") {
+		t.Errorf("runRemove() of a missing dependency error = %v", err)
+	}
+}
+
+func TestRemoveCommand_KeepsManifestOnFailure(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+	manifestPath := filepath.Join(projectDir, manifest.ManifestFileName)
+	// The remaining dependency has no repository, so resolving fails
+	const original = `apiVersion: cpkg.ringil.dev/v0
+kind: Module
+module: example.com/acme/app
+depRoot: deps
+dependencies:
+  example.com/acme/liba:
+    version: ^1.0.0
+  example.com/acme/missing:
+    version: ^1.0.0
+`
+	if err := os.WriteFile(manifestPath, []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	ctx := &clix.Context{App: &clix.App{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}}, Args: []string{"example.com/acme/liba"}}
+	if err := runRemove(ctx); err == nil {
+		t.Fatal("runRemove() should fail when the remaining dependencies do not resolve")
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	if string(data) != original {
+		t.Errorf("manifest changed by a failed remove:\n%s", data)
+	}
+
+	// A lockfile that cannot be read is not mistaken for an empty one
+	lockfilePath := filepath.Join(projectDir, lockfile.LockfileName)
+	if err := os.WriteFile(lockfilePath, []byte("dependencies: [\n"), 0644); err != nil {
+		t.Fatalf("failed to write lockfile: %v", err)
+	}
+	if err := runRemove(ctx); err == nil || !strings.Contains(err.Error(), "failed to load lockfile") {
+		t.Errorf("runRemove() with a corrupt lockfile error = %v", err)
+	}
+}
+
+func TestAddCommand_Resolves(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+	remotes.publish("example.com/acme/liba", nil, "v1.2.0")
+	remotes.publish("example.com/acme/libb", nil)
+	libbDir := filepath.Join(remotes.root, "example.com/acme/libb.git")
+	remotes.git(libbDir, "branch", "dev")
+	remotes.git(libbDir, "tag", "snapshot-1")
+	output, err := exec.Command("git", "-C", libbDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatalf("git rev-parse failed: %v", err)
+	}
+	commit := strings.TrimSpace(string(output))
+
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+	manifestPath := filepath.Join(projectDir, manifest.ManifestFileName)
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+	}
+	writeManifest(t, m, manifestPath)
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	add := func(args ...string) (string, error) {
+		var buf bytes.Buffer
+		ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}, Args: args}
+		err := runAdd(ctx)
+		return buf.String(), err
+	}
+
+	// Nothing is written if any argument fails
+	for _, args := range [][]string{
+		{"example.com/acme/liba", "example.com/acme/nosuchlib"},
+		{"example.com/acme/liba@^2.0.0"},
+		{"example.com/acme/liba/nosuchdir"},
+		{"example.com/acme/libb@nosuchbranch"},
+		{"example.com/acme/libb"},
+	} {
+		if _, err := add(args...); err == nil {
+			t.Errorf("runAdd(%v) should fail", args)
+		}
+	}
+	if m, _ := manifest.Load(manifestPath); len(m.Dependencies) != 0 {
+		t.Fatalf("failed adds should not change the manifest: %v", m.Dependencies)
+	}
+
+	// A module replaced by a local directory needs a constraint
+	m.Replace = map[string]string{"example.com/acme/libc": "./work/libc"}
+	writeManifest(t, m, manifestPath)
+	_, err = add("example.com/acme/libc")
+	if want := "version required for example.com/acme/libc, which is replaced by ./work/libc"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("runAdd() of a locally replaced module error = %v, want %q", err, want)
+	}
+	m.Replace = nil
+	writeManifest(t, m, manifestPath)
+
+	tests := []struct {
+		args []string
+		want manifest.Dependency
+		out  string
+	}{
+		{[]string{"example.com/acme/liba"}, manifest.Dependency{Version: "^1.2.0"}, "+ example.com/acme/liba @ ^1.2.0 (v1.2.0)"},
+		{[]string{"example.com/acme/liba@~1.0.0"}, manifest.Dependency{Version: "~1.0.0"}, "~ example.com/acme/liba: ^1.2.0 → ~1.0.0 (v1.0.0)"},
+		{[]string{"example.com/acme/liba@latest"}, manifest.Dependency{Version: "^1.2.0"}, "~ example.com/acme/liba: ~1.0.0 → ^1.2.0"},
+		{[]string{"example.com/acme/libb@dev"}, manifest.Dependency{Branch: "dev"}, "+ example.com/acme/libb @ branch:dev (" + commit[:7] + ")"},
+		{[]string{"example.com/acme/libb@snapshot-1"}, manifest.Dependency{Tag: "snapshot-1"}, "tag:snapshot-1"},
+		{[]string{"example.com/acme/libb@" + commit[:8]}, manifest.Dependency{Commit: commit}, "commit:" + commit[:12]},
+	}
+	for _, tt := range tests {
+		output, err := add(tt.args...)
+		if err != nil {
+			t.Fatalf("runAdd(%v) error = %v", tt.args, err)
+		}
+		if !strings.Contains(output, tt.out) {
+			t.Errorf("runAdd(%v) output = %q, want %q", tt.args, output, tt.out)
+		}
+		m, err := manifest.Load(manifestPath)
+		if err != nil {
+			t.Fatalf("failed to load manifest: %v", err)
+		}
+		module, _, _ := parseModuleVersion(tt.args[0])
+		if got := m.Dependencies[module]; got != tt.want {
+			t.Errorf("runAdd(%v) recorded %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+
+	addSync = true
+	defer func() { addSync = false }()
+	if _, err := add("example.com/acme/liba@^1.0.0"); err != nil {
+		t.Fatalf("runAdd(--sync) error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "deps", "example.com", "acme", "liba", "lib.h")); err != nil {
+		t.Errorf("liba should be synced: %v", err)
+	}
+}
+
+func TestUpgradeCommand_WriteConstraints(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolution string
+		libb       string // Locked version of libb, whose constraint is not raised
+	}{
+		{"highest", "", "v0.3.0"},
+		// Minimal version selection only upgrades the raised constraint
+		{"minimal", "resolution: minimal\n", "v0.2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remotes := newTestRemotes(t)
+			remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+			remotes.publish("example.com/acme/libb", nil, "v0.2.0")
+
+			projectDir := t.TempDir()
+			remotes.git(projectDir, "init", "--quiet")
+			manifestPath := filepath.Join(projectDir, manifest.ManifestFileName)
+			original := `apiVersion: cpkg.ringil.dev/v0
+kind: Module
+module: example.com/acme/app
+depRoot: deps
+` + tt.resolution + `dependencies:
+  # The core library
+  example.com/acme/liba:
+    version: ^1.0.0
+  example.com/acme/libb:
+    version: ">=0.1.0 <1.0.0" # Until 1.0
+x-team: firmware
+`
+			if err := os.WriteFile(manifestPath, []byte(original), 0644); err != nil {
+				t.Fatalf("failed to write manifest: %v", err)
+			}
+			if err := runTidyInternal(projectDir, "", false); err != nil {
+				t.Fatalf("runTidyInternal() error = %v", err)
+			}
+			remotes.publish("example.com/acme/liba", nil, "v1.3.0")
+			remotes.publish("example.com/acme/libb", nil, "v0.3.0")
+			// Mirrors are only fetched once per process; start from a fresh cache as
+			// a new cpkg run would see the new tags
+			t.Setenv(git.CacheEnvVar, filepath.Join(t.TempDir(), "cache"))
+
+			originalDir, _ := os.Getwd()
+			defer os.Chdir(originalDir)
+			if err := os.Chdir(projectDir); err != nil {
+				t.Fatalf("failed to chdir: %v", err)
+			}
+			upgradeWriteConstraints = true
+			defer func() { upgradeWriteConstraints = false }()
+
+			var buf, errBuf bytes.Buffer
+			ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &errBuf}}
+			if err := runUpgrade(ctx); err != nil {
+				t.Fatalf("runUpgrade() error = %v\n%s", err, buf.String())
+			}
+			if !strings.Contains(buf.String(), "Raising example.com/acme/liba in cpkg.yaml: ^1.0.0 → ^1.3.0") {
+				t.Errorf("output should report the raised constraint:\n%s", buf.String())
+			}
+			if !strings.Contains(errBuf.String(), "not rewriting constraint") {
+				t.Errorf("a range with an upper bound should be left alone with a warning:\n%s", errBuf.String())
+			}
+
+			data, err := os.ReadFile(manifestPath)
+			if err != nil {
+				t.Fatalf("failed to read manifest: %v", err)
+			}
+			want := strings.Replace(original, "version: ^1.0.0", "version: ^1.3.0", 1)
+			if string(data) != want {
+				t.Errorf("manifest = \n%s\nwant\n%s", data, want)
+			}
+
+			lock, err := lockfile.Load(filepath.Join(projectDir, lockfile.LockfileName))
+			if err != nil {
+				t.Fatalf("failed to load lockfile: %v", err)
+			}
+			if got := lock.Dependencies["example.com/acme/liba"].Version; got != "v1.3.0" {
+				t.Errorf("liba locked at %s, want v1.3.0", got)
+			}
+			if got := lock.Dependencies["example.com/acme/libb"].Version; got != tt.libb {
+				t.Errorf("libb locked at %s, want %s", got, tt.libb)
+			}
+		})
+	}
+}
+
+func TestRaiseConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       string
+		ok         bool
+	}{
+		{"^1.0.0", "v1.3.0", "^1.3.0", true},
+		{"~1.0", "v1.0.4", "~1.0.4", true},
+		{">= 1.0.0", "v2.1.0", ">=2.1.0", true},
+		{"^v1.2.0", "v1.3.0", "^v1.3.0", true},
+		{"~v1.2", "1.2.5", "~v1.2.5", true},
+		{">=1.0.0 <2.0.0", "v1.3.0", ">=1.0.0 <2.0.0", false},
+		{"^1.0 || ^2.0", "v2.1.0", "^1.0 || ^2.0", false},
+		{"1.x", "v1.3.0", "1.x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			got, ok := raiseConstraint(tt.constraint, tt.version)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("raiseConstraint(%q, %q) = %q, %v; want %q, %v", tt.constraint, tt.version, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSetPreRun(t *testing.T) {
+	var calls []string
+	record := func(name string, err error) clix.Hook {
+		return func(ctx *clix.Context) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	child := clix.NewCommand("child")
+	child.PreRun = record("child", nil)
+	plain := clix.NewCommand("plain")
+	root := clix.NewGroup("root", "", child, plain)
+
+	failing := errors.New("failing")
+	setPreRun(root, record("global", nil))
+	ctx := &clix.Context{App: &clix.App{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}}}
+	for _, cmd := range []*clix.Command{root, child, plain} {
+		calls = nil
+		if err := cmd.PreRun(ctx); err != nil {
+			t.Fatalf("%s PreRun error = %v", cmd.Name, err)
+		}
+		want := "global"
+		if cmd == child {
+			want = "global child"
+		}
+		if got := strings.Join(calls, " "); got != want {
+			t.Errorf("%s PreRun calls = %q, want %q", cmd.Name, got, want)
+		}
+	}
+
+	// A failing hook stops before the command's own
+	child.PreRun = record("child", nil)
+	setPreRun(child, record("global", failing))
+	calls = nil
+	if err := child.PreRun(ctx); !errors.Is(err, failing) || strings.Join(calls, " ") != "global" {
+		t.Errorf("PreRun = %v after calls %v, want the hook's error only", err, calls)
+	}
+}
+
+func TestGraphCommand(t *testing.T) {
+	projectDir := t.TempDir()
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+			"example.com/acme/libb": {Branch: "main"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+	// liba and libb both require the hal module of the mono repository,
+	// which requires the drivers module of the same repository at another
+	// commit
+	lock := &lockfile.Lockfile{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Lockfile",
+		Module:     "example.com/acme/app",
+		Dependencies: map[string]lockfile.Dependency{
+			"example.com/acme/liba": {Version: "v1.2.0", Commit: "a1", RepoURL: "https://example.com/acme/liba.git",
+				Requires: map[string]string{"example.com/acme/mono/hal": "^2.0.0"}},
+			"example.com/acme/libb": {Version: "v0.0.0-main", Commit: "b1", RepoURL: "https://example.com/acme/libb.git",
+				Requires: map[string]string{"example.com/acme/mono/hal": "^2.1.0"}},
+			"example.com/acme/mono/hal": {Version: "v2.1.0", Commit: "m1", RepoURL: "https://example.com/acme/mono.git", Indirect: true,
+				Requires: map[string]string{"example.com/acme/mono/drivers": "^1.0.0"}},
+			"example.com/acme/mono/drivers": {Version: "v1.0.0", Commit: "m0", RepoURL: "https://example.com/acme/mono.git", Indirect: true},
+		},
+	}
+	if err := lockfile.Save(lock, filepath.Join(projectDir, lockfile.LockfileName)); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer func() {
+		GlobalFormatFlag = ""
+		graphConstraints, graphDuplicates, graphDepth = false, false, 0
+	}()
+
+	run := func(outputFormat string, constraints, duplicates bool, depth int) string {
+		t.Helper()
+		GlobalFormatFlag = outputFormat
+		graphConstraints, graphDuplicates, graphDepth = constraints, duplicates, depth
+		var buf bytes.Buffer
+		ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+		if err := runGraph(ctx); err != nil {
+			t.Fatalf("runGraph() error = %v", err)
+		}
+		return buf.String()
+	}
+
+	t.Run("transitive tree", func(t *testing.T) {
+		want := `example.com/acme/app
+├─ example.com/acme/liba v1.2.0
+│  └─ example.com/acme/mono/hal v2.1.0
+│     └─ example.com/acme/mono/drivers v1.0.0
+└─ example.com/acme/libb v0.0.0-main
+   └─ example.com/acme/mono/hal v2.1.0 (*)
+`
+		if got := run("", false, false, 0); got != want {
+			t.Errorf("output =\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("constraints and duplicates", func(t *testing.T) {
+		got := run("", true, true, 0)
+		for _, want := range []string{
+			"├─ example.com/acme/liba v1.2.0 (^1.0.0)\n",
+			"└─ example.com/acme/libb v0.0.0-main (branch:main)\n",
+			"└─ example.com/acme/mono/hal v2.1.0 (^2.1.0) [repository locked at 2 commits] (*)\n",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("output does not contain %q:\n%s", want, got)
+			}
+		}
+	})
+
+	t.Run("depth", func(t *testing.T) {
+		got := run("", false, false, 1)
+		if strings.Contains(got, "mono") {
+			t.Errorf("--depth 1 should only show direct dependencies:\n%s", got)
+		}
+		got = run("dot", false, false, 2)
+		if strings.Contains(got, "drivers") {
+			t.Errorf("--depth 2 should not show drivers:\n%s", got)
+		}
+		if !strings.Contains(got, `"example.com/acme/libb" -> "example.com/acme/mono/hal";`) {
+			t.Errorf("dot output should hold the edges within the depth:\n%s", got)
+		}
+	})
+
+	t.Run("mermaid", func(t *testing.T) {
+		got := run("mermaid", true, true, 0)
+		for _, want := range []string{
+			`n0 -->|"^1.0.0"| n1`,
+			"class n3,n4 highlight\n",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("output does not contain %q:\n%s", want, got)
+			}
+		}
+	})
+}
+
+func TestWhyCommand(t *testing.T) {
+	projectDir := t.TempDir()
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+			"example.com/acme/libb": {Version: "^0.2.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+	lock := &lockfile.Lockfile{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Lockfile",
+		Module:     "example.com/acme/app",
+		Dependencies: map[string]lockfile.Dependency{
+			"example.com/acme/liba": {Version: "v1.2.0",
+				Requires: map[string]string{"example.com/acme/tls": "^3.0.0"}},
+			"example.com/acme/libb": {Version: "v0.2.1",
+				Requires: map[string]string{"example.com/acme/net": "^1.0.0"}},
+			"example.com/acme/net": {Version: "v1.4.0", Indirect: true,
+				Requires: map[string]string{"example.com/acme/tls": "^3.1.0"}},
+			"example.com/acme/tls": {Version: "v3.1.2", Indirect: true},
+		},
+	}
+	if err := lockfile.Save(lock, filepath.Join(projectDir, lockfile.LockfileName)); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer func() {
+		GlobalFormatFlag = ""
+		whyAll = false
+	}()
+
+	run := func(outputFormat string, all bool, module string) (string, error) {
+		GlobalFormatFlag = outputFormat
+		whyAll = all
+		var buf bytes.Buffer
+		ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}, Args: []string{module}}
+		err := runWhy(ctx)
+		return buf.String(), err
+	}
+
+	t.Run("shortest path", func(t *testing.T) {
+		got, err := run("", false, "example.com/acme/tls")
+		if err != nil {
+			t.Fatalf("runWhy() error = %v", err)
+		}
+		want := `# example.com/acme/tls v3.1.2
+example.com/acme/app
+└─ example.com/acme/liba v1.2.0 (^1.0.0)
+   └─ example.com/acme/tls v3.1.2 (^3.0.0)
+`
+		if got != want {
+			t.Errorf("output =\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("all paths", func(t *testing.T) {
+		got, err := run("json", true, "example.com/acme/tls")
+		if err != nil {
+			t.Fatalf("runWhy() error = %v", err)
+		}
+		var output whyOutput
+		if err := json.Unmarshal([]byte(got), &output); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, got)
+		}
+		if len(output.Paths) != 2 {
+			t.Fatalf("got %d paths, want 2:\n%s", len(output.Paths), got)
+		}
+		long := output.Paths[1]
+		if len(long) != 4 || long[1].Module != "example.com/acme/libb" || long[3].Constraint != "^3.1.0" {
+			t.Errorf("second path = %+v, want app → libb → net → tls (^3.1.0)", long)
+		}
+	})
+
+	t.Run("max paths", func(t *testing.T) {
+		whyMaxPaths = 1
+		defer func() { whyMaxPaths = 100 }()
+		got, err := run("json", true, "example.com/acme/tls")
+		if err != nil {
+			t.Fatalf("runWhy() error = %v", err)
+		}
+		var output whyOutput
+		if err := json.Unmarshal([]byte(got), &output); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, got)
+		}
+		if len(output.Paths) != 1 || !output.Truncated {
+			t.Errorf("got %d paths, truncated %v; want 1 path, truncated:\n%s", len(output.Paths), output.Truncated, got)
+		}
+		got, err = run("", true, "example.com/acme/tls")
+		if err != nil {
+			t.Fatalf("runWhy() error = %v", err)
+		}
+		if !strings.Contains(got, "Stopped after 1 paths; raise --max-paths to see the rest") {
+			t.Errorf("output should report the truncation:\n%s", got)
+		}
+	})
+
+	t.Run("not required", func(t *testing.T) {
+		if _, err := run("", false, "example.com/acme/other"); err == nil {
+			t.Error("runWhy() should fail for a module that is not locked")
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		g := &dependencyGraph{lock: lock, edges: map[string][]graphRequirement{}}
+		if got := g.shortestPath("example.com/acme/tls"); len(got) != 1 || got[0] != "example.com/acme/tls" {
+			t.Errorf("shortestPath() = %v, want only the module", got)
+		}
+		if got, truncated := g.allPaths("example.com/acme/tls", 0); len(got) != 0 || truncated {
+			t.Errorf("allPaths() = %v, %v; want no paths", got, truncated)
+		}
+	})
+}
+
+func TestLockDiffCommand(t *testing.T) {
+	remotes := newTestRemotes(t)
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+	}
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+	remotes.git(projectDir, "add", manifest.ManifestFileName)
+	remotes.git(projectDir, "commit", "--quiet", "-m", "Add manifest")
+
+	lockfilePath := filepath.Join(projectDir, lockfile.LockfileName)
+	lock := &lockfile.Lockfile{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Lockfile",
+		Module:     "example.com/acme/app",
+		Dependencies: map[string]lockfile.Dependency{
+			"github.com/acme/liba": {Version: "v1.2.0", Commit: "1111111111111111111111111111111111111111", RepoURL: "https://github.com/acme/liba.git"},
+			"github.com/acme/libb": {Version: "v0.3.0", Commit: "2222222222222222222222222222222222222222", RepoURL: "https://github.com/acme/libb.git"},
+		},
+	}
+	if err := lockfile.Save(lock, lockfilePath); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+	remotes.git(projectDir, "add", lockfile.LockfileName)
+	remotes.git(projectDir, "commit", "--quiet", "-m", "Lock dependencies")
+
+	lock.Dependencies["github.com/acme/liba"] = lockfile.Dependency{Version: "v1.3.0", Commit: "3333333333333333333333333333333333333333", RepoURL: "https://github.com/acme/liba.git"}
+	delete(lock.Dependencies, "github.com/acme/libb")
+	lock.Dependencies["github.com/acme/libc"] = lockfile.Dependency{Version: "v2.0.0", Commit: "4444444444444444444444444444444444444444", RepoURL: "https://github.com/acme/libc.git", Indirect: true}
+	if err := lockfile.Save(lock, lockfilePath); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	defer func() { GlobalFormatFlag = "" }()
+
+	run := func(outputFormat string, args ...string) (string, error) {
+		GlobalFormatFlag = outputFormat
+		var buf bytes.Buffer
+		ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}, Args: args}
+		err := runLockDiff(ctx)
+		return buf.String(), err
+	}
+
+	t.Run("HEAD and working tree", func(t *testing.T) {
+		got, err := run("")
+		if err != nil {
+			t.Fatalf("runLockDiff() error = %v", err)
+		}
+		want := `Changes from HEAD to lock.cpkg.yaml:
+
+↑ github.com/acme/liba v1.2.0 → v1.3.0 (minor)
+    commits: 111111111111..333333333333
+- github.com/acme/libb v0.3.0
++ github.com/acme/libc v2.0.0 (indirect)
+
+1 upgraded, 1 added, 1 removed
+`
+		if got != want {
+			t.Errorf("output =\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("revisions", func(t *testing.T) {
+		got, err := run("json", "HEAD~1", "HEAD")
+		if err != nil {
+			t.Fatalf("runLockDiff() error = %v", err)
+		}
+		var output lockDiffOutput
+		if err := json.Unmarshal([]byte(got), &output); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, got)
+		}
+		// The lockfile did not exist at HEAD~1
+		if len(output.Changes) != 2 || output.Changes[0].Change != lockfile.ChangeAdded || output.Changes[1].Change != lockfile.ChangeAdded {
+			t.Errorf("changes = %+v, want liba and libb added", output.Changes)
+		}
+
+		if _, err := run("", "no-such-rev"); err == nil {
+			t.Error("runLockDiff() should fail for an unknown revision")
+		}
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		got, err := run("markdown", "HEAD", lockfile.LockfileName)
+		if err != nil {
+			t.Fatalf("runLockDiff() error = %v", err)
+		}
+		for _, want := range []string{
+			"#### `lock.cpkg.yaml` changes (HEAD → lock.cpkg.yaml)\n",
+			"| `github.com/acme/liba` | upgraded (minor) | v1.2.0 → v1.3.0 | [`111111111111..333333333333`](https://github.com/acme/liba/compare/1111111111111111111111111111111111111111...3333333333333333333333333333333333333333) |\n",
+			"| `github.com/acme/libc` | added (indirect) | v2.0.0 | `444444444444` |\n",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("output does not contain %q:\n%s", want, got)
+			}
+		}
+	})
+}
@@ -9,14 +9,13 @@ import (
 	"github.com/SCKelemen/cpkg/internal/format"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
-	"github.com/SCKelemen/cpkg/internal/submodule"
 )
 
 type explainOutput struct {
-	Module     string              `json:"module" yaml:"module"`
-	Constraint string              `json:"constraint" yaml:"constraint"`
-	Locked     *explainLocked      `json:"locked,omitempty" yaml:"locked,omitempty"`
-	LocalState *explainLocalState  `json:"local_state,omitempty" yaml:"local_state,omitempty"`
+	Module     string             `json:"module" yaml:"module"`
+	Constraint string             `json:"constraint" yaml:"constraint"`
+	Locked     *explainLocked     `json:"locked,omitempty" yaml:"locked,omitempty"`
+	LocalState *explainLocalState `json:"local_state,omitempty" yaml:"local_state,omitempty"`
 }
 
 type explainLocked struct {
@@ -26,6 +25,7 @@ type explainLocked struct {
 	VCS     string `json:"vcs" yaml:"vcs"`
 	RepoURL string `json:"repo_url" yaml:"repo_url"`
 	Path    string `json:"path" yaml:"path"`
+	Replace string `json:"replace,omitempty" yaml:"replace,omitempty"`
 }
 
 type explainLocalState struct {
@@ -33,6 +33,8 @@ type explainLocalState struct {
 	CurrentCommit   string `json:"current_commit,omitempty" yaml:"current_commit,omitempty"`
 	InSync          bool   `json:"in_sync" yaml:"in_sync"`
 	IsDirty         bool   `json:"is_dirty" yaml:"is_dirty"`
+	LocalDir        string `json:"local_dir,omitempty" yaml:"local_dir,omitempty"` // Set for local replacements
+	LocalDirExists  bool   `json:"local_dir_exists,omitempty" yaml:"local_dir_exists,omitempty"`
 }
 
 var explainCmd = clix.NewCommand("explain",
@@ -83,7 +85,7 @@ func runExplain(ctx *clix.Context) error {
 	outputFormat := GetFormat()
 	output := explainOutput{
 		Module:     modulePath,
-		Constraint: dep.Version,
+		Constraint: dep.Constraint(),
 	}
 
 	if hasLockfile {
@@ -95,44 +97,23 @@ func runExplain(ctx *clix.Context) error {
 				VCS:     lockDep.VCS,
 				RepoURL: lockDep.RepoURL,
 				Path:    lockDep.Path,
+				Replace: lockDep.Replace,
 			}
 
-			// Check local submodule state
-			submodulePath := lockDep.Path
-			if !filepath.IsAbs(submodulePath) {
-				submodulePath = filepath.Join(filepath.Dir(manifestPath), submodulePath)
-			}
-
-			// Resolve symlinks (important for macOS)
-			resolvedPath, err := filepath.EvalSymlinks(submodulePath)
-			if err == nil {
-				submodulePath = resolvedPath
-			}
-
-			// Get relative path for git submodule commands
-			relPath := submodulePath
-			if filepath.IsAbs(submodulePath) {
-				realCwd, _ := filepath.EvalSymlinks(cwd)
-				if rel, err := filepath.Rel(realCwd, submodulePath); err == nil {
-					relPath = rel
+			state := inspectLocalState(filepath.Dir(manifestPath), lockDep)
+			if lockDep.IsLocal() {
+				output.LocalState = &explainLocalState{
+					LocalDir:       lockDep.Path,
+					LocalDirExists: state.Exists,
 				}
-			}
-
-			localState := &explainLocalState{
-				SubmoduleExists: submodule.SubmoduleExists(relPath),
-			}
-
-			if localState.SubmoduleExists {
-				currentCommit, err := submodule.GetSubmoduleCommit(submodulePath)
-				if err == nil {
-					localState.CurrentCommit = currentCommit
-					localState.InSync = currentCommit == lockDep.Commit
-					dirty, _ := submodule.IsSubmoduleDirty(submodulePath)
-					localState.IsDirty = dirty
+			} else {
+				output.LocalState = &explainLocalState{
+					SubmoduleExists: state.Exists,
+					CurrentCommit:   state.CurrentCommit,
+					InSync:          state.InSync,
+					IsDirty:         state.Dirty,
 				}
 			}
-
-			output.LocalState = localState
 		}
 	}
 
@@ -144,19 +125,36 @@ func runExplain(ctx *clix.Context) error {
 	// Text output
 	fmt.Fprintf(ctx.App.Out, "Dependency: %s\n", modulePath)
 	fmt.Fprintf(ctx.App.Out, "─────────────────────────────────────────────────────────────\n\n")
-	fmt.Fprintf(ctx.App.Out, "Constraint: %s\n", dep.Version)
+	fmt.Fprintf(ctx.App.Out, "Constraint: %s\n", dep.Constraint())
 
 	if hasLockfile {
 		if output.Locked != nil {
 			fmt.Fprintf(ctx.App.Out, "\nLocked Information:\n")
-			fmt.Fprintf(ctx.App.Out, "  Version: %s\n", output.Locked.Version)
-			fmt.Fprintf(ctx.App.Out, "  Commit:  %s\n", output.Locked.Commit)
-			fmt.Fprintf(ctx.App.Out, "  Sum:     %s\n", output.Locked.Sum)
-			fmt.Fprintf(ctx.App.Out, "  VCS:     %s\n", output.Locked.VCS)
-			fmt.Fprintf(ctx.App.Out, "  Repo:    %s\n", output.Locked.RepoURL)
-			fmt.Fprintf(ctx.App.Out, "  Path:    %s\n", output.Locked.Path)
-
-			if output.LocalState != nil {
+			if output.Locked.VCS == lockfile.VCSLocal {
+				if output.Locked.Version != "" {
+					fmt.Fprintf(ctx.App.Out, "  Version: %s\n", output.Locked.Version)
+				}
+				fmt.Fprintf(ctx.App.Out, "  Replace: %s (local directory)\n", output.Locked.Replace)
+			} else {
+				fmt.Fprintf(ctx.App.Out, "  Version: %s\n", output.Locked.Version)
+				if output.Locked.Replace != "" {
+					fmt.Fprintf(ctx.App.Out, "  Replace: %s\n", output.Locked.Replace)
+				}
+				fmt.Fprintf(ctx.App.Out, "  Commit:  %s\n", output.Locked.Commit)
+				fmt.Fprintf(ctx.App.Out, "  Sum:     %s\n", output.Locked.Sum)
+				fmt.Fprintf(ctx.App.Out, "  VCS:     %s\n", output.Locked.VCS)
+				fmt.Fprintf(ctx.App.Out, "  Repo:    %s\n", output.Locked.RepoURL)
+				fmt.Fprintf(ctx.App.Out, "  Path:    %s\n", output.Locked.Path)
+			}
+
+			if output.LocalState != nil && output.LocalState.LocalDir != "" {
+				fmt.Fprintf(ctx.App.Out, "\nLocal State:\n")
+				if output.LocalState.LocalDirExists {
+					fmt.Fprintf(ctx.App.Out, "  Directory: %s (used in place, no submodule)\n", output.LocalState.LocalDir)
+				} else {
+					fmt.Fprintf(ctx.App.Out, "  Directory: ⚠ %s does not exist\n", output.LocalState.LocalDir)
+				}
+			} else if output.LocalState != nil {
 				fmt.Fprintf(ctx.App.Out, "\nLocal State:\n")
 				if output.LocalState.SubmoduleExists {
 					shortCommit := output.LocalState.CurrentCommit
@@ -173,7 +171,7 @@ func runExplain(ctx *clix.Context) error {
 					}
 
 					if output.LocalState.IsDirty {
-						fmt.Fprintf(ctx.App.Out, "  Working tree: ⚠ dirty (has uncommitted changes)\n")
+						fmt.Fprintf(ctx.App.Out, "  Working tree: ⚠ dirty (has uncommitted changes or untracked files)\n")
 					} else {
 						fmt.Fprintf(ctx.App.Out, "  Working tree: ✓ clean\n")
 					}
@@ -193,4 +191,3 @@ func runExplain(ctx *clix.Context) error {
 
 	return nil
 }
-
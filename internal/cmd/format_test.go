@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/dirhash"
 	"github.com/SCKelemen/cpkg/internal/format"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
@@ -37,9 +39,7 @@ func TestListCommand_Format(t *testing.T) {
 	}
 
 	manifestPath := filepath.Join(tmpDir, manifest.ManifestFileName)
-	if err := manifest.Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Create a lockfile
 	lock := &lockfile.Lockfile{
@@ -176,9 +176,7 @@ func TestCheckCommand_Format(t *testing.T) {
 	}
 
 	manifestPath := filepath.Join(tmpDir, manifest.ManifestFileName)
-	if err := manifest.Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Create a lockfile
 	lock := &lockfile.Lockfile{
@@ -232,6 +230,73 @@ func TestCheckCommand_Format(t *testing.T) {
 	})
 }
 
+func TestCheckCommand_Retracted(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+	remotes.publish("example.com/acme/liba", nil, "v1.0.1")
+	remotes.publishManifest("example.com/acme/liba", &manifest.Manifest{
+		Retract: []string{"v1.0.1"},
+	}, "v1.0.2")
+
+	tmpDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "test/module",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(tmpDir, manifest.ManifestFileName))
+	lock := &lockfile.Lockfile{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Lockfile",
+		Module:     "test/module",
+		Dependencies: map[string]lockfile.Dependency{
+			"example.com/acme/liba": {Version: "v1.0.1", VCS: lockfile.VCSGit},
+		},
+	}
+	if err := lockfile.Save(lock, filepath.Join(tmpDir, lockfile.LockfileName)); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	GlobalFormatFlag = "json"
+	defer func() { GlobalFormatFlag = "" }()
+
+	var buf bytes.Buffer
+	ctx := &clix.Context{
+		App: &clix.App{
+			Out: &buf,
+			Err: &bytes.Buffer{},
+		},
+	}
+	if err := runCheck(ctx); err != nil {
+		t.Fatalf("runCheck() error = %v", err)
+	}
+
+	var result checkOutput
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON output: %v\nOutput: %s", err, buf.String())
+	}
+	if len(result.Dependencies) != 1 {
+		t.Fatalf("Expected 1 dependency, got %d", len(result.Dependencies))
+	}
+	dep := result.Dependencies[0]
+	if !dep.Retracted || dep.Latest != "v1.0.2" {
+		t.Errorf("check = %+v, want v1.0.1 flagged as retracted with v1.0.2 available", dep)
+	}
+	if result.AllUpToDate {
+		t.Error("a retracted locked version should not be reported as up to date")
+	}
+}
+
 func TestStatusCommand_Format(t *testing.T) {
 	tmpDir := t.TempDir()
 	originalDir, _ := os.Getwd()
@@ -253,9 +318,7 @@ func TestStatusCommand_Format(t *testing.T) {
 	}
 
 	manifestPath := filepath.Join(tmpDir, manifest.ManifestFileName)
-	if err := manifest.Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Create a lockfile
 	lock := &lockfile.Lockfile{
@@ -344,6 +407,79 @@ func TestStatusCommand_Format(t *testing.T) {
 	})
 }
 
+func TestStatusCommand_LocalReplacement(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "test/module",
+		Dependencies: map[string]manifest.Dependency{
+			"github.com/user/repo1": {Version: "^1.0.0"},
+			"github.com/user/repo2": {Version: "^1.0.0"},
+		},
+		Replace: map[string]string{
+			"github.com/user/repo1": "./work/repo1",
+			"github.com/user/repo2": "./work/repo2",
+		},
+	}
+	writeManifest(t, m, filepath.Join(tmpDir, manifest.ManifestFileName))
+	if err := os.MkdirAll(filepath.Join(tmpDir, "work", "repo1"), 0755); err != nil {
+		t.Fatalf("failed to create local directory: %v", err)
+	}
+
+	lock := &lockfile.Lockfile{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Lockfile",
+		Module:     "test/module",
+		Dependencies: map[string]lockfile.Dependency{
+			"github.com/user/repo1": {VCS: lockfile.VCSLocal, Path: "./work/repo1", SourcePath: "./work/repo1", Replace: "./work/repo1"},
+			"github.com/user/repo2": {VCS: lockfile.VCSLocal, Path: "./work/repo2", SourcePath: "./work/repo2", Replace: "./work/repo2"},
+		},
+	}
+	if err := lockfile.Save(lock, filepath.Join(tmpDir, lockfile.LockfileName)); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	GlobalFormatFlag = "json"
+	defer func() { GlobalFormatFlag = "" }()
+
+	var buf bytes.Buffer
+	ctx := &clix.Context{
+		App: &clix.App{
+			Out: &buf,
+			Err: &bytes.Buffer{},
+		},
+	}
+	if err := runStatus(ctx); err != nil {
+		t.Fatalf("runStatus() error = %v", err)
+	}
+
+	var result statusOutput
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON output: %v\nOutput: %s", err, buf.String())
+	}
+
+	want := map[string]string{
+		"github.com/user/repo1": "REPLACED",
+		"github.com/user/repo2": "MISSING",
+	}
+	for _, dep := range result.Dependencies {
+		if dep.Status != want[dep.Module] {
+			t.Errorf("%s status = %s, want %s", dep.Module, dep.Status, want[dep.Module])
+		}
+		if dep.Replace == "" {
+			t.Errorf("%s should report its replacement", dep.Module)
+		}
+	}
+}
+
 func TestGraphCommand_Format(t *testing.T) {
 	tmpDir := t.TempDir()
 	originalDir, _ := os.Getwd()
@@ -363,9 +499,7 @@ func TestGraphCommand_Format(t *testing.T) {
 	}
 
 	manifestPath := filepath.Join(tmpDir, manifest.ManifestFileName)
-	if err := manifest.Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Create a lockfile with dependencies
 	lock := &lockfile.Lockfile{
@@ -508,9 +642,7 @@ func TestExplainCommand_Format(t *testing.T) {
 	}
 
 	manifestPath := filepath.Join(tmpDir, manifest.ManifestFileName)
-	if err := manifest.Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Create a lockfile
 	lock := &lockfile.Lockfile{
@@ -603,6 +735,61 @@ func TestExplainCommand_Format(t *testing.T) {
 	})
 }
 
+func TestTidyCommand_ConflictFormat(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libc", nil, "v1.2.0")
+	remotes.publish("example.com/acme/libc", nil, "v2.0.0")
+	remotes.publish("example.com/acme/liba", map[string]string{
+		"example.com/acme/libc": "^2.0.0",
+	}, "v1.0.0")
+
+	tmpDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+			"example.com/acme/libc": {Version: "^1.2.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(tmpDir, manifest.ManifestFileName))
+
+	GlobalFormatFlag = "json"
+	defer func() { GlobalFormatFlag = "" }()
+
+	var buf bytes.Buffer
+	ctx := &clix.Context{
+		App: &clix.App{
+			Out: &buf,
+			Err: &bytes.Buffer{},
+		},
+	}
+
+	if err := runTidy(ctx); err == nil {
+		t.Fatal("runTidy() should fail on incompatible constraints")
+	}
+
+	var result tidyConflictOutput
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON output: %v\nOutput: %s", err, buf.String())
+	}
+	if result.Conflict == nil || result.Conflict.Module != "example.com/acme/libc" {
+		t.Fatalf("Expected conflict on example.com/acme/libc, got %+v", result.Conflict)
+	}
+	if len(result.Conflict.Requirements) != 2 {
+		t.Errorf("Expected 2 requirements, got %d", len(result.Conflict.Requirements))
+	}
+}
+
 func TestGetFormat(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -628,3 +815,175 @@ func TestGetFormat(t *testing.T) {
 	}
 }
 
+
+func TestVerifyCommand(t *testing.T) {
+	remotes := newTestRemotes(t) // For git and its commit identity
+	tmpDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	// A project with one checked-out submodule
+	remotes.git(tmpDir, "init", "--quiet")
+	depPath := filepath.Join("deps", "github.com/user/repo1")
+	remotes.git("", "init", "--quiet", depPath)
+	if err := os.WriteFile(filepath.Join(depPath, "lib.h"), []byte("// v1.2.3\n"), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(depPath, ".gitignore"), []byte("build/\n"), 0644); err != nil {
+		t.Fatalf("failed to write .gitignore: %v", err)
+	}
+	remotes.git(depPath, "add", "-A")
+	remotes.git(depPath, "commit", "--quiet", "-m", "release")
+	remotes.git(tmpDir, "config", "--file", ".gitmodules", "submodule."+filepath.ToSlash(depPath)+".url", "https://github.com/user/repo1.git")
+
+	head, err := exec.Command("git", "-C", depPath, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatalf("git rev-parse failed: %v", err)
+	}
+	sum, err := dirhash.HashDir(depPath)
+	if err != nil {
+		t.Fatalf("HashDir() error = %v", err)
+	}
+
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "test/module",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"github.com/user/repo1": {Version: "^1.0.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(tmpDir, manifest.ManifestFileName))
+	lock := &lockfile.Lockfile{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Lockfile",
+		Module:     "test/module",
+		Dependencies: map[string]lockfile.Dependency{
+			"github.com/user/repo1": {
+				Version:    "v1.2.3",
+				Commit:     strings.TrimSpace(string(head)),
+				Sum:        sum,
+				VCS:        lockfile.VCSGit,
+				RepoURL:    "https://github.com/user/repo1.git",
+				Path:       depPath,
+				SourcePath: depPath,
+			},
+		},
+	}
+	if err := lockfile.Save(lock, filepath.Join(tmpDir, lockfile.LockfileName)); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+
+	GlobalFormatFlag = "json"
+	defer func() { GlobalFormatFlag = "" }()
+
+	verify := func() (verifyOutput, error) {
+		var buf bytes.Buffer
+		ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+		runErr := runVerify(ctx)
+		var result verifyOutput
+		if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+			t.Fatalf("Invalid JSON output: %v\nOutput: %s", err, buf.String())
+		}
+		return result, runErr
+	}
+
+	result, err := verify()
+	if err != nil {
+		t.Fatalf("runVerify() error = %v", err)
+	}
+	if !result.Verified || len(result.Dependencies) != 1 || result.Dependencies[0].Status != "OK" {
+		t.Errorf("expected a verified dependency, got %+v", result)
+	}
+
+	// Ignored build outputs are neither changes nor part of the content hash
+	if err := os.MkdirAll(filepath.Join(depPath, "build"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(depPath, "build", "lib.o"), []byte("object\n"), 0644); err != nil {
+		t.Fatalf("failed to write build output: %v", err)
+	}
+	result, err = verify()
+	if err != nil {
+		t.Fatalf("runVerify() with an ignored build output error = %v", err)
+	}
+	if !result.Verified || result.Dependencies[0].Status != "OK" {
+		t.Errorf("expected a verified dependency, got %+v", result)
+	}
+
+	// Untracked files are changes, but not part of the content hash
+	extra := filepath.Join(depPath, "extra.h")
+	if err := os.WriteFile(extra, []byte("// extra\n"), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	result, err = verify()
+	if err == nil {
+		t.Fatal("runVerify() should fail for a dependency with untracked files")
+	}
+	if dep := result.Dependencies[0]; dep.Status != "DIRTY" || len(dep.Problems) != 1 || dep.CurrentSum != sum {
+		t.Errorf("expected a dirty dependency with a matching sum, got %+v", dep)
+	}
+	if err := os.Remove(extra); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+
+	// Local modifications fail both the dirty check and the content hash
+	if err := os.WriteFile(filepath.Join(depPath, "lib.h"), []byte("// patched\n"), 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	result, err = verify()
+	if err == nil {
+		t.Fatal("runVerify() should fail for a modified dependency")
+	}
+	dep := result.Dependencies[0]
+	if result.Verified || dep.Status != "DIRTY" || len(dep.Problems) != 2 || dep.CurrentSum == sum {
+		t.Errorf("expected a dirty dependency with a sum mismatch, got %+v", dep)
+	}
+}
+
+func TestManifestValidateCommand_Format(t *testing.T) {
+	path := filepath.Join(t.TempDir(), manifest.ManifestFileName)
+	data := "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: test/module\nx-owner: firmware\ndependencies:\n  github.com/user/repo1:\n    version: ^1.x.y\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	var buf bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}, Args: []string{path}}
+	err := runManifestValidate(ctx)
+	if err == nil || !strings.Contains(err.Error(), "has 1 errors") {
+		t.Errorf("runManifestValidate() error = %v, want 1 error", err)
+	}
+	for _, want := range []string{
+		path + ":4:1: warning: x-owner: unknown field",
+		path + `:7:14: error: dependencies.github.com/user/repo1.version: invalid constraint "^1.x.y"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	// JSON output lists warnings too, and a manifest with only warnings is valid
+	data = strings.Replace(data, "^1.x.y", "^1.0.0", 1)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+	GlobalFormatFlag = "json"
+	defer func() { GlobalFormatFlag = "" }()
+	buf.Reset()
+	if err := runManifestValidate(ctx); err != nil {
+		t.Fatalf("runManifestValidate() error = %v", err)
+	}
+	var output manifestValidateOutput
+	if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+		t.Fatalf("failed to parse JSON output: %v\n%s", err, buf.String())
+	}
+	if !output.Valid || len(output.Problems) != 1 || !output.Problems[0].Warning || output.Problems[0].Line != 4 {
+		t.Errorf("output = %+v, want valid with one warning on line 4", output)
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+	"github.com/SCKelemen/cpkg/internal/semver"
+)
+
+// checkFrozen checks that lock is up to date with m without resolving
+// anything: every dependency of the manifest and every requirement recorded
+// in the lockfile is locked at a version that satisfies it, with the same
+// replacement, and nothing else is locked. It is what tidy, build and test do
+// instead of resolving in frozen mode.
+//
+// Branch and tag pins cannot be checked without the network, so any locked
+// commit satisfies them; a commit pin must match the locked commit. In a
+// workspace, the replacements are those tidy applies: the directives of every
+// member, and the members themselves.
+func checkFrozen(m *manifest.Manifest, projectRoot string, lock *lockfile.Lockfile) error {
+	if lock == nil {
+		return fmt.Errorf("%s is missing and cannot be created in frozen mode; run 'cpkg tidy' without --frozen", lockfile.LockfileName)
+	}
+
+	src, err := newGitSource(m, projectRoot)
+	if err != nil {
+		return err
+	}
+	replacements := src.replacementsFor(projectRoot)
+
+	var problems []string
+	reached := make(map[string]bool)
+	var queue []string
+
+	// require checks that the locked version of modulePath satisfies
+	// constraint, if there is one.
+	require := func(modulePath, constraint, requiredBy string) {
+		dep, ok := lock.Dependencies[modulePath]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s (required by %s) is not locked", modulePath, requiredBy))
+			return
+		}
+		if !reached[modulePath] {
+			reached[modulePath] = true
+			queue = append(queue, modulePath)
+		}
+
+		r, replaced := manifest.FindReplacement(replacements, modulePath, dep.Version)
+		want := ""
+		if replaced {
+			want = r.Target()
+		}
+		if dep.Replace != want {
+			problems = append(problems, fmt.Sprintf("%s is locked with replacement %q, but %s has %q", modulePath, dep.Replace, manifest.ManifestFileName, want))
+		}
+		// Replacements of every version with a fixed target bypass constraints
+		if constraint == "" || (replaced && r.OldVersion == "" && (r.IsLocal() || r.NewVersion != "")) {
+			return
+		}
+
+		v, err := semver.Parse(dep.Version)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s is locked at invalid version %s", modulePath, dep.Version))
+			return
+		}
+		ok, err = v.Satisfies(constraint)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid constraint %q from %s: %v", modulePath, constraint, requiredBy, err))
+		} else if !ok {
+			problems = append(problems, fmt.Sprintf("%s is locked at %s, which does not satisfy %s required by %s", modulePath, dep.Version, constraint, requiredBy))
+		}
+	}
+
+	for modulePath, dep := range m.Dependencies {
+		kind, ref, err := dep.Pin()
+		if err != nil {
+			return fmt.Errorf("dependency %s: %w", modulePath, err)
+		}
+		switch kind {
+		case "":
+			require(modulePath, dep.Version, manifest.ManifestFileName)
+		case manifest.PinCommit:
+			require(modulePath, "", manifest.ManifestFileName)
+			if locked, ok := lock.Dependencies[modulePath]; ok && !locked.IsLocal() && !strings.HasPrefix(locked.Commit, strings.ToLower(ref)) {
+				problems = append(problems, fmt.Sprintf("%s is locked at commit %s, but %s pins %s", modulePath, locked.Commit, manifest.ManifestFileName, dep.Constraint()))
+			}
+		default:
+			require(modulePath, "", manifest.ManifestFileName)
+		}
+		if locked, ok := lock.Dependencies[modulePath]; ok && locked.Indirect {
+			problems = append(problems, fmt.Sprintf("%s is a direct dependency but is locked as indirect", modulePath))
+		}
+	}
+
+	for len(queue) > 0 {
+		modulePath := queue[0]
+		queue = queue[1:]
+		for required, constraint := range lock.Dependencies[modulePath].Requires {
+			require(required, constraint, modulePath)
+		}
+	}
+
+	for modulePath, dep := range lock.Dependencies {
+		if !reached[modulePath] {
+			problems = append(problems, fmt.Sprintf("%s is locked but no longer required", modulePath))
+		} else if _, direct := m.Dependencies[modulePath]; !direct && !dep.Indirect {
+			problems = append(problems, fmt.Sprintf("%s is locked as a direct dependency but is not in %s", modulePath, manifest.ManifestFileName))
+		}
+	}
+
+	if len(problems) > 0 {
+		// A module required by several others may report the same problem
+		sort.Strings(problems)
+		unique := problems[:1]
+		for _, problem := range problems[1:] {
+			if problem != unique[len(unique)-1] {
+				unique = append(unique, problem)
+			}
+		}
+		return fmt.Errorf("%s is out of date and cannot be updated in frozen mode; run 'cpkg tidy' without --frozen:\n  %s",
+			lockfile.LockfileName, strings.Join(unique, "\n  "))
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/SCKelemen/clix"
 	"github.com/SCKelemen/cpkg/internal/format"
@@ -11,23 +13,74 @@ import (
 	"github.com/SCKelemen/cpkg/internal/manifest"
 )
 
+var (
+	graphConstraints bool
+	graphDuplicates  bool
+	graphDepth       int
+)
+
 type graphOutput struct {
-	Module      string            `json:"module" yaml:"module"`
-	Dependencies []graphDependency `json:"dependencies" yaml:"dependencies"`
+	Module       string             `json:"module" yaml:"module"`
+	Requires     []graphRequirement `json:"requires,omitempty" yaml:"requires,omitempty"`
+	Dependencies []graphDependency  `json:"dependencies" yaml:"dependencies"`
 }
 
 type graphDependency struct {
-	Module  string `json:"module" yaml:"module"`
-	Version string `json:"version" yaml:"version"`
+	Module    string             `json:"module" yaml:"module"`
+	Version   string             `json:"version" yaml:"version"`
+	Indirect  bool               `json:"indirect,omitempty" yaml:"indirect,omitempty"`
+	Duplicate bool               `json:"duplicate,omitempty" yaml:"duplicate,omitempty"` // Its repository is locked at several commits
+	Requires  []graphRequirement `json:"requires,omitempty" yaml:"requires,omitempty"`
+}
+
+type graphRequirement struct {
+	Module     string `json:"module" yaml:"module"`
+	Constraint string `json:"constraint,omitempty" yaml:"constraint,omitempty"` // With --constraints
 }
 
 var graphCmd = clix.NewCommand("graph",
 	clix.WithCommandShort("Display the dependency graph"),
+	clix.WithCommandLong("Display the dependency graph from lock.cpkg.yaml as a tree, or export it with --format dot, mermaid or graphml"),
 	clix.WithCommandRun(func(ctx *clix.Context) error {
 		return runGraph(ctx)
 	}),
 )
 
+func init() {
+	graphCmd.Flags = clix.NewFlagSet("graph")
+	graphCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "constraints",
+			Usage: "Label each edge with the version constraint it requires",
+		},
+		Value: &graphConstraints,
+	})
+	graphCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "duplicates",
+			Usage: "Highlight modules whose repository is locked at more than one commit",
+		},
+		Value: &graphDuplicates,
+	})
+	graphCmd.Flags.IntVar(clix.IntVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "depth",
+			Usage: "Only show dependencies up to this many levels below the module (default: 0, unlimited)",
+		},
+		Value: &graphDepth,
+	})
+}
+
+// dependencyGraph is the graph of a lockfile: the root module's direct
+// dependencies and, for each locked module, the modules it requires.
+type dependencyGraph struct {
+	lock        *lockfile.Lockfile
+	edges       map[string][]graphRequirement // Module -> requirements, sorted by module
+	depths      map[string]int                // Module -> shortest distance from the root
+	duplicates  map[string]int                // Module -> number of commits its repository is locked at
+	constraints bool
+}
+
 func runGraph(ctx *clix.Context) error {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -39,56 +92,255 @@ func runGraph(ctx *clix.Context) error {
 		return fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
 	}
 
+	m, err := manifest.Load(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
+	}
+
 	lockfilePath := filepath.Join(filepath.Dir(manifestPath), lockfile.LockfileName)
 	lock, err := lockfile.Load(lockfilePath)
 	if err != nil {
 		return fmt.Errorf("no lockfile found, run 'cpkg tidy' first: %w", err)
 	}
 
+	if graphDepth < 0 {
+		return fmt.Errorf("--depth must not be negative")
+	}
+
+	g := newDependencyGraph(m, lock)
+	g.constraints = graphConstraints
+	if !graphDuplicates {
+		g.duplicates = nil
+	}
+
 	outputFormat := GetFormat()
+	switch {
+	case outputFormat.IsGraph():
+		return format.Write(ctx.App.Out, outputFormat, g.export(graphDepth))
+	case outputFormat != format.FormatText:
+		return format.Write(ctx.App.Out, outputFormat, g.output(graphDepth))
+	}
+
+	// Text output
+	fmt.Fprintf(ctx.App.Out, "%s\n", lock.Module)
+	g.writeTree(ctx.App.Out, lock.Module, "", 1, graphDepth, make(map[string]bool))
+	return nil
+}
 
-	// Get sorted list of dependencies
-	deps := make([]string, 0, len(lock.Dependencies))
+// newDependencyGraph builds the graph of lock. The root module requires its
+// direct dependencies, with the constraints of m. Lockfiles written before
+// requirements were recorded have no edges between dependencies; modules not
+// reachable from the root are attached to it, so that none is left out.
+func newDependencyGraph(m *manifest.Manifest, lock *lockfile.Lockfile) *dependencyGraph {
+	g := &dependencyGraph{
+		lock:       lock,
+		edges:      make(map[string][]graphRequirement),
+		depths:     make(map[string]int),
+		duplicates: make(map[string]int),
+	}
+
+	modules := make([]string, 0, len(lock.Dependencies))
 	for module := range lock.Dependencies {
-		deps = append(deps, module)
+		modules = append(modules, module)
 	}
+	sort.Strings(modules)
 
-	// Simple alphabetical sort for now
-	for i := 0; i < len(deps); i++ {
-		for j := i + 1; j < len(deps); j++ {
-			if deps[i] > deps[j] {
-				deps[i], deps[j] = deps[j], deps[i]
+	for _, module := range modules {
+		dep := lock.Dependencies[module]
+		if !dep.Indirect {
+			g.edges[lock.Module] = append(g.edges[lock.Module], graphRequirement{
+				Module:     module,
+				Constraint: m.Dependencies[module].Constraint(),
+			})
+		}
+		required := make([]string, 0, len(dep.Requires))
+		for r := range dep.Requires {
+			if _, ok := lock.Dependencies[r]; ok {
+				required = append(required, r)
 			}
 		}
+		sort.Strings(required)
+		for _, r := range required {
+			g.edges[module] = append(g.edges[module], graphRequirement{Module: r, Constraint: dep.Requires[r]})
+		}
 	}
 
-	graphDeps := make([]graphDependency, 0, len(deps))
-	for _, module := range deps {
-		dep := lock.Dependencies[module]
-		graphDeps = append(graphDeps, graphDependency{
-			Module:  module,
-			Version: dep.Version,
-		})
+	g.computeDepths()
+	for _, module := range modules {
+		if _, ok := g.depths[module]; !ok {
+			g.edges[lock.Module] = append(g.edges[lock.Module], graphRequirement{Module: module})
+		}
 	}
+	sort.Slice(g.edges[lock.Module], func(i, j int) bool {
+		return g.edges[lock.Module][i].Module < g.edges[lock.Module][j].Module
+	})
+	g.computeDepths()
 
-	// Output in requested format
-	if outputFormat != format.FormatText {
-		output := graphOutput{
-			Module:       lock.Module,
-			Dependencies: graphDeps,
+	// Modules of one repository locked at different commits are checked out
+	// several times, and may not work together
+	commits := make(map[string]map[string]bool) // Repository -> commits
+	for _, dep := range lock.Dependencies {
+		if dep.RepoURL == "" || dep.IsLocal() {
+			continue
 		}
-		return format.Write(ctx.App.Out, outputFormat, output)
+		commit := dep.Commit
+		if commit == "" {
+			commit = dep.Version
+		}
+		if commits[dep.RepoURL] == nil {
+			commits[dep.RepoURL] = make(map[string]bool)
+		}
+		commits[dep.RepoURL][commit] = true
 	}
+	for module, dep := range lock.Dependencies {
+		if n := len(commits[dep.RepoURL]); n > 1 && !dep.IsLocal() {
+			g.duplicates[module] = n
+		}
+	}
+	return g
+}
 
-	// Text output
-	fmt.Fprintf(ctx.App.Out, "%s\n", lock.Module)
-	for i, dep := range graphDeps {
-		prefix := "├─"
-		if i == len(graphDeps)-1 {
-			prefix = "└─"
+// computeDepths sets the distance of every module reachable from the root.
+func (g *dependencyGraph) computeDepths() {
+	g.depths = map[string]int{g.lock.Module: 0}
+	queue := []string{g.lock.Module}
+	for len(queue) > 0 {
+		module := queue[0]
+		queue = queue[1:]
+		for _, r := range g.edges[module] {
+			if _, ok := g.depths[r.Module]; !ok {
+				g.depths[r.Module] = g.depths[module] + 1
+				queue = append(queue, r.Module)
+			}
+		}
+	}
+}
+
+// included reports whether module is shown with the depth limit (0 for none).
+func (g *dependencyGraph) included(module string, depth int) bool {
+	d, ok := g.depths[module]
+	return ok && (depth == 0 || d <= depth)
+}
+
+// expanded reports whether the requirements of module are shown with the
+// depth limit.
+func (g *dependencyGraph) expanded(module string, depth int) bool {
+	d, ok := g.depths[module]
+	return ok && (depth == 0 || d < depth)
+}
+
+// requirements returns the requirements of module as shown, with
+// constraints only if they were asked for.
+func (g *dependencyGraph) requirements(module string) []graphRequirement {
+	requirements := make([]graphRequirement, 0, len(g.edges[module]))
+	for _, r := range g.edges[module] {
+		if !g.constraints {
+			r.Constraint = ""
 		}
-		fmt.Fprintf(ctx.App.Out, "%s %s %s\n", prefix, dep.Module, dep.Version)
+		requirements = append(requirements, r)
 	}
+	return requirements
+}
 
-	return nil
+// version returns the locked version of module for display, with its
+// replacement if it is replaced.
+func (g *dependencyGraph) version(module string) string {
+	dep := g.lock.Dependencies[module]
+	if dep.Replace != "" {
+		if dep.Version == "" {
+			return "=> " + dep.Replace
+		}
+		return dep.Version + " => " + dep.Replace
+	}
+	return dep.Version
+}
+
+// modules returns the modules shown with the depth limit, in order.
+func (g *dependencyGraph) modules(depth int) []string {
+	var modules []string
+	for module := range g.lock.Dependencies {
+		if g.included(module, depth) {
+			modules = append(modules, module)
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// output returns the graph as a graphOutput, for JSON and YAML.
+func (g *dependencyGraph) output(depth int) graphOutput {
+	output := graphOutput{
+		Module:       g.lock.Module,
+		Requires:     g.requirements(g.lock.Module),
+		Dependencies: []graphDependency{},
+	}
+	for _, module := range g.modules(depth) {
+		dep := graphDependency{
+			Module:    module,
+			Version:   g.version(module),
+			Indirect:  g.lock.Dependencies[module].Indirect,
+			Duplicate: g.duplicates[module] > 0,
+		}
+		if g.expanded(module, depth) {
+			dep.Requires = g.requirements(module)
+		}
+		output.Dependencies = append(output.Dependencies, dep)
+	}
+	return output
+}
+
+// export returns the graph for the graph formats.
+func (g *dependencyGraph) export(depth int) format.Graph {
+	out := format.Graph{
+		Name:  g.lock.Module,
+		Nodes: []format.GraphNode{{ID: g.lock.Module, Label: g.lock.Module}},
+	}
+	for _, r := range g.requirements(g.lock.Module) {
+		out.Edges = append(out.Edges, format.GraphEdge{From: g.lock.Module, To: r.Module, Label: r.Constraint})
+	}
+	for _, module := range g.modules(depth) {
+		out.Nodes = append(out.Nodes, format.GraphNode{
+			ID:        module,
+			Label:     module + "\n" + g.version(module),
+			Highlight: g.duplicates[module] > 0,
+		})
+		if !g.expanded(module, depth) {
+			continue
+		}
+		for _, r := range g.requirements(module) {
+			out.Edges = append(out.Edges, format.GraphEdge{From: module, To: r.Module, Label: r.Constraint})
+		}
+	}
+	return out
+}
+
+// writeTree writes the requirements of module, at the given level below the
+// root, as a tree. A module already shown with its requirements is marked
+// (*) instead of being expanded again.
+func (g *dependencyGraph) writeTree(w io.Writer, module, indent string, level, depth int, shown map[string]bool) {
+	shown[module] = true
+	requirements := g.requirements(module)
+	for i, r := range requirements {
+		prefix, childIndent := "├─", indent+"│  "
+		if i == len(requirements)-1 {
+			prefix, childIndent = "└─", indent+"   "
+		}
+		line := fmt.Sprintf("%s%s %s %s", indent, prefix, r.Module, g.version(r.Module))
+		if r.Constraint != "" {
+			line += fmt.Sprintf(" (%s)", r.Constraint)
+		}
+		if n := g.duplicates[r.Module]; n > 0 {
+			line += fmt.Sprintf(" [repository locked at %d commits]", n)
+		}
+
+		expand := depth == 0 || level < depth
+		if shown[r.Module] && len(g.edges[r.Module]) > 0 && expand {
+			line += " (*)"
+			expand = false
+		}
+		fmt.Fprintln(w, line)
+		if expand {
+			g.writeTree(w, r.Module, childIndent, level+1, depth, shown)
+		}
+	}
 }
@@ -89,7 +89,11 @@ func runInit(ctx *clix.Context) error {
 		Dependencies: make(map[string]manifest.Dependency),
 	}
 
-	if err := manifest.Save(m, manifestPath); err != nil {
+	doc, err := manifest.NewDocument(m)
+	if err != nil {
+		return err
+	}
+	if err := doc.Save(manifestPath); err != nil {
 		return fmt.Errorf("failed to save manifest: %w", err)
 	}
 
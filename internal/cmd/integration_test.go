@@ -110,9 +110,7 @@ func TestManifestLockfileConsistency(t *testing.T) {
 	}
 
 	manifestPath := filepath.Join(tmpDir, manifest.ManifestFileName)
-	if err := manifest.Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Verify manifest can be loaded
 	loaded, err := manifest.Load(manifestPath)
@@ -56,7 +56,7 @@ func runList(ctx *clix.Context) error {
 
 	// Collect dependency information
 	for modulePath, dep := range m.Dependencies {
-		constraint := dep.Version
+		constraint := dep.Constraint()
 		lockedVersion := ""
 		status := ""
 
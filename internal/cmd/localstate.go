@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/submodule"
+)
+
+// localState is the on-disk state of a locked dependency. It is read without
+// network access.
+type localState struct {
+	Dir           string // Absolute submodule checkout, or local replacement directory
+	SourceDir     string // Dir plus the module's subdirectory: the files covered by Sum
+	Exists        bool   // The submodule is registered, or the local directory exists
+	CurrentCommit string // Checked-out commit; empty for local replacements or if unreadable
+	InSync        bool   // CurrentCommit is the locked commit
+	Dirty         bool   // The checkout has uncommitted changes or untracked files
+}
+
+// inspectLocalState reads the on-disk state of dep. Relative lockfile paths
+// are resolved against projectRoot (the directory containing cpkg.yaml);
+// submodules are looked up in the .gitmodules at the top of its work tree.
+func inspectLocalState(projectRoot string, dep lockfile.Dependency) localState {
+	dir := dep.Path
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(projectRoot, dir)
+	}
+
+	if dep.IsLocal() {
+		_, err := os.Stat(dir)
+		return localState{Dir: dir, SourceDir: dir, Exists: err == nil}
+	}
+
+	// Resolve symlinks (important for macOS)
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	state := localState{
+		Dir:       dir,
+		SourceDir: filepath.Join(dir, dep.Subdir),
+	}
+	// .gitmodules paths are relative to the top of the work tree
+	if top, err := submodule.Toplevel(projectRoot); err == nil {
+		if realTop, err := filepath.EvalSymlinks(top); err == nil {
+			top = realTop
+		}
+		if rel, err := filepath.Rel(top, dir); err == nil {
+			state.Exists = submodule.SubmoduleExists(top, rel)
+		}
+	}
+	if !state.Exists {
+		return state
+	}
+
+	if currentCommit, err := submodule.GetSubmoduleCommit(dir); err == nil {
+		state.CurrentCommit = currentCommit
+		state.InSync = currentCommit == dep.Commit
+		state.Dirty, _ = submodule.IsSubmoduleDirty(dir)
+	}
+	return state
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/format"
+	"github.com/SCKelemen/cpkg/internal/git"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+)
+
+var lockCmd = clix.NewGroup("lock", "Inspect lock.cpkg.yaml",
+	lockDiffCmd,
+)
+
+var lockDiffCmd = clix.NewCommand("diff",
+	clix.WithCommandShort("Compare two versions of lock.cpkg.yaml"),
+	clix.WithCommandLong("Compare two lockfiles, each a file or a git revision of lock.cpkg.yaml, and report added, removed, upgraded and downgraded modules. Without arguments, compares HEAD with the working tree; with one, compares it with the working tree"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runLockDiff(ctx)
+	}),
+)
+
+type lockDiffOutput struct {
+	Old     string            `json:"old" yaml:"old"`
+	New     string            `json:"new" yaml:"new"`
+	Changes []lockfile.Change `json:"changes" yaml:"changes"`
+}
+
+func runLockDiff(ctx *clix.Context) error {
+	if len(ctx.Args) > 2 {
+		return fmt.Errorf("expected at most two lockfiles to compare, got %d", len(ctx.Args))
+	}
+	oldArg, newArg := "HEAD", ""
+	if len(ctx.Args) > 0 {
+		oldArg = ctx.Args[0]
+	}
+	if len(ctx.Args) > 1 {
+		newArg = ctx.Args[1]
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	oldLock, oldLabel, err := loadLockfileArg(cwd, oldArg)
+	if err != nil {
+		return err
+	}
+	newLock, newLabel, err := loadLockfileArg(cwd, newArg)
+	if err != nil {
+		return err
+	}
+
+	output := lockDiffOutput{
+		Old:     oldLabel,
+		New:     newLabel,
+		Changes: lockfile.Diff(oldLock, newLock),
+	}
+
+	outputFormat := GetFormat()
+	if outputFormat != format.FormatText {
+		return format.Write(ctx.App.Out, outputFormat, output)
+	}
+
+	// Text output
+	if len(output.Changes) == 0 {
+		fmt.Fprintf(ctx.App.Out, "No changes from %s to %s\n", output.Old, output.New)
+		return nil
+	}
+	fmt.Fprintf(ctx.App.Out, "Changes from %s to %s:\n\n", output.Old, output.New)
+	for _, c := range output.Changes {
+		var line string
+		switch c.Change {
+		case lockfile.ChangeAdded:
+			line = fmt.Sprintf("+ %s %s", c.Module, c.NewVersion)
+		case lockfile.ChangeRemoved:
+			line = fmt.Sprintf("- %s %s", c.Module, c.OldVersion)
+		case lockfile.ChangeUpgraded:
+			line = fmt.Sprintf("↑ %s %s → %s", c.Module, c.OldVersion, c.NewVersion)
+		case lockfile.ChangeDowngraded:
+			line = fmt.Sprintf("↓ %s %s → %s", c.Module, c.OldVersion, c.NewVersion)
+		default:
+			line = fmt.Sprintf("~ %s %s", c.Module, versionChange(c))
+		}
+		if notes := changeNotes(c); len(notes) > 0 {
+			line += " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Fprintln(ctx.App.Out, line)
+		if c.CommitChanged() {
+			fmt.Fprintf(ctx.App.Out, "    commits: %s..%s\n", shortCommit(c.OldCommit), shortCommit(c.NewCommit))
+		}
+		if c.RepoURLChanged() {
+			fmt.Fprintf(ctx.App.Out, "    repository: %s → %s\n", c.OldRepoURL, c.NewRepoURL)
+		}
+		if c.OldReplace != c.NewReplace && c.Change != lockfile.ChangeAdded && c.Change != lockfile.ChangeRemoved {
+			fmt.Fprintf(ctx.App.Out, "    replace: %s → %s\n", orNone(c.OldReplace), orNone(c.NewReplace))
+		}
+	}
+	fmt.Fprintf(ctx.App.Out, "\n%s\n", changeSummary(output.Changes))
+	return nil
+}
+
+// loadLockfileArg loads the lockfile named by an argument of cpkg lock diff:
+// a file, a git revision of the project's lock.cpkg.yaml, or, if arg is
+// empty, the project's lock.cpkg.yaml in the working tree. It also returns
+// the lockfile's name for the report. A revision without a lockfile has no
+// dependencies, so that the lockfile's first commit can be reviewed too.
+func loadLockfileArg(cwd, arg string) (*lockfile.Lockfile, string, error) {
+	if arg != "" {
+		if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+			lock, err := lockfile.Load(arg)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to load %s: %w", arg, err)
+			}
+			return lock, arg, nil
+		}
+	}
+
+	manifestPath, err := manifest.FindManifest(cwd)
+	if err != nil {
+		return nil, "", fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
+	}
+	projectRoot := filepath.Dir(manifestPath)
+
+	if arg == "" {
+		lockfilePath := filepath.Join(projectRoot, lockfile.LockfileName)
+		label, err := filepath.Rel(cwd, lockfilePath)
+		if err != nil {
+			label = lockfilePath
+		}
+		lock, err := lockfile.Load(lockfilePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load %s: %w", label, err)
+		}
+		return lock, label, nil
+	}
+
+	data, err := git.ShowFile(projectRoot, arg, lockfile.LockfileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return &lockfile.Lockfile{}, arg, nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s is neither a lockfile nor a git revision: %w", arg, err)
+	}
+	lock, err := lockfile.Parse(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load %s at %s: %w", lockfile.LockfileName, arg, err)
+	}
+	return lock, arg, nil
+}
+
+// versionChange describes the version of a changed module, which may be the
+// same on both sides.
+func versionChange(c lockfile.Change) string {
+	if c.OldVersion == c.NewVersion {
+		return c.NewVersion
+	}
+	return c.OldVersion + " → " + c.NewVersion
+}
+
+// changeNotes returns the level of an upgrade or downgrade and whether the
+// module is an indirect dependency.
+func changeNotes(c lockfile.Change) []string {
+	var notes []string
+	if c.Level != "" {
+		notes = append(notes, c.Level)
+	}
+	if c.Indirect {
+		notes = append(notes, "indirect")
+	}
+	return notes
+}
+
+// changeSummary counts the changes by kind, e.g. "2 upgraded, 1 added".
+func changeSummary(changes []lockfile.Change) string {
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Change]++
+	}
+	var parts []string
+	for _, change := range []string{
+		lockfile.ChangeUpgraded,
+		lockfile.ChangeDowngraded,
+		lockfile.ChangeAdded,
+		lockfile.ChangeRemoved,
+		lockfile.ChangeChanged,
+	} {
+		if counts[change] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[change], change))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+// WriteMarkdown writes the diff as a table for a pull request description,
+// linking commit ranges of GitHub repositories to their comparison.
+func (o lockDiffOutput) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#### `%s` changes (%s → %s)\n\n", lockfile.LockfileName, o.Old, o.New)
+	if len(o.Changes) == 0 {
+		b.WriteString("No dependency changes.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	b.WriteString("| Module | Change | Version | Commits |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	var moved, replaced []string
+	for _, c := range o.Changes {
+		change := c.Change
+		if notes := changeNotes(c); len(notes) > 0 {
+			change += " (" + strings.Join(notes, ", ") + ")"
+		}
+
+		var version, commits string
+		switch c.Change {
+		case lockfile.ChangeAdded:
+			version, commits = c.NewVersion, markdownCode(shortCommit(c.NewCommit))
+		case lockfile.ChangeRemoved:
+			version, commits = c.OldVersion, markdownCode(shortCommit(c.OldCommit))
+		default:
+			version = versionChange(c)
+			if c.CommitChanged() {
+				commits = fmt.Sprintf("`%s..%s`", shortCommit(c.OldCommit), shortCommit(c.NewCommit))
+				if url := compareURL(c); url != "" {
+					commits = fmt.Sprintf("[%s](%s)", commits, url)
+				}
+			}
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", c.Module, change, version, commits)
+
+		if c.RepoURLChanged() {
+			moved = append(moved, fmt.Sprintf("- `%s`: %s → %s\n", c.Module, c.OldRepoURL, c.NewRepoURL))
+		}
+		if c.OldReplace != c.NewReplace && c.Change != lockfile.ChangeAdded && c.Change != lockfile.ChangeRemoved {
+			replaced = append(replaced, fmt.Sprintf("- `%s`: %s → %s\n", c.Module, orNone(c.OldReplace), orNone(c.NewReplace)))
+		}
+	}
+	if len(moved) > 0 {
+		b.WriteString("\n**Repository changes**\n\n")
+		b.WriteString(strings.Join(moved, ""))
+	}
+	if len(replaced) > 0 {
+		b.WriteString("\n**Replacement changes**\n\n")
+		b.WriteString(strings.Join(replaced, ""))
+	}
+	fmt.Fprintf(&b, "\n%s\n", changeSummary(o.Changes))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + s + "`"
+}
+
+// compareURL returns the GitHub page comparing the old and new commits of a
+// module that stayed in the same GitHub repository, or an empty string.
+func compareURL(c lockfile.Change) string {
+	if c.OldRepoURL != c.NewRepoURL {
+		return ""
+	}
+	repo := strings.TrimSuffix(c.NewRepoURL, ".git")
+	switch {
+	case strings.HasPrefix(repo, "https://github.com/"):
+	case strings.HasPrefix(repo, "git@github.com:"):
+		repo = "https://github.com/" + strings.TrimPrefix(repo, "git@github.com:")
+	default:
+		return ""
+	}
+	return fmt.Sprintf("%s/compare/%s...%s", repo, c.OldCommit, c.NewCommit)
+}
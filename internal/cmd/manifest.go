@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/format"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+)
+
+var manifestSchemaLockfile bool
+
+var manifestCmd = clix.NewGroup("manifest", "Inspect and check cpkg.yaml",
+	manifestValidateCmd,
+	manifestSchemaCmd,
+)
+
+var manifestValidateCmd = clix.NewCommand("validate",
+	clix.WithCommandShort("Check cpkg.yaml against the manifest schema"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runManifestValidate(ctx)
+	}),
+)
+
+var manifestSchemaCmd = clix.NewCommand("schema",
+	clix.WithCommandShort("Print the JSON Schema of cpkg.yaml"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runManifestSchema(ctx)
+	}),
+)
+
+func init() {
+	manifestSchemaCmd.Flags = clix.NewFlagSet("schema")
+	manifestSchemaCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "lockfile",
+			Usage: "Print the JSON Schema of lock.cpkg.yaml instead",
+		},
+		Value: &manifestSchemaLockfile,
+	})
+}
+
+type manifestValidateOutput struct {
+	File     string             `json:"file" yaml:"file"`
+	Valid    bool               `json:"valid" yaml:"valid"`
+	Problems []manifest.Problem `json:"problems" yaml:"problems"`
+}
+
+func runManifestValidate(ctx *clix.Context) error {
+	var manifestPath string
+	switch len(ctx.Args) {
+	case 0:
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %w", err)
+		}
+		manifestPath, err = manifest.FindManifest(cwd)
+		if err != nil {
+			return fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
+		}
+	case 1:
+		manifestPath = ctx.Args[0]
+	default:
+		return fmt.Errorf("expected at most one manifest path")
+	}
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	problems, err := manifest.Validate(data)
+	if err != nil {
+		return err
+	}
+	errs := manifest.Errors(problems)
+
+	output := manifestValidateOutput{File: manifestPath, Valid: len(errs) == 0, Problems: problems}
+	if outputFormat := GetFormat(); outputFormat != format.FormatText {
+		if output.Problems == nil {
+			output.Problems = []manifest.Problem{}
+		}
+		if err := format.Write(ctx.App.Out, outputFormat, output); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range problems {
+			fmt.Fprintf(ctx.App.Out, "%s:%s\n", manifestPath, p)
+		}
+		if len(errs) == 0 {
+			fmt.Fprintf(ctx.App.Out, "%s is valid", manifestPath)
+			if warnings := len(problems); warnings > 0 {
+				fmt.Fprintf(ctx.App.Out, " (%d warnings)", warnings)
+			}
+			fmt.Fprintln(ctx.App.Out)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s has %d errors", manifestPath, len(errs))
+	}
+	return nil
+}
+
+func runManifestSchema(ctx *clix.Context) error {
+	schema := manifest.JSONSchema
+	if manifestSchemaLockfile {
+		schema = lockfile.JSONSchema
+	}
+	_, err := ctx.App.Out.Write(schema)
+	return err
+}
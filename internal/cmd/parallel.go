@@ -0,0 +1,46 @@
+package cmd
+
+import "sync"
+
+// defaultJobs is the number of concurrent git operations when --jobs is not
+// given. Most of them wait on the network, so this exceeds the CPU count of
+// a typical machine on purpose.
+const defaultJobs = 8
+
+// jobs returns the maximum number of concurrent git operations.
+func jobs() int {
+	if GlobalJobsFlag > 0 {
+		return GlobalJobsFlag
+	}
+	return defaultJobs
+}
+
+// forEachParallel calls fn(i) for every i in [0, n) on at most jobs()
+// goroutines and waits for all of them. It returns the error of the lowest
+// index that failed, so the result does not depend on scheduling.
+func forEachParallel(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs() && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
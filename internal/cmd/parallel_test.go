@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEachParallel(t *testing.T) {
+	GlobalJobsFlag = 3
+	defer func() { GlobalJobsFlag = 0 }()
+
+	var running, peak int32
+	err := forEachParallel(20, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if i == 7 || i == 12 {
+			return fmt.Errorf("job %d failed", i)
+		}
+		return nil
+	})
+
+	if peak > 3 {
+		t.Errorf("%d jobs ran concurrently, want at most 3", peak)
+	}
+	if err == nil || err.Error() != "job 7 failed" {
+		t.Errorf("forEachParallel() error = %v, want the lowest failing job", err)
+	}
+}
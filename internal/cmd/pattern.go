@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/format"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+)
+
+// patternOutput is the report of a command run with a ./... pattern: one
+// section per module, holding what the command would have printed for that
+// module on its own.
+type patternOutput struct {
+	Modules []patternModule `json:"modules" yaml:"modules"`
+	Failed  int             `json:"failed" yaml:"failed"`
+}
+
+type patternModule struct {
+	Dir    string      `json:"dir" yaml:"dir"`
+	Module string      `json:"module" yaml:"module"`
+	Result interface{} `json:"result,omitempty" yaml:"result,omitempty"` // The command's structured output
+	Output string      `json:"output,omitempty" yaml:"output,omitempty"` // The command's output, if it is not structured
+	Error  string      `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// foundModule is a module found by findModules.
+type foundModule struct {
+	Dir    string
+	Module string
+}
+
+// patternDirs returns the directories of the ./... patterns in args, e.g.
+// "." for "./..." and "libs" for "libs/...". It reports false if args holds
+// no pattern; mixing patterns with other arguments is an error.
+func patternDirs(args []string) ([]string, bool, error) {
+	var dirs []string
+	for _, arg := range args {
+		if arg != "..." && !strings.HasSuffix(arg, "/...") {
+			continue
+		}
+		dir := strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/")
+		if dir == "" {
+			dir = "."
+		}
+		dirs = append(dirs, filepath.FromSlash(dir))
+	}
+	if len(dirs) == 0 {
+		return nil, false, nil
+	}
+	if len(dirs) != len(args) {
+		return nil, true, fmt.Errorf("cannot mix ./... patterns with other arguments")
+	}
+	return dirs, true, nil
+}
+
+// findModules returns the modules in dir and the directories below it,
+// sorted by directory. Hidden directories, git submodules and other nested
+// repositories, and the dependency and vendor directories of the modules
+// found are not searched, so checkouts of dependencies are never mistaken
+// for modules of the project.
+func findModules(dir string) ([]foundModule, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	var modules []foundModule
+	skip := make(map[string]bool)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root {
+			if strings.HasPrefix(d.Name(), ".") || skip[path] {
+				return filepath.SkipDir
+			}
+			if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+
+		data, err := os.ReadFile(filepath.Join(path, manifest.ManifestFileName))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Invalid manifests are reported when the command loads them
+		module := foundModule{Dir: path}
+		depRoot := manifest.DefaultDepRoot()
+		if m, err := manifest.Parse(data); err == nil {
+			module.Module = m.Module
+			depRoot = m.DepRoot
+		}
+		skip[filepath.Join(path, depRoot)] = true
+		skip[filepath.Join(path, "vendor")] = true
+		modules = append(modules, module)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", dir, err)
+	}
+	return modules, nil
+}
+
+// runPattern runs run in every module matched by the ./... patterns dirs and
+// writes a single report. In text, each module's output follows a heading;
+// with --format, the structured output of each module becomes a section of
+// a patternOutput. Failures do not stop the remaining modules, but make the
+// command fail once all have run.
+func runPattern(ctx *clix.Context, dirs []string, run func(ctx *clix.Context) error) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	var modules []foundModule
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		found, err := findModules(dir)
+		if err != nil {
+			return err
+		}
+		for _, module := range found {
+			if !seen[module.Dir] {
+				seen[module.Dir] = true
+				modules = append(modules, module)
+			}
+		}
+	}
+	if len(modules) == 0 {
+		return fmt.Errorf("no %s found in %s", manifest.ManifestFileName, strings.Join(dirs, ", "))
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Dir < modules[j].Dir })
+
+	// Each module is run as if the command had been run in its directory
+	args, out := ctx.Args, ctx.App.Out
+	defer func() {
+		ctx.Args, ctx.App.Out = args, out
+		os.Chdir(cwd)
+	}()
+	ctx.Args = nil
+
+	outputFormat := GetFormat()
+	report := patternOutput{Modules: make([]patternModule, 0, len(modules))}
+	for i, module := range modules {
+		dir, err := filepath.Rel(cwd, module.Dir)
+		if err != nil {
+			dir = module.Dir
+		}
+		section := patternModule{Dir: dir, Module: module.Module}
+
+		var buf bytes.Buffer
+		if outputFormat == format.FormatText {
+			writeModuleHeading(out, i, dir)
+			ctx.App.Out = out
+		} else {
+			ctx.App.Out = &buf
+		}
+
+		if err := os.Chdir(module.Dir); err != nil {
+			return fmt.Errorf("failed to enter %s: %w", dir, err)
+		}
+		if err := run(ctx); err != nil {
+			section.Error = err.Error()
+			report.Failed++
+			if outputFormat == format.FormatText {
+				fmt.Fprintf(ctx.App.Err, "✗ %s: %v\n", dir, err)
+			}
+		}
+
+		if buf.Len() > 0 {
+			if result, err := format.Decode(outputFormat, buf.Bytes()); err == nil {
+				section.Result = result
+			} else {
+				section.Output = buf.String()
+			}
+		}
+		report.Modules = append(report.Modules, section)
+	}
+
+	if outputFormat != format.FormatText {
+		if err := format.Write(out, outputFormat, report); err != nil {
+			return err
+		}
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("failed in %d of %d modules", report.Failed, len(modules))
+	}
+	return nil
+}
+
+// writeModuleHeading starts the output of the i-th module of several.
+func writeModuleHeading(w io.Writer, i int, dir string) {
+	if i > 0 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "==> %s\n", dir)
+}
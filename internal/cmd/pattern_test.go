@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SCKelemen/clix"
+)
+
+func TestPatternDirs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    []string
+		ok      bool
+		wantErr bool
+	}{
+		{nil, nil, false, false},
+		{[]string{"github.com/user/repo"}, nil, false, false},
+		{[]string{"./..."}, []string{"."}, true, false},
+		{[]string{"..."}, []string{"."}, true, false},
+		{[]string{"libs/...", "./app/..."}, []string{"libs", "./app"}, true, false},
+		{[]string{"libs/...", "app"}, nil, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			got, ok, err := patternDirs(tt.args)
+			if (err != nil) != tt.wantErr || ok != tt.ok {
+				t.Fatalf("patternDirs() = %v, %v, %v", got, ok, err)
+			}
+			var want []string
+			for _, dir := range tt.want {
+				want = append(want, filepath.FromSlash(dir))
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, want) {
+				t.Errorf("patternDirs() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStatusCommand_Pattern(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, data string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	write("cpkg.yaml", `apiVersion: cpkg.ringil.dev/v0
+kind: Module
+module: test/app
+depRoot: deps
+dependencies:
+  github.com/user/dep1:
+    version: ^1.0.0
+`)
+	write("lock.cpkg.yaml", `apiVersion: cpkg.ringil.dev/v0
+kind: Lockfile
+module: test/app
+depRoot: deps
+dependencies:
+  github.com/user/dep1:
+    version: v1.0.0
+    commit: 1234567890abcdef1234567890abcdef12345678
+    vcs: git
+    repoURL: https://github.com/user/dep1.git
+    path: deps/github.com/user/dep1
+    sourcePath: deps/github.com/user/dep1
+`)
+	write("libs/span/cpkg.yaml", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: test/span\n")
+	write("broken/cpkg.yaml", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\n")
+	// Not modules of the project: a dependency's checkout, a hidden directory
+	// and a nested repository
+	write("deps/github.com/user/dep1/cpkg.yaml", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: github.com/user/dep1\n")
+	write(".cache/cpkg.yaml", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: test/cache\n")
+	write("external/.git", "gitdir: ../.git/modules/external\n")
+	write("external/cpkg.yaml", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: test/external\n")
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	GlobalFormatFlag = "json"
+	defer func() { GlobalFormatFlag = "" }()
+
+	var buf bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}, Args: []string{"./..."}}
+	err := runStatus(ctx)
+	if err == nil || !strings.Contains(err.Error(), "failed in 1 of 3 modules") {
+		t.Errorf("runStatus() error = %v, want 1 of 3 modules failed", err)
+	}
+	if ctx.Args[0] != "./..." {
+		t.Errorf("ctx.Args = %v, want the pattern restored", ctx.Args)
+	}
+	if cwd, _ := os.Getwd(); cwd != root {
+		if real, _ := filepath.EvalSymlinks(root); cwd != real {
+			t.Errorf("current directory = %s, want %s restored", cwd, root)
+		}
+	}
+
+	var output struct {
+		Modules []struct {
+			Dir    string
+			Module string
+			Result *statusOutput
+			Error  string
+		}
+		Failed int
+	}
+	if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+		t.Fatalf("failed to parse JSON output: %v\n%s", err, buf.String())
+	}
+	var dirs []string
+	for _, module := range output.Modules {
+		dirs = append(dirs, module.Dir)
+	}
+	if want := []string{".", "broken", filepath.Join("libs", "span")}; !reflect.DeepEqual(dirs, want) {
+		t.Fatalf("modules = %v, want %v", dirs, want)
+	}
+	if output.Failed != 1 {
+		t.Errorf("failed = %d, want 1", output.Failed)
+	}
+
+	app := output.Modules[0]
+	if app.Module != "test/app" || app.Result == nil || len(app.Result.Dependencies) != 1 || app.Result.Dependencies[0].Status != "MISSING" {
+		t.Errorf("app section = %+v", app)
+	}
+	if broken := output.Modules[1]; broken.Error == "" || broken.Result != nil {
+		t.Errorf("broken section = %+v, want an error", broken)
+	}
+	if span := output.Modules[2]; span.Module != "test/span" || span.Result == nil || len(span.Result.Dependencies) != 0 {
+		t.Errorf("span section = %+v", span)
+	}
+
+	// Text output heads each module and reports failures as it goes
+	GlobalFormatFlag = ""
+	buf.Reset()
+	var errBuf bytes.Buffer
+	ctx = &clix.Context{App: &clix.App{Out: &buf, Err: &errBuf}, Args: []string{"libs/..."}}
+	if err := runStatus(ctx); err != nil {
+		t.Errorf("runStatus(libs/...) error = %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "==> "+filepath.Join("libs", "span")+"\n") || strings.Contains(buf.String(), "==> .") {
+		t.Errorf("output of libs/... =\n%s", buf.String())
+	}
+}
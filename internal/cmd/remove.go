@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+	"github.com/SCKelemen/cpkg/internal/submodule"
+)
+
+var removeKeepFiles bool
+
+var removeCmd = clix.NewCommand("remove",
+	clix.WithCommandShort("Remove dependencies and their submodules"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runRemove(ctx)
+	}),
+)
+
+func init() {
+	removeCmd.Flags = clix.NewFlagSet("remove")
+	removeCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "keep-files",
+			Usage: "Keep the submodules of removed dependencies",
+		},
+		Value: &removeKeepFiles,
+	})
+}
+
+func runRemove(ctx *clix.Context) error {
+	if len(ctx.Args) == 0 {
+		return fmt.Errorf("no modules specified")
+	}
+	if IsFrozen() {
+		return fmt.Errorf("cannot remove dependencies in frozen mode: %s would change", lockfile.LockfileName)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	manifestPath, err := manifest.FindManifest(cwd)
+	if err != nil {
+		return fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
+	}
+
+	doc, err := manifest.LoadDocument(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
+	}
+
+	removed := make(map[string]bool)
+	for _, arg := range ctx.Args {
+		module, version, err := parseModuleVersion(arg)
+		if err != nil || version != "" {
+			return fmt.Errorf("invalid module %q: expected a module path without a version", arg)
+		}
+		if !doc.RemoveDependency(module) {
+			return fmt.Errorf("%s is not a dependency in %s", module, manifest.ManifestFileName)
+		}
+		removed[module] = true
+	}
+
+	lockfilePath := filepath.Join(filepath.Dir(manifestPath), lockfile.LockfileName)
+	oldLock, err := lockfile.Load(lockfilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to load lockfile: %w", err)
+	}
+
+	// Resolve without the removed dependencies before saving anything, so a
+	// failure leaves cpkg.yaml and the lockfile as they were
+	m, err := doc.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
+	}
+	lock, err := tidyLockfile(m, filepath.Dir(manifestPath), "", false)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", lockfile.LockfileName, err)
+	}
+	if err := doc.Save(manifestPath); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+	if err := lockfile.Save(lock, lockfilePath); err != nil {
+		return fmt.Errorf("failed to save lockfile: %w", err)
+	}
+
+	// Report the modules given and the dependencies only they required
+	var unlocked []string
+	if oldLock != nil {
+		for module := range oldLock.Dependencies {
+			if _, ok := lock.Dependencies[module]; !ok {
+				unlocked = append(unlocked, module)
+			}
+		}
+	}
+	sort.Strings(unlocked)
+	for _, module := range ctx.Args {
+		if _, ok := lock.Dependencies[module]; ok {
+			fmt.Fprintf(ctx.App.Out, "- %s (still required by other dependencies)\n", module)
+		} else {
+			fmt.Fprintf(ctx.App.Out, "- %s\n", module)
+		}
+	}
+	for _, module := range unlocked {
+		if !removed[module] {
+			fmt.Fprintf(ctx.App.Out, "- %s (no longer required)\n", module)
+		}
+	}
+
+	return removeSubmodules(ctx, filepath.Dir(manifestPath), oldLock, unlocked)
+}
+
+// removeSubmodules removes the submodules of the given dependencies, which
+// are no longer locked, or only reports them with --keep-files.
+func removeSubmodules(ctx *clix.Context, projectRoot string, oldLock *lockfile.Lockfile, modules []string) error {
+	if len(modules) == 0 {
+		return nil
+	}
+	top, err := submodule.Toplevel(projectRoot)
+	if err != nil {
+		return err
+	}
+	submodules, err := submodule.ListSubmodules(top)
+	if err != nil {
+		return err
+	}
+
+	// Match submodules to dependencies by absolute path, as sync does
+	if realRoot, err := filepath.EvalSymlinks(projectRoot); err == nil {
+		projectRoot = realRoot
+	}
+	if realTop, err := filepath.EvalSymlinks(top); err == nil {
+		top = realTop
+	}
+	byPath := make(map[string]submodule.Submodule)
+	for _, sub := range submodules {
+		byPath[filepath.Join(top, filepath.FromSlash(sub.Path))] = sub
+	}
+
+	var failed []string
+	for _, module := range modules {
+		dep := oldLock.Dependencies[module]
+		if dep.IsLocal() {
+			continue
+		}
+		path := dep.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectRoot, path)
+		}
+		sub, ok := byPath[filepath.Clean(path)]
+		if !ok {
+			continue // Never synced
+		}
+
+		if removeKeepFiles {
+			fmt.Fprintf(ctx.App.Out, "Kept %s\n", sub.Path)
+			continue
+		}
+		if err := removeSubmodule(top, sub, path); err != nil {
+			fmt.Fprintf(ctx.App.Err, "✗ %s: %v\n", sub.Path, err)
+			failed = append(failed, sub.Path)
+			continue
+		}
+		fmt.Fprintf(ctx.App.Out, "Removed %s\n", sub.Path)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove %d submodules; remove them by hand or rerun with --keep-files", len(failed))
+	}
+	return nil
+}
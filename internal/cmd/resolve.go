@@ -1,174 +1,789 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/SCKelemen/cpkg/internal/dirhash"
 	"github.com/SCKelemen/cpkg/internal/git"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
 	"github.com/SCKelemen/cpkg/internal/modulepath"
+	"github.com/SCKelemen/cpkg/internal/resolver"
 	"github.com/SCKelemen/cpkg/internal/semver"
+	"github.com/SCKelemen/cpkg/internal/sums"
 )
 
-func resolveDependencies(m *manifest.Manifest, depRoot string) (*lockfile.Lockfile, error) {
-	lock := &lockfile.Lockfile{
-		APIVersion:  "cpkg.ringil.dev/v0",
-		Kind:        "Lockfile",
-		Module:      m.Module,
-		GeneratedBy: "cpkg 0.1.0",
-		DepRoot:     depRoot,
-		Dependencies: make(map[string]lockfile.Dependency),
+// moduleVersions holds the versions available for a module and the git tags
+// they map back to.
+type moduleVersions struct {
+	RepoURL  string
+	Subpath  string
+	Versions []string          // Version part of each tag (without subpath)
+	Tags     map[string]string // Version -> original tag
+
+	Retract       []string // Retract list from the manifest at the latest version
+	retractLoaded bool
+}
+
+// gitSource supplies module versions and requirements to the resolver from git
+// repositories. Refs, versions and commits are cached for the lifetime of the
+// source, so each repository is only queried once per resolution, and
+// repositories are queried concurrently (see Prefetch).
+//
+// The root manifest's replace and exclude directives are applied here: a
+// replaced module's versions and requirements come from its replacement module
+// or local directory, and excluded or retracted versions are never offered.
+type gitSource struct {
+	refs         *git.RefsCache
+	modules      map[string]*moduleVersions
+	commits      map[string]string             // module@version -> commit
+	pins         map[string]string             // module@kind:ref -> version
+	manifests    map[string]*manifest.Manifest // module@commit -> manifest (nil if none)
+	replacements []manifest.Replacement
+	exclusions   map[string][]string
+	projectRoot  string // Directory local replacement paths are relative to
+
+	// In a workspace, the workspace and its members; projectRoot is then the
+	// workspace root and the directives of every member apply (see
+	// newWorkspaceSource).
+	workspace *manifest.Workspace
+	members   []manifest.Member
+	// The manifest of the member being resolved and its directory. It is
+	// used instead of the cpkg.yaml on disk, which may not be saved yet.
+	member    *manifest.Manifest
+	memberDir string
+}
+
+// newGitSource returns a source for resolving the dependencies of m, whose
+// cpkg.yaml is in projectRoot. If the module is a member of a workspace, the
+// source covers the whole workspace, with m in place of the member's
+// cpkg.yaml on disk.
+func newGitSource(m *manifest.Manifest, projectRoot string) (*gitSource, error) {
+	ws, members, err := loadWorkspace(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	if ws != nil {
+		dir, _ := ws.MemberDir(projectRoot)
+		for i := range members {
+			if members[i].Dir == dir {
+				members[i].Manifest = m
+			}
+		}
+		src, err := newWorkspaceSource(ws, members)
+		if err != nil {
+			return nil, err
+		}
+		src.member, src.memberDir = m, filepath.Clean(projectRoot)
+		return src, nil
 	}
 
-	for modulePath, dep := range m.Dependencies {
-		// Parse module path to extract repo URL and subpath
-		mp, err := modulepath.ParseModulePath(modulePath)
+	replacements, err := m.Replacements()
+	if err != nil {
+		return nil, err
+	}
+	exclusions, err := m.Exclusions()
+	if err != nil {
+		return nil, err
+	}
+	return newSource(replacements, exclusions, projectRoot), nil
+}
+
+func newSource(replacements []manifest.Replacement, exclusions map[string][]string, projectRoot string) *gitSource {
+	return &gitSource{
+		refs:         git.NewRefsCache(),
+		modules:      make(map[string]*moduleVersions),
+		commits:      make(map[string]string),
+		pins:         make(map[string]string),
+		manifests:    make(map[string]*manifest.Manifest),
+		replacements: replacements,
+		exclusions:   exclusions,
+		projectRoot:  projectRoot,
+	}
+}
+
+// module returns the (cached) versions available for modulePath.
+func (s *gitSource) module(modulePath string) (*moduleVersions, error) {
+	if mv, ok := s.modules[modulePath]; ok {
+		return mv, nil
+	}
+	mv, err := listModuleVersions(s.refs, modulePath)
+	if err != nil {
+		return nil, err
+	}
+	s.modules[modulePath] = mv
+	return mv, nil
+}
+
+// Prefetch implements resolver.Prefetcher by listing the refs of the
+// repositories providing modules concurrently, on up to --jobs goroutines.
+// Modules sharing a repository are listed once. Errors are left for the
+// Versions call that needs the refs to report.
+func (s *gitSource) Prefetch(modules []string) {
+	seen := make(map[string]bool)
+	var repoURLs []string
+	for _, modulePath := range modules {
+		if r, ok := s.replacement(modulePath, ""); ok {
+			if r.IsLocal() {
+				continue
+			}
+			modulePath = r.New
+		}
+		repoURL, err := moduleRepoURL(modulePath)
+		if err != nil || seen[repoURL] {
+			continue
+		}
+		seen[repoURL] = true
+		repoURLs = append(repoURLs, repoURL)
+	}
+	forEachParallel(len(repoURLs), func(i int) error {
+		_, err := s.refs.Refs(repoURLs[i])
+		return err
+	})
+}
+
+// replacement returns the replace directive that applies to modulePath at version.
+func (s *gitSource) replacement(modulePath, version string) (manifest.Replacement, bool) {
+	return manifest.FindReplacement(s.replacements, modulePath, version)
+}
+
+// localPath returns dir, the directory of a local replacement relative to
+// the source's project root, relative to projectRoot instead, as it is
+// written to that module's lockfile.
+func (s *gitSource) localPath(dir, projectRoot string) string {
+	if filepath.IsAbs(dir) || projectRoot == s.projectRoot {
+		return dir
+	}
+	rel, err := filepath.Rel(projectRoot, filepath.Join(s.projectRoot, dir))
+	if err != nil {
+		return dir
+	}
+	if rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = "." + string(filepath.Separator) + rel
+	}
+	return rel
+}
+
+// replacementsFor returns the replace directives that apply to the module in
+// projectRoot, with local paths relative to it.
+func (s *gitSource) replacementsFor(projectRoot string) []manifest.Replacement {
+	replacements := make([]manifest.Replacement, len(s.replacements))
+	for i, r := range s.replacements {
+		if r.IsLocal() {
+			r.Dir = s.localPath(r.Dir, projectRoot)
+		}
+		replacements[i] = r
+	}
+	return replacements
+}
+
+// target returns the module and version whose repository provides modulePath
+// at version, after applying replace directives.
+func (s *gitSource) target(modulePath, version string) (string, string) {
+	r, ok := s.replacement(modulePath, version)
+	if !ok || r.IsLocal() {
+		return modulePath, version
+	}
+	if r.NewVersion != "" {
+		return r.New, r.NewVersion
+	}
+	return r.New, version
+}
+
+// Versions implements resolver.Source. A module replaced by another module
+// without a fixed version offers the replacement's versions. Versions excluded
+// by the root manifest or retracted by the module's author are left out.
+func (s *gitSource) Versions(modulePath string) ([]string, error) {
+	target := modulePath
+	if r, ok := s.replacement(modulePath, ""); ok && !r.IsLocal() {
+		target = r.New
+	}
+	mv, err := s.module(target)
+	if err != nil {
+		return nil, err
+	}
+	retract, err := s.retractions(target)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(mv.Versions))
+	for _, version := range mv.Versions {
+		if s.excluded(modulePath, version) || manifest.Retracted(retract, version) {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// excluded reports whether version of modulePath is listed in the root
+// manifest's exclude section.
+func (s *gitSource) excluded(modulePath, version string) bool {
+	for _, excluded := range s.exclusions[modulePath] {
+		if excluded == version {
+			return true
+		}
+		a, errA := semver.Parse(excluded)
+		b, errB := semver.Parse(version)
+		if errA == nil && errB == nil && a.Compare(b) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// retractions returns the retract list of modulePath, read from the manifest
+// at its latest version (the highest release, or the highest pre-release if
+// there are no releases).
+func (s *gitSource) retractions(modulePath string) ([]string, error) {
+	mv, err := s.module(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	if mv.retractLoaded {
+		return mv.Retract, nil
+	}
+
+	var latest *semver.Version
+	latestStr := ""
+	for _, version := range mv.Versions {
+		v, err := semver.Parse(version)
 		if err != nil {
-			return nil, fmt.Errorf("invalid module path %s: %w", modulePath, err)
+			continue
+		}
+		// Releases take precedence over pre-releases
+		if latest == nil || (latest.Pre != "" && v.Pre == "") ||
+			((latest.Pre == "") == (v.Pre == "") && v.Compare(latest) > 0) {
+			latest = v
+			latestStr = version
 		}
+	}
 
-		repoURL := git.ModulePathToRepoURL(mp.RepoURL)
+	if latestStr != "" {
+		m, err := s.manifest(modulePath, latestStr)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			mv.Retract = m.Retract
+		}
+	}
+	mv.retractLoaded = true
+	return mv.Retract, nil
+}
+
+// Retracted reports whether the author of modulePath has retracted version.
+func (s *gitSource) Retracted(modulePath, version string) (bool, error) {
+	target, _ := s.target(modulePath, version)
+	retract, err := s.retractions(target)
+	if err != nil {
+		return false, err
+	}
+	return manifest.Retracted(retract, version), nil
+}
+
+// Requirements implements resolver.Source by reading the module's cpkg.yaml at
+// the commit the version's tag points to, or from the local directory that
+// replaces it.
+func (s *gitSource) Requirements(modulePath, version string) (map[string]string, error) {
+	if r, ok := s.replacement(modulePath, version); ok && r.IsLocal() {
+		return s.localRequirements(filepath.Join(s.projectRoot, r.Dir))
+	}
+
+	m, err := s.manifest(s.target(modulePath, version))
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return s.requirements(m)
+}
 
-		// Fetch tags
-		allTags, err := git.LsRemoteTags(repoURL)
+// requirements returns the dependencies of m as module path -> constraint.
+// A dependency pinned to a branch, commit or tag is required at exactly the
+// version of the pinned commit.
+func (s *gitSource) requirements(m *manifest.Manifest) (map[string]string, error) {
+	if len(m.Dependencies) == 0 {
+		return nil, nil
+	}
+	reqs := make(map[string]string, len(m.Dependencies))
+	for modulePath, dep := range m.Dependencies {
+		if !dep.IsPinned() {
+			reqs[modulePath] = dep.Version
+			continue
+		}
+		version, err := s.pin(modulePath, dep)
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch tags for %s: %w", modulePath, err)
+			return nil, err
 		}
+		reqs[modulePath] = version
+	}
+	return reqs, nil
+}
 
-		// Filter tags for this subpath
-		tags := modulepath.FilterTagsForSubpath(allTags, mp.Subpath)
+// localRequirements returns the dependencies declared in the cpkg.yaml of a
+// local directory. Directories without a manifest have no dependencies.
+func (s *gitSource) localRequirements(dir string) (map[string]string, error) {
+	if s.member != nil && filepath.Clean(dir) == s.memberDir {
+		return s.requirements(s.member)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("replacement directory %s: %w", dir, err)
+	}
+	// Like a manifest read from git, a replacement's is not validated
+	data, err := os.ReadFile(filepath.Join(dir, manifest.ManifestFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	depManifest, err := manifest.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.requirements(depManifest)
+}
 
-		// Extract version from tags (remove subpath prefix/suffix)
-		var versionTags []string
-		for _, tag := range tags {
-			version, parseErr := modulepath.ExtractVersionFromTag(tag, mp.Subpath)
-			if parseErr != nil {
-				continue // Skip tags that don't match format
-			}
-			versionTags = append(versionTags, version)
-		}
-
-		// If no subpath-specific tags found, fall back to root repo tags
-		// This allows using arbitrary subdirectories from any repo
-		var selectedVersion string
-		var selectedTag string
-		
-		if len(versionTags) == 0 && mp.Subpath != "" {
-			// No subpath tags - use root repo tags and point to subdirectory
-			var rootVersionTags []string
-			for _, tag := range allTags {
-				// Filter out tags that look like they have subpaths
-				if strings.Contains(tag, "/") && !strings.HasPrefix(tag, "v") {
-					continue // Likely a subpath tag
-				}
-				if _, parseErr := semver.Parse(tag); parseErr == nil {
-					rootVersionTags = append(rootVersionTags, tag)
-				}
-			}
-			
-			var resolveErr error
-			selectedVersion, resolveErr = findCompatibleVersion(rootVersionTags, dep.Version)
-			if resolveErr != nil {
-				return nil, fmt.Errorf("no compatible version found for %s (constraint: %s): %w", modulePath, dep.Version, resolveErr)
-			}
-			selectedTag = selectedVersion // Root tag, no subpath prefix
-		} else {
-			// Find compatible version from subpath tags
-			var resolveErr error
-			selectedVersion, resolveErr = findCompatibleVersion(versionTags, dep.Version)
-			if resolveErr != nil {
-				return nil, fmt.Errorf("no compatible version found for %s (constraint: %s): %w", modulePath, dep.Version, resolveErr)
+// pin resolves a dependency pinned to a git ref to the version identifying
+// the pinned commit: a tag's own version if it is a release tag of the
+// module, otherwise a pseudo-version derived from the commit time. The
+// version is added to the module's versions so it can be selected, locked and
+// checked out like any tagged version.
+func (s *gitSource) pin(modulePath string, dep manifest.Dependency) (string, error) {
+	kind, ref, err := dep.Pin()
+	if err != nil {
+		return "", fmt.Errorf("dependency %s: %w", modulePath, err)
+	}
+
+	key := modulePath + "@" + kind + ":" + ref
+	if version, ok := s.pins[key]; ok {
+		return version, nil
+	}
+
+	target, _ := s.target(modulePath, "")
+	mv, err := s.module(target)
+	if err != nil {
+		return "", err
+	}
+
+	var commit string
+	switch kind {
+	case manifest.PinBranch:
+		commit, err = s.refs.CommitForBranch(mv.RepoURL, ref)
+	case manifest.PinCommit:
+		commit, err = git.ResolveCommit(mv.RepoURL, ref)
+	case manifest.PinTag:
+		for version, tag := range mv.Tags {
+			if _, err := semver.Parse(version); err == nil && tag == ref {
+				s.pins[key] = version
+				return version, nil
 			}
+		}
+		commit, err = s.refs.CommitForTag(mv.RepoURL, ref)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s %s of %s: %w", kind, ref, modulePath, err)
+	}
+
+	committed, err := git.CommitTime(mv.RepoURL, commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit %s of %s: %w", commit, modulePath, err)
+	}
+	version := semver.PseudoVersion(committed, commit)
+
+	if _, ok := s.commits[target+"@"+version]; !ok {
+		mv.Versions = append(mv.Versions, version)
+		s.commits[target+"@"+version] = commit
+	}
+	s.pins[key] = version
+	return version, nil
+}
+
+// manifest returns the (cached) cpkg.yaml of modulePath at version, or nil if
+// the module has no manifest.
+func (s *gitSource) manifest(modulePath, version string) (*manifest.Manifest, error) {
+	mv, err := s.module(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := s.Commit(modulePath, version)
+	if err != nil {
+		return nil, err
+	}
 
-			// Map back to the original tag format
-			selectedTag = selectedVersion
-			for _, tag := range tags {
-				version, parseErr := modulepath.ExtractVersionFromTag(tag, mp.Subpath)
-				if parseErr == nil && version == selectedVersion {
-					selectedTag = tag
-					break
-				}
+	key := modulePath + "@" + commit
+	if m, ok := s.manifests[key]; ok {
+		return m, nil
+	}
+	m, err := readManifest(mv, commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s of %s@%s: %w", manifest.ManifestFileName, modulePath, version, err)
+	}
+	s.manifests[key] = m
+	return m, nil
+}
+
+// Commit returns the commit for a version of modulePath.
+func (s *gitSource) Commit(modulePath, version string) (string, error) {
+	key := modulePath + "@" + version
+	if commit, ok := s.commits[key]; ok {
+		return commit, nil
+	}
+
+	mv, err := s.module(modulePath)
+	if err != nil {
+		return "", err
+	}
+	tag, ok := mv.Tags[version]
+	if !ok {
+		return "", fmt.Errorf("version %s of %s not found", version, modulePath)
+	}
+	commit, err := s.refs.CommitForTag(mv.RepoURL, tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit for %s@%s: %w", modulePath, tag, err)
+	}
+	s.commits[key] = commit
+	return commit, nil
+}
+
+// resolveDependencies resolves the full dependency graph of m, including the
+// dependencies declared in each dependency's own cpkg.yaml, and builds the
+// lockfile for it. The manifest's resolution field selects the strategy and its
+// replace directives are applied; local replacement paths are relative to
+// projectRoot.
+//
+// If the module is a member of a workspace, the graphs of all members are
+// resolved together, so that the lockfile agrees with those of the other
+// members, and members are used from their directories. While forEachMember
+// runs, that resolution is shared by all members (see sharedResolutions).
+func resolveDependencies(m *manifest.Manifest, projectRoot, depRoot string) (*lockfile.Lockfile, error) {
+	src, err := newGitSource(m, projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	if src.workspace != nil {
+		shared, ok := sharedResolutions[src.workspace.Root]
+		if !ok {
+			shared = &sharedResolution{src: src}
+			shared.result, shared.err = resolveSource(src, m)
+			if sharedResolutions != nil {
+				sharedResolutions[src.workspace.Root] = shared
 			}
 		}
+		if shared.err != nil {
+			return nil, shared.err
+		}
+		return buildLockfile(m, requiredBy(shared.result, m.Module), shared.src, projectRoot, depRoot)
+	}
 
-		// Get commit for tag
-		commit, err := git.GetCommitForTag(repoURL, selectedTag)
+	result, err := resolveSource(src, m)
+	if err != nil {
+		return nil, err
+	}
+	return buildLockfile(m, result.Modules, src, projectRoot, depRoot)
+}
+
+// resolveSource resolves the dependency graph of m, or of the whole workspace
+// if src covers one, from src.
+func resolveSource(src *gitSource, m *manifest.Manifest) (*resolver.Result, error) {
+	root, resolution := m.Module, m.Resolution
+	var requirements map[string]string
+	var err error
+	if src.workspace != nil {
+		root = manifest.WorkspaceFileName
+		requirements, resolution, err = src.workspaceRequirements()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get commit for %s@%s: %w", modulePath, selectedTag, err)
+			return nil, err
+		}
+	} else {
+		// Pins of direct dependencies are resolved before the resolver starts
+		src.Prefetch(sortedDependencies(m))
+		requirements, err = src.requirements(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	strategy, err := resolver.ParseStrategy(resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replacements that apply to every version with a fixed target bypass
+	// the constraints on the module entirely.
+	fixed := make(map[string]string)
+	for _, r := range src.replacements {
+		if r.OldVersion != "" {
+			continue
+		}
+		if r.IsLocal() {
+			fixed[r.Old] = ""
+		} else if r.NewVersion != "" {
+			fixed[r.Old] = r.NewVersion
 		}
+	}
 
-		// Compute checksum
-		sum, err := git.ComputeTreeHash(repoURL, commit)
+	return resolver.Resolve(root, requirements, src, resolver.Options{
+		Strategy: strategy,
+		Fixed:    fixed,
+	})
+}
+
+// buildLockfile builds the lockfile of m, in projectRoot, for the selected
+// modules. The content hash of a module still locked at the same commit and
+// subdirectory as in the existing lockfile is kept rather than recomputed.
+func buildLockfile(m *manifest.Manifest, modules map[string]*resolver.Selection, src *gitSource, projectRoot, depRoot string) (*lockfile.Lockfile, error) {
+	previous, err := lockfile.Load(filepath.Join(projectRoot, lockfile.LockfileName))
+	if err != nil {
+		previous = &lockfile.Lockfile{} // Everything is hashed
+	}
+
+	lock := &lockfile.Lockfile{
+		APIVersion:   "cpkg.ringil.dev/v0",
+		Kind:         "Lockfile",
+		Module:       m.Module,
+		GeneratedBy:  "cpkg 0.1.0",
+		DepRoot:      depRoot,
+		Dependencies: make(map[string]lockfile.Dependency),
+	}
+
+	var hashed []string // Modules checked out from git at a new commit, whose sum is computed below
+	for modulePath, sel := range modules {
+		_, direct := m.Dependencies[modulePath]
+		lockDep := lockfile.Dependency{
+			Version:  sel.Version, // Store the version part (without subpath)
+			Indirect: !direct,
+			Requires: sel.Requires,
+		}
+
+		r, replaced := src.replacement(modulePath, sel.Version)
+		if replaced && r.IsLocal() {
+			r.Dir = src.localPath(r.Dir, projectRoot)
+		}
+		if replaced {
+			lockDep.Replace = r.Target()
+		}
+		if replaced && r.IsLocal() {
+			// Local directories are used in place, not checked out as submodules
+			lockDep.VCS = lockfile.VCSLocal
+			lockDep.Path = r.Dir
+			lockDep.SourcePath = r.Dir
+			lock.Dependencies[modulePath] = lockDep
+			continue
+		}
+
+		target, targetVersion := src.target(modulePath, sel.Version)
+		mv, err := src.module(target)
 		if err != nil {
-			return nil, fmt.Errorf("failed to compute checksum for %s: %w", modulePath, err)
+			return nil, err
+		}
+		commit, err := src.Commit(target, targetVersion)
+		if err != nil {
+			return nil, err
 		}
 
+		// The submodule path is keyed by the original module path, so a
+		// replacement does not move the checkout
 		path := filepath.Join(depRoot, modulePath)
-		
+
 		// Compute the actual source path (where the .c/.h files are)
 		sourcePath := path
-		if mp.Subpath != "" {
-			sourcePath = filepath.Join(path, mp.Subpath)
+		if mv.Subpath != "" {
+			sourcePath = filepath.Join(path, mv.Subpath)
 		}
 
-		lockDep := lockfile.Dependency{
-			Version:    selectedVersion, // Store the version part (without subpath)
-			Commit:     commit,
-			Sum:        sum,
-			VCS:        "git",
-			RepoURL:    repoURL,
-			Path:       path,        // Submodule path (entire repo checkout)
-			Subdir:     mp.Subpath,  // Store the subdirectory within the repo
-			SourcePath: sourcePath,  // Actual path to source files
-		}
+		lockDep.Commit = commit
+		lockDep.VCS = lockfile.VCSGit
+		lockDep.RepoURL = mv.RepoURL
+		lockDep.Path = path             // Submodule path (entire repo checkout)
+		lockDep.Subdir = mv.Subpath     // Store the subdirectory within the repo
+		lockDep.SourcePath = sourcePath // Actual path to source files
 
+		// A commit's content never changes
+		if old, ok := previous.Dependencies[modulePath]; ok && dirhash.IsHash(old.Sum) &&
+			old.Commit == commit && old.RepoURL == mv.RepoURL && old.Subdir == mv.Subpath {
+			lockDep.Sum = old.Sum
+		} else {
+			hashed = append(hashed, modulePath)
+		}
 		lock.Dependencies[modulePath] = lockDep
 	}
 
+	// Compute checksums, which reads every file of each tree, concurrently
+	sort.Strings(hashed)
+	hashes := make([]string, len(hashed))
+	err = forEachParallel(len(hashed), func(i int) error {
+		dep := lock.Dependencies[hashed[i]]
+		sum, err := git.ComputeTreeHash(dep.RepoURL, dep.Commit, dep.Subdir)
+		if err != nil {
+			return fmt.Errorf("failed to compute checksum for %s: %w", hashed[i], err)
+		}
+		hashes[i] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, modulePath := range hashed {
+		dep := lock.Dependencies[modulePath]
+		dep.Sum = hashes[i]
+		lock.Dependencies[modulePath] = dep
+	}
+
 	return lock, nil
 }
 
-func findCompatibleVersion(tags []string, constraint string) (string, error) {
-	var compatibleVersions []*semver.Version
+// sortedDependencies returns the module paths of m's dependencies, sorted.
+func sortedDependencies(m *manifest.Manifest) []string {
+	modules := make([]string, 0, len(m.Dependencies))
+	for modulePath := range m.Dependencies {
+		modules = append(modules, modulePath)
+	}
+	sort.Strings(modules)
+	return modules
+}
 
-	for _, tag := range tags {
-		// Remove 'v' prefix if present for parsing
-		v, err := semver.Parse(tag)
-		if err != nil {
-			continue // Skip invalid versions
-		}
+// moduleRepoURL returns the URL of the repository providing modulePath.
+func moduleRepoURL(modulePath string) (string, error) {
+	mp, err := modulepath.ParseModulePath(modulePath)
+	if err != nil {
+		return "", fmt.Errorf("invalid module path %s: %w", modulePath, err)
+	}
+	return git.ModulePathToRepoURL(mp.RepoURL), nil
+}
 
-		satisfies, err := v.Satisfies(constraint)
-		if err != nil {
-			continue
+// listModuleVersions lists the versions available for modulePath from its
+// repository tags.
+func listModuleVersions(refs *git.RefsCache, modulePath string) (*moduleVersions, error) {
+	// Parse module path to extract repo URL and subpath
+	mp, err := modulepath.ParseModulePath(modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module path %s: %w", modulePath, err)
+	}
+
+	mv := &moduleVersions{
+		RepoURL: git.ModulePathToRepoURL(mp.RepoURL),
+		Subpath: mp.Subpath,
+		Tags:    make(map[string]string),
+	}
+
+	// Fetch tags
+	allTags, err := refs.Tags(mv.RepoURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tags for %s: %w", modulePath, err)
+	}
+
+	// Filter tags for this subpath and extract the version part
+	for _, tag := range modulepath.FilterTagsForSubpath(allTags, mp.Subpath) {
+		version, parseErr := modulepath.ExtractVersionFromTag(tag, mp.Subpath)
+		if parseErr != nil {
+			continue // Skip tags that don't match format
 		}
-		if satisfies {
-			compatibleVersions = append(compatibleVersions, v)
+		if _, seen := mv.Tags[version]; !seen {
+			mv.Versions = append(mv.Versions, version)
+			mv.Tags[version] = tag
 		}
 	}
 
-	if len(compatibleVersions) == 0 {
-		return "", fmt.Errorf("no compatible version found")
+	// If no subpath-specific tags found, fall back to root repo tags
+	// This allows using arbitrary subdirectories from any repo
+	if len(mv.Versions) == 0 && mp.Subpath != "" {
+		for _, tag := range allTags {
+			// Filter out tags that look like they have subpaths
+			if strings.Contains(tag, "/") && !strings.HasPrefix(tag, "v") {
+				continue // Likely a subpath tag
+			}
+			if _, parseErr := semver.Parse(tag); parseErr != nil {
+				continue
+			}
+			if _, seen := mv.Tags[tag]; !seen {
+				mv.Versions = append(mv.Versions, tag)
+				mv.Tags[tag] = tag // Root tag, no subpath prefix
+			}
+		}
 	}
 
-	// Sort descending (highest first)
-	sort.Slice(compatibleVersions, func(i, j int) bool {
-		return compatibleVersions[i].Compare(compatibleVersions[j]) > 0
-	})
+	return mv, nil
+}
+
+// readManifest returns the cpkg.yaml of a module at commit, or nil if the
+// module has no manifest.
+func readManifest(mv *moduleVersions, commit string) (*manifest.Manifest, error) {
+	manifestPath := path.Join(mv.Subpath, manifest.ManifestFileName)
+	data, err := git.ReadFile(mv.RepoURL, commit, manifestPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest.Parse(data)
+}
+
+// lockedVersion describes the version locked for dep, including any
+// replacement, e.g. "v1.2.0", "v1.2.0 => github.com/me/foo@v1.2.1" or
+// "=> ../foo" for an unversioned local replacement.
+func lockedVersion(dep lockfile.Dependency) string {
+	if dep.Replace == "" {
+		return dep.Version
+	}
+	if dep.Version == "" {
+		return "=> " + dep.Replace
+	}
+	return dep.Version + " => " + dep.Replace
+}
 
-	// Return the highest compatible version with 'v' prefix
-	selected := compatibleVersions[0]
-	selectedStr := selected.String()
-	// Find the original tag that matches
-	for _, tag := range tags {
-		v, err := semver.Parse(tag)
-		if err == nil && v.Compare(selected) == 0 {
-			return tag, nil
+// checkSums checks the content hashes in lock against the shared sums store
+// and, unless dryRun is set, records the ones the store has not seen yet.
+// Entries are keyed by the module and version whose content was hashed, so
+// a fork is recorded under its own path.
+func checkSums(lock *lockfile.Lockfile, dryRun bool) error {
+	path, err := sums.Path()
+	if err != nil || path == "" {
+		return err
+	}
+	store, err := sums.Open(path)
+	if err != nil {
+		return err
+	}
+
+	modules := make([]string, 0, len(lock.Dependencies))
+	for modulePath := range lock.Dependencies {
+		modules = append(modules, modulePath)
+	}
+	sort.Strings(modules)
+
+	for _, modulePath := range modules {
+		dep := lock.Dependencies[modulePath]
+		if dep.IsLocal() || !dirhash.IsHash(dep.Sum) {
+			continue
 		}
+		module, version := modulePath, dep.Version
+		if dep.Replace != "" {
+			module = dep.Replace
+			if i := strings.LastIndex(dep.Replace, "@"); i >= 0 {
+				module, version = dep.Replace[:i], dep.Replace[i+1:]
+			}
+		}
+		if err := store.Check(module, version, dep.Sum); err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		return nil
 	}
-	return "v" + selectedStr, nil
+	return store.Save()
 }
 
+// findCompatibleVersion returns the highest tag that satisfies constraint.
+func findCompatibleVersion(tags []string, constraint string) (string, error) {
+	return resolver.Select(tags, []string{constraint}, resolver.StrategyHighest)
+}
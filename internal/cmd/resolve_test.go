@@ -0,0 +1,720 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/dirhash"
+	"github.com/SCKelemen/cpkg/internal/git"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+	"github.com/SCKelemen/cpkg/internal/semver"
+	"github.com/SCKelemen/cpkg/internal/submodule"
+	"github.com/SCKelemen/cpkg/internal/sums"
+	"gopkg.in/yaml.v3"
+)
+
+// testRemotes redirects https:// module URLs to local repositories under a
+// temporary directory, so resolution can be exercised without network access.
+type testRemotes struct {
+	t    *testing.T
+	root string
+}
+
+func newTestRemotes(t *testing.T) *testRemotes {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+
+	root := t.TempDir()
+	t.Setenv("GIT_CONFIG_COUNT", "2")
+	t.Setenv("GIT_CONFIG_KEY_0", "url.file://"+filepath.ToSlash(root)+"/.insteadOf")
+	t.Setenv("GIT_CONFIG_VALUE_0", "https://")
+	// Submodules are cloned from the redirected file:// URLs
+	t.Setenv("GIT_CONFIG_KEY_1", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_1", "always")
+	t.Setenv("GIT_AUTHOR_NAME", "cpkg")
+	t.Setenv("GIT_AUTHOR_EMAIL", "cpkg@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "cpkg")
+	t.Setenv("GIT_COMMITTER_EMAIL", "cpkg@example.com")
+	t.Setenv(sums.EnvVar, filepath.Join(root, sums.FileName))
+	t.Setenv(git.CacheEnvVar, filepath.Join(root, "cache"))
+
+	return &testRemotes{t: t, root: root}
+}
+
+// writeManifest writes m to path as YAML, as a user would.
+func writeManifest(t *testing.T, m *manifest.Manifest, path string) {
+	t.Helper()
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+}
+
+// publish commits files to the repository for module path repo and tags the
+// commit with each of tags. Passing deps writes a cpkg.yaml declaring them.
+func (r *testRemotes) publish(repo string, deps map[string]string, tags ...string) {
+	r.t.Helper()
+
+	var m *manifest.Manifest
+	if deps != nil {
+		m = &manifest.Manifest{Dependencies: make(map[string]manifest.Dependency)}
+		for module, constraint := range deps {
+			m.Dependencies[module] = manifest.Dependency{Version: constraint}
+		}
+	}
+	r.publishManifest(repo, m, tags...)
+}
+
+// publishManifest is like publish, but writes m as the module's cpkg.yaml
+// (or removes it if m is nil).
+func (r *testRemotes) publishManifest(repo string, m *manifest.Manifest, tags ...string) {
+	r.t.Helper()
+
+	dir := filepath.Join(r.root, repo+".git")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		r.git("", "init", "--quiet", dir)
+	}
+
+	if m == nil {
+		os.Remove(filepath.Join(dir, manifest.ManifestFileName))
+	} else {
+		m.APIVersion = "cpkg.ringil.dev/v0"
+		m.Kind = "Module"
+		m.Module = repo
+		data, err := yaml.Marshal(m)
+		if err != nil {
+			r.t.Fatalf("failed to marshal manifest: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, manifest.ManifestFileName), data, 0644); err != nil {
+			r.t.Fatalf("failed to write manifest: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lib.h"), []byte("// "+strings.Join(tags, " ")+"\n"), 0644); err != nil {
+		r.t.Fatalf("failed to write source: %v", err)
+	}
+
+	r.git(dir, "add", "-A")
+	r.git(dir, "commit", "--quiet", "-m", "release "+strings.Join(tags, " "))
+	for _, tag := range tags {
+		r.git(dir, "tag", tag)
+	}
+}
+
+func (r *testRemotes) git(dir string, args ...string) {
+	r.t.Helper()
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		r.t.Fatalf("git %s failed: %v\noutput: %s", strings.Join(args, " "), err, output)
+	}
+}
+
+func TestResolveDependencies_Transitive(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libb", nil, "v1.0.0")
+	remotes.publish("example.com/acme/libb", nil, "v1.1.0")
+	remotes.publish("example.com/acme/libb", nil, "v1.2.0")
+	remotes.publish("example.com/acme/libb", nil, "v2.0.0")
+	remotes.publish("example.com/acme/liba", map[string]string{
+		"example.com/acme/libb": "^1.1.0",
+	}, "v1.0.0")
+
+	m := &manifest.Manifest{
+		Module: "example.com/acme/app",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+
+	lock, err := resolveDependencies(m, t.TempDir(), "deps")
+	if err != nil {
+		t.Fatalf("resolveDependencies() error = %v", err)
+	}
+
+	if len(lock.Dependencies) != 2 {
+		t.Fatalf("expected 2 dependencies, got %d: %+v", len(lock.Dependencies), lock.Dependencies)
+	}
+
+	liba := lock.Dependencies["example.com/acme/liba"]
+	if liba.Version != "v1.0.0" || liba.Indirect {
+		t.Errorf("liba = %s (indirect=%v), want v1.0.0 direct", liba.Version, liba.Indirect)
+	}
+	if liba.Requires["example.com/acme/libb"] != "^1.1.0" {
+		t.Errorf("liba requires = %v, want libb ^1.1.0", liba.Requires)
+	}
+	// The sum is the content hash of the tree, matching a checkout of it
+	if want, err := dirhash.HashDir(filepath.Join(remotes.root, "example.com/acme/liba.git")); err != nil || liba.Sum != want {
+		t.Errorf("liba sum = %s, want %s (err=%v)", liba.Sum, want, err)
+	}
+
+	libb := lock.Dependencies["example.com/acme/libb"]
+	if libb.Version != "v1.2.0" || !libb.Indirect {
+		t.Errorf("libb = %s (indirect=%v), want v1.2.0 indirect", libb.Version, libb.Indirect)
+	}
+	if libb.Path != filepath.Join("deps", "example.com/acme/libb") {
+		t.Errorf("libb path = %s", libb.Path)
+	}
+}
+
+func TestResolveDependencies_SharedConstraints(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libb", nil, "v1.1.0")
+	remotes.publish("example.com/acme/libb", nil, "v1.1.5")
+	remotes.publish("example.com/acme/libb", nil, "v1.2.0")
+	remotes.publish("example.com/acme/liba", map[string]string{
+		"example.com/acme/libb": "^1.1.0",
+	}, "v1.0.0")
+
+	m := &manifest.Manifest{
+		Module: "example.com/acme/app",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+			"example.com/acme/libb": {Version: "~1.1.0"},
+		},
+	}
+
+	lock, err := resolveDependencies(m, t.TempDir(), "deps")
+	if err != nil {
+		t.Fatalf("resolveDependencies() error = %v", err)
+	}
+
+	libb := lock.Dependencies["example.com/acme/libb"]
+	if libb.Version != "v1.1.5" {
+		t.Errorf("libb version = %s, want v1.1.5", libb.Version)
+	}
+	if libb.Indirect {
+		t.Error("libb is required by the root manifest and should be direct")
+	}
+}
+
+func TestResolveDependencies_Replace(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libb", nil, "v1.0.0")
+	remotes.publish("example.com/acme/liba", map[string]string{
+		"example.com/acme/libb": "^1.0.0",
+	}, "v1.0.0")
+	remotes.publish("example.com/me/liba", map[string]string{
+		"example.com/acme/libb": "^1.0.0",
+	}, "v1.0.1-fix")
+
+	projectRoot := t.TempDir()
+	localRel := "./work/libb"
+	if err := os.MkdirAll(filepath.Join(projectRoot, localRel), 0755); err != nil {
+		t.Fatalf("failed to create local directory: %v", err)
+	}
+
+	m := &manifest.Manifest{
+		Module: "example.com/acme/app",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+		Replace: map[string]string{
+			"example.com/acme/liba": "example.com/me/liba@v1.0.1-fix",
+			"example.com/acme/libb": localRel,
+		},
+	}
+
+	lock, err := resolveDependencies(m, projectRoot, "deps")
+	if err != nil {
+		t.Fatalf("resolveDependencies() error = %v", err)
+	}
+
+	liba := lock.Dependencies["example.com/acme/liba"]
+	if liba.Version != "v1.0.1-fix" || liba.Replace != "example.com/me/liba@v1.0.1-fix" {
+		t.Errorf("liba = %s (replace %q), want the fork", liba.Version, liba.Replace)
+	}
+	if liba.RepoURL != "https://example.com/me/liba.git" {
+		t.Errorf("liba repo = %s, want the fork's repository", liba.RepoURL)
+	}
+	if liba.Path != filepath.Join("deps", "example.com/acme/liba") {
+		t.Errorf("liba path = %s, want the original module's submodule path", liba.Path)
+	}
+
+	libb := lock.Dependencies["example.com/acme/libb"]
+	if !libb.IsLocal() || libb.Path != localRel || libb.Commit != "" {
+		t.Errorf("libb = %+v, want a local replacement at %s", libb, localRel)
+	}
+}
+
+func TestResolveDependencies_ExcludeAndRetract(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v3.6.0")
+	remotes.publish("example.com/acme/liba", nil, "v3.6.1")
+	remotes.publish("example.com/acme/liba", nil, "v3.6.2")
+	remotes.publish("example.com/acme/libb", nil, "v1.0.0")
+	remotes.publish("example.com/acme/libb", nil, "v1.0.1")
+	remotes.publishManifest("example.com/acme/libb", &manifest.Manifest{
+		Retract: []string{"v1.0.1", "v1.0.2"},
+	}, "v1.0.2")
+
+	m := &manifest.Manifest{
+		Module: "example.com/acme/app",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^3.6.0"},
+			"example.com/acme/libb": {Version: "^1.0.0"},
+		},
+		Exclude: []string{"example.com/acme/liba@v3.6.2"},
+	}
+
+	lock, err := resolveDependencies(m, t.TempDir(), "deps")
+	if err != nil {
+		t.Fatalf("resolveDependencies() error = %v", err)
+	}
+
+	if got := lock.Dependencies["example.com/acme/liba"].Version; got != "v3.6.1" {
+		t.Errorf("liba = %s, want v3.6.1 (v3.6.2 is excluded)", got)
+	}
+	if got := lock.Dependencies["example.com/acme/libb"].Version; got != "v1.0.0" {
+		t.Errorf("libb = %s, want v1.0.0 (later versions are retracted)", got)
+	}
+}
+
+func TestResolveDependencies_Pins(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/hal", nil)
+	remotes.git(filepath.Join(remotes.root, "example.com/acme/hal.git"), "branch", "-M", "main")
+	remotes.publish("example.com/acme/bsp", nil, "bsp-2024.1")
+	remotes.publish("example.com/acme/rtos", nil)
+	rtosHead, err := exec.Command("git", "-C", filepath.Join(remotes.root, "example.com/acme/rtos.git"), "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatalf("git rev-parse failed: %v", err)
+	}
+	rtosCommit := strings.TrimSpace(string(rtosHead))
+	remotes.publishManifest("example.com/acme/liba", &manifest.Manifest{
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/rtos": {Commit: rtosCommit[:7]},
+		},
+	}, "v1.0.0")
+
+	m := &manifest.Manifest{
+		Module: "example.com/acme/app",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/hal":  {Branch: "main"},
+			"example.com/acme/bsp":  {Tag: "bsp-2024.1"},
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+
+	lock, err := resolveDependencies(m, t.TempDir(), "deps")
+	if err != nil {
+		t.Fatalf("resolveDependencies() error = %v", err)
+	}
+
+	for _, module := range []string{"example.com/acme/hal", "example.com/acme/bsp", "example.com/acme/rtos"} {
+		dep := lock.Dependencies[module]
+		if !semver.IsPseudoVersion(dep.Version) {
+			t.Errorf("%s version = %s, want a pseudo-version", module, dep.Version)
+		}
+		if !strings.HasPrefix(dep.Commit, semver.PseudoVersionCommit(dep.Version)) {
+			t.Errorf("%s commit = %s does not match %s", module, dep.Commit, dep.Version)
+		}
+	}
+	if got := lock.Dependencies["example.com/acme/rtos"].Commit; got != rtosCommit {
+		t.Errorf("rtos commit = %s, want %s", got, rtosCommit)
+	}
+	if !lock.Dependencies["example.com/acme/rtos"].Indirect {
+		t.Error("rtos is only required by liba and should be indirect")
+	}
+
+	m.Dependencies["example.com/acme/hal"] = manifest.Dependency{Branch: "main", Version: "^1.0.0"}
+	if _, err := resolveDependencies(m, t.TempDir(), "deps"); err == nil {
+		t.Error("expected an error for a dependency with both a version and a branch")
+	}
+}
+
+func TestTidy_SumsStore(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+
+	projectDir := t.TempDir()
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	storePath := filepath.Join(remotes.root, sums.FileName)
+	store, err := sums.Open(storePath)
+	if err != nil {
+		t.Fatalf("sums.Open() error = %v", err)
+	}
+	trusted, ok := store.Lookup("example.com/acme/liba", "v1.0.0")
+	if !ok || !dirhash.IsHash(trusted) {
+		t.Fatalf("tidy should record liba@v1.0.0 in the sums store, got %q", trusted)
+	}
+
+	// Another project locked different content for the same version
+	other := "h1:" + strings.Repeat("A", 43) + "="
+	if err := os.WriteFile(storePath, []byte("example.com/acme/liba v1.0.0 "+other+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write sums store: %v", err)
+	}
+	err = runTidyInternal(projectDir, "", false)
+	var mismatch *sums.MismatchError
+	if !errors.As(err, &mismatch) || mismatch.Trusted != other || mismatch.Got != trusted {
+		t.Errorf("runTidyInternal() error = %v, want a mismatch showing both hashes", err)
+	}
+}
+
+func TestTidy_ReusesSums(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+
+	projectDir := t.TempDir()
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	// A sum that is not the tree's own shows it was kept, not recomputed
+	lockPath := filepath.Join(projectDir, lockfile.LockfileName)
+	lock, err := lockfile.Load(lockPath)
+	if err != nil {
+		t.Fatalf("failed to load lockfile: %v", err)
+	}
+	kept := "h1:" + strings.Repeat("A", 43) + "="
+	dep := lock.Dependencies["example.com/acme/liba"]
+	dep.Sum = kept
+	lock.Dependencies["example.com/acme/liba"] = dep
+	if err := lockfile.Save(lock, lockPath); err != nil {
+		t.Fatalf("failed to save lockfile: %v", err)
+	}
+	t.Setenv(sums.EnvVar, "off")
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+	if lock, _ := lockfile.Load(lockPath); lock.Dependencies["example.com/acme/liba"].Sum != kept {
+		t.Errorf("sum = %s, want the sum of the unchanged commit kept", lock.Dependencies["example.com/acme/liba"].Sum)
+	}
+
+	// A new commit is hashed
+	remotes.publish("example.com/acme/liba", nil, "v1.1.0")
+	t.Setenv(git.CacheEnvVar, filepath.Join(t.TempDir(), "cache"))
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+	lock, err = lockfile.Load(lockPath)
+	if err != nil {
+		t.Fatalf("failed to load lockfile: %v", err)
+	}
+	if got := lock.Dependencies["example.com/acme/liba"]; got.Version != "v1.1.0" || got.Sum == kept || !dirhash.IsHash(got.Sum) {
+		t.Errorf("liba = %+v, want v1.1.0 with its own sum", got)
+	}
+}
+
+func TestOfflineMode(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/liba", nil, "v1.0.0")
+
+	projectDir := t.TempDir()
+	remotes.git(projectDir, "init", "--quiet")
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+	writeManifest(t, m, filepath.Join(projectDir, manifest.ManifestFileName))
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	// Resolving online fills the module cache
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+
+	// Without the network, everything is served from the cache
+	remote := filepath.Join(remotes.root, "example.com/acme/liba.git")
+	if err := os.Rename(remote, remote+".unreachable"); err != nil {
+		t.Fatalf("failed to hide remote: %v", err)
+	}
+	t.Setenv(git.OfflineEnvVar, "1")
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() offline error = %v", err)
+	}
+	if err := runSyncInternal(io.Discard, io.Discard, projectDir, ""); err != nil {
+		t.Fatalf("runSyncInternal() offline error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "deps/example.com/acme/liba/lib.h")); err != nil {
+		t.Errorf("the dependency should be checked out from the cache: %v", err)
+	}
+	if url, _ := submodule.GetSubmoduleURL(projectDir, "deps/example.com/acme/liba"); url != "https://example.com/acme/liba.git" {
+		t.Errorf("submodule URL = %q, want the module's repository", url)
+	}
+
+	// Anything not cached fails instead of going to the network
+	if err := git.CleanCache(filepath.Join(remotes.root, "cache")); err != nil {
+		t.Fatalf("CleanCache() error = %v", err)
+	}
+	if err := runTidyInternal(projectDir, "", false); !errors.Is(err, git.ErrOffline) {
+		t.Errorf("runTidyInternal() error = %v, want %v", err, git.ErrOffline)
+	}
+}
+
+func TestFrozenMode(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libb", nil, "v1.0.0")
+	remotes.publish("example.com/acme/liba", map[string]string{
+		"example.com/acme/libb": "^1.0.0",
+	}, "v1.0.0")
+
+	projectDir := t.TempDir()
+	manifestPath := filepath.Join(projectDir, manifest.ManifestFileName)
+	m := &manifest.Manifest{
+		APIVersion: "cpkg.ringil.dev/v0",
+		Kind:       "Module",
+		Module:     "example.com/acme/app",
+		DepRoot:    "deps",
+		Dependencies: map[string]manifest.Dependency{
+			"example.com/acme/liba": {Version: "^1.0.0"},
+		},
+	}
+	writeManifest(t, m, manifestPath)
+
+	t.Setenv(FrozenEnvVar, "true")
+	if err := runTidyInternal(projectDir, "", false); err == nil || !strings.Contains(err.Error(), "cannot be created") {
+		t.Fatalf("runTidyInternal() error = %v, want a missing lockfile error", err)
+	}
+
+	t.Setenv(FrozenEnvVar, "")
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() error = %v", err)
+	}
+	lockPath := filepath.Join(projectDir, lockfile.LockfileName)
+	before, _ := os.ReadFile(lockPath)
+
+	// A new release does not move a frozen lockfile, and nothing is fetched
+	remotes.publish("example.com/acme/libb", nil, "v1.1.0")
+	t.Setenv(FrozenEnvVar, "true")
+	t.Setenv(git.CacheEnvVar, filepath.Join(t.TempDir(), "empty"))
+	t.Setenv(git.OfflineEnvVar, "1")
+	if err := runTidyInternal(projectDir, "", false); err != nil {
+		t.Fatalf("runTidyInternal() frozen error = %v", err)
+	}
+
+	// A manifest the lockfile no longer satisfies is an error, not a re-resolve
+	m.Dependencies["example.com/acme/liba"] = manifest.Dependency{Version: "^2.0.0"}
+	writeManifest(t, m, manifestPath)
+	err := runTidyInternal(projectDir, "", false)
+	if err == nil || !strings.Contains(err.Error(), "does not satisfy ^2.0.0") {
+		t.Errorf("runTidyInternal() error = %v, want an out of date lockfile", err)
+	}
+	if after, _ := os.ReadFile(lockPath); string(after) != string(before) {
+		t.Error("frozen mode must not rewrite the lockfile")
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libc", nil, "v1.0.0")
+	remotes.publish("example.com/acme/libc", nil, "v1.1.0")
+	remotes.publish("example.com/acme/libc", nil, "v1.2.0")
+	// A release of the sibling, which the workspace must not use
+	remotes.publish("example.com/acme/span", nil, "v1.0.0")
+
+	root := t.TempDir()
+	write := func(path, data string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	write(manifest.WorkspaceFileName, "apiVersion: cpkg.ringil.dev/v0\nkind: Workspace\nuse:\n  - libs/span\n  - app\n")
+	write("libs/span/cpkg.yaml", `apiVersion: cpkg.ringil.dev/v0
+kind: Module
+module: example.com/acme/span
+depRoot: deps
+dependencies:
+  example.com/acme/libc:
+    version: ~1.1.0
+`)
+	write("app/cpkg.yaml", `apiVersion: cpkg.ringil.dev/v0
+kind: Module
+module: example.com/acme/app
+depRoot: deps
+dependencies:
+  example.com/acme/libc:
+    version: ^1.0.0
+  example.com/acme/span:
+    version: ^1.0.0
+`)
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	// Tidy at the root tidies every member
+	var buf bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &buf, Err: &bytes.Buffer{}}}
+	if err := runTidy(ctx); err != nil {
+		t.Fatalf("runTidy() error = %v\n%s", err, buf.String())
+	}
+	if !strings.Contains(buf.String(), "==> "+filepath.Join("libs", "span")+"\n") || !strings.Contains(buf.String(), "==> app\n") {
+		t.Errorf("output does not head each member:\n%s", buf.String())
+	}
+
+	appLock, err := lockfile.Load(filepath.Join(root, "app", lockfile.LockfileName))
+	if err != nil {
+		t.Fatalf("failed to load app lockfile: %v", err)
+	}
+	spanLock, err := lockfile.Load(filepath.Join(root, "libs/span", lockfile.LockfileName))
+	if err != nil {
+		t.Fatalf("failed to load span lockfile: %v", err)
+	}
+
+	// The sibling is used from its directory
+	span := appLock.Dependencies["example.com/acme/span"]
+	wantDir := filepath.Join("..", "libs", "span")
+	if span.VCS != lockfile.VCSLocal || span.Path != wantDir || span.Replace != wantDir || span.Indirect {
+		t.Errorf("app's span = %+v, want the local directory %s", span, wantDir)
+	}
+	// Both members agree on libc, although app alone would pick v1.2.0
+	if got := appLock.Dependencies["example.com/acme/libc"]; got.Version != "v1.1.0" || got.Indirect {
+		t.Errorf("app's libc = %s (indirect=%v), want v1.1.0 direct", got.Version, got.Indirect)
+	}
+	if got := spanLock.Dependencies["example.com/acme/libc"]; got.Version != "v1.1.0" {
+		t.Errorf("span's libc = %s, want v1.1.0", got.Version)
+	}
+	if len(spanLock.Dependencies) != 1 {
+		t.Errorf("span's lockfile has other members' dependencies: %+v", spanLock.Dependencies)
+	}
+
+	// Frozen mode accepts the workspace's replacements
+	appManifest, err := manifest.Load(filepath.Join(root, "app", manifest.ManifestFileName))
+	if err != nil {
+		t.Fatalf("failed to load app manifest: %v", err)
+	}
+	if err := checkFrozen(appManifest, filepath.Join(root, "app"), appLock); err != nil {
+		t.Errorf("checkFrozen() error = %v", err)
+	}
+
+	// A module inside the workspace that is not a member is refused
+	write("tools/cpkg.yaml", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/tools\n")
+	if err := runTidyInternal(filepath.Join(root, "tools"), "", false); err == nil || !strings.Contains(err.Error(), "not one of its members") {
+		t.Errorf("runTidyInternal(tools) error = %v, want a not-a-member error", err)
+	}
+
+	// Without the workspace, app resolves on its own from tags
+	t.Setenv(manifest.WorkspaceEnvVar, "off")
+	lock, err := resolveDependencies(appManifest, filepath.Join(root, "app"), "deps")
+	if err != nil {
+		t.Fatalf("resolveDependencies() error = %v", err)
+	}
+	if got := lock.Dependencies["example.com/acme/span"]; got.Version != "v1.0.0" || got.VCS != lockfile.VCSGit {
+		t.Errorf("span without the workspace = %+v, want v1.0.0 from git", got)
+	}
+	if got := lock.Dependencies["example.com/acme/libc"]; got.Version != "v1.2.0" {
+		t.Errorf("libc without the workspace = %s, want v1.2.0", got.Version)
+	}
+}
+
+func TestForEachMember(t *testing.T) {
+	remotes := newTestRemotes(t)
+	remotes.publish("example.com/acme/libc", nil, "v1.0.0")
+
+	root := t.TempDir()
+	remotes.git(root, "init", "--quiet")
+	write := func(path, data string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	write(manifest.WorkspaceFileName, "apiVersion: cpkg.ringil.dev/v0\nkind: Workspace\nuse:\n  - a\n  - b\n")
+	for _, member := range []string{"a", "b"} {
+		write(member+"/cpkg.yaml", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/"+member+
+			"\ndepRoot: deps\ndependencies:\n  example.com/acme/libc:\n    version: ^1.0.0\n")
+	}
+
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+	var out, errOut bytes.Buffer
+	ctx := &clix.Context{App: &clix.App{Out: &out, Err: &errOut}}
+	if err := runTidy(ctx); err != nil {
+		t.Fatalf("runTidy() error = %v\n%s", err, out.String())
+	}
+
+	// The workspace is resolved once: b is tidied although libc is gone
+	// after a was
+	inWorkspace, err := forEachMember(ctx, func(ctx *clix.Context) error {
+		err := runTidy(ctx)
+		os.RemoveAll(filepath.Join(remotes.root, "example.com/acme/libc.git"))
+		os.RemoveAll(filepath.Join(remotes.root, "cache"))
+		return err
+	})
+	if !inWorkspace || err != nil {
+		t.Fatalf("forEachMember(runTidy) = %v, %v\n%s", inWorkspace, err, errOut.String())
+	}
+	if sharedResolutions != nil {
+		t.Error("the shared resolutions should be dropped after the run")
+	}
+
+	// A failing member does not stop the others
+	remotes.publish("example.com/acme/libc", nil, "v1.0.0")
+	t.Setenv(git.CacheEnvVar, filepath.Join(t.TempDir(), "cache"))
+	if err := os.Remove(filepath.Join(root, "a", lockfile.LockfileName)); err != nil {
+		t.Fatalf("failed to remove lockfile: %v", err)
+	}
+	out.Reset()
+	errOut.Reset()
+	err = runSync(ctx)
+	if err == nil || err.Error() != "failed in 1 of 2 workspace members" {
+		t.Errorf("runSync() error = %v, want one failed member", err)
+	}
+	if want := "✗ a: lockfile not found"; !strings.Contains(errOut.String(), want) {
+		t.Errorf("errors should contain %q:\n%s", want, errOut.String())
+	}
+	if want := "✓ example.com/acme/libc @ v1.0.0"; !strings.Contains(out.String(), "==> b\n") || !strings.Contains(out.String(), want) {
+		t.Errorf("output should contain b's sync:\n%s", out.String())
+	}
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"os"
+	"strconv"
+
 	"github.com/SCKelemen/clix"
 	"github.com/SCKelemen/clix/ext/help"
 	"github.com/SCKelemen/clix/ext/version"
 	"github.com/SCKelemen/cpkg/internal/format"
+	"github.com/SCKelemen/cpkg/internal/git"
 )
 
 // Version is set at build time via ldflags.
@@ -22,6 +26,18 @@ var Date = ""
 // GlobalFormatFlag holds the global --format flag value
 var GlobalFormatFlag string
 
+// GlobalFrozenFlag holds the global --frozen flag value
+var GlobalFrozenFlag bool
+
+// GlobalOfflineFlag holds the global --offline flag value
+var GlobalOfflineFlag bool
+
+// GlobalJobsFlag holds the global --jobs flag value
+var GlobalJobsFlag int
+
+// FrozenEnvVar enables frozen mode when set to a true value ("1", "true").
+const FrozenEnvVar = "CPKG_FROZEN"
+
 func NewApp() *clix.App {
 	app := clix.NewApp("cpkg",
 		clix.WithAppDescription("Source-only package manager for C"),
@@ -30,6 +46,7 @@ func NewApp() *clix.App {
 	app.Root = clix.NewGroup("cpkg", "Source-only package manager for C",
 		initCmd,
 		addCmd,
+		removeCmd,
 		tidyCmd,
 		syncCmd,
 		upgradeCmd,
@@ -38,9 +55,14 @@ func NewApp() *clix.App {
 		listCmd,
 		explainCmd,
 		checkCmd,
+		verifyCmd,
 		buildCmd,
 		testCmd,
 		graphCmd,
+		whyCmd,
+		cacheCmd,
+		manifestCmd,
+		lockCmd,
 	)
 
 	// Add global flags to root
@@ -48,10 +70,33 @@ func NewApp() *clix.App {
 	app.Root.Flags.StringVar(clix.StringVarOptions{
 		FlagOptions: clix.FlagOptions{
 			Name:  "format",
-			Usage: "Output format: text, json, or yaml (default: text)",
+			Usage: "Output format: text, json, or yaml; also dot, mermaid, and graphml for graph, and markdown for lock diff (default: text)",
 		},
 		Value: &GlobalFormatFlag,
 	})
+	app.Root.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "frozen",
+			Usage: "Never re-resolve; fail if the lockfile is out of date (also CPKG_FROZEN)",
+		},
+		Value: &GlobalFrozenFlag,
+	})
+	app.Root.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "offline",
+			Usage: "Never access the network; use only the module cache (also CPKG_OFFLINE)",
+		},
+		Value: &GlobalOfflineFlag,
+	})
+	app.Root.Flags.IntVar(clix.IntVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "jobs",
+			Short: "j",
+			Usage: "Maximum number of concurrent git operations (default: 8)",
+		},
+		Value: &GlobalJobsFlag,
+	})
+	setPreRun(app.Root, applyGlobalFlags)
 
 	// Add help extension for "cpkg help [command]"
 	app.AddExtension(help.Extension{})
@@ -70,3 +115,46 @@ func NewApp() *clix.App {
 func GetFormat() format.Format {
 	return format.GetFormatFromContext(GlobalFormatFlag)
 }
+
+// IsFrozen reports whether frozen mode is enabled by --frozen or CPKG_FROZEN.
+// Frozen, the lockfile is never re-resolved or rewritten: commands that would
+// change it fail instead.
+func IsFrozen() bool {
+	if GlobalFrozenFlag {
+		return true
+	}
+	frozen, _ := strconv.ParseBool(os.Getenv(FrozenEnvVar))
+	return frozen
+}
+
+// IsOffline reports whether offline mode is enabled by --offline or
+// CPKG_OFFLINE. See git.SetOffline.
+func IsOffline() bool {
+	return GlobalOfflineFlag || git.Offline()
+}
+
+// applyGlobalFlags passes global flags on to the packages they configure.
+func applyGlobalFlags(ctx *clix.Context) error {
+	if GlobalOfflineFlag {
+		git.SetOffline(true)
+	}
+	return nil
+}
+
+// setPreRun installs hook on cmd and all of its descendants. A command's own
+// PreRun is kept and runs after hook, if hook succeeds.
+func setPreRun(cmd *clix.Command, hook clix.Hook) {
+	if own := cmd.PreRun; own != nil {
+		cmd.PreRun = func(ctx *clix.Context) error {
+			if err := hook(ctx); err != nil {
+				return err
+			}
+			return own(ctx)
+		}
+	} else {
+		cmd.PreRun = hook
+	}
+	for _, child := range cmd.Children {
+		setPreRun(child, hook)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/SCKelemen/clix"
 	"github.com/SCKelemen/cpkg/internal/format"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
-	"github.com/SCKelemen/cpkg/internal/submodule"
 )
 
 var statusCmd = clix.NewCommand("status",
@@ -29,9 +29,17 @@ type statusDependency struct {
 	LockedVersion string `json:"locked_version" yaml:"locked_version"`
 	LocalVersion  string `json:"local_version" yaml:"local_version"`
 	Status        string `json:"status" yaml:"status"`
+	Replace       string `json:"replace,omitempty" yaml:"replace,omitempty"` // Replacement module@version or local directory
 }
 
 func runStatus(ctx *clix.Context) error {
+	if dirs, ok, err := patternDirs(ctx.Args); ok {
+		if err != nil {
+			return err
+		}
+		return runPattern(ctx, dirs, runStatus)
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -64,44 +72,32 @@ func runStatus(ctx *clix.Context) error {
 
 	// Check each dependency
 	for modulePath, dep := range m.Dependencies {
-		constraint := dep.Version
+		constraint := dep.Constraint()
 		lockedVersion := ""
 		localVersion := ""
+		replace := ""
 		status := "NO_LOCK"
 
 		if lockDep, exists := lock.Dependencies[modulePath]; exists {
 			lockedVersion = lockDep.Version
-			status = "OK"
-
-			// Check local submodule state
-			submodulePath := lockDep.Path
-			if !filepath.IsAbs(submodulePath) {
-				submodulePath = filepath.Join(filepath.Dir(manifestPath), submodulePath)
-			}
-
-			// Resolve symlinks (important for macOS)
-			resolvedPath, err := filepath.EvalSymlinks(submodulePath)
-			if err == nil {
-				submodulePath = resolvedPath
-			}
+			replace = lockDep.Replace
+			state := inspectLocalState(filepath.Dir(manifestPath), lockDep)
 
-			// Get relative path for git submodule commands
-			relPath := submodulePath
-			if filepath.IsAbs(submodulePath) {
-				realCwd, _ := filepath.EvalSymlinks(cwd)
-				if rel, err := filepath.Rel(realCwd, submodulePath); err == nil {
-					relPath = rel
-				}
-			}
-
-			if submodule.SubmoduleExists(relPath) {
-				currentCommit, err := submodule.GetSubmoduleCommit(submodulePath)
-				if err == nil {
-					lockedCommit := lockDep.Commit
-					if currentCommit == lockedCommit {
+			switch {
+			case !state.Exists:
+				localVersion = "MISSING"
+				status = "MISSING"
+			case lockDep.IsLocal():
+				// Replaced by a local directory: there is no submodule to compare
+				localVersion = "local"
+				status = "REPLACED"
+			default:
+				status = "OK"
+				if state.CurrentCommit != "" {
+					if state.InSync {
 						localVersion = lockedVersion
 					} else {
-						shortCommit := currentCommit
+						shortCommit := state.CurrentCommit
 						if len(shortCommit) > 7 {
 							shortCommit = shortCommit[:7]
 						}
@@ -109,16 +105,11 @@ func runStatus(ctx *clix.Context) error {
 						status = "OUT_OF_SYNC"
 					}
 
-					// Check if dirty
-					dirty, _ := submodule.IsSubmoduleDirty(submodulePath)
-					if dirty {
+					if state.Dirty {
 						localVersion += " (dirty)"
 						status = "DIRTY"
 					}
 				}
-			} else {
-				localVersion = "MISSING"
-				status = "MISSING"
 			}
 		} else {
 			localVersion = "MISSING"
@@ -130,6 +121,7 @@ func runStatus(ctx *clix.Context) error {
 			LockedVersion: lockedVersion,
 			LocalVersion:  localVersion,
 			Status:        status,
+			Replace:       replace,
 		})
 	}
 
@@ -147,7 +139,9 @@ func runStatus(ctx *clix.Context) error {
 
 	for _, dep := range deps {
 		locked := dep.LockedVersion
-		if locked == "" {
+		if dep.Replace != "" {
+			locked = strings.TrimSpace(locked + " => " + dep.Replace)
+		} else if locked == "" {
 			locked = "NO_LOCK"
 		}
 		local := dep.LocalVersion
@@ -1,17 +1,29 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/dirhash"
+	"github.com/SCKelemen/cpkg/internal/git"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
 	"github.com/SCKelemen/cpkg/internal/submodule"
 )
 
-var syncDepRoot string
+var (
+	syncDepRoot      string
+	syncDryRun       bool
+	syncKeep         bool
+	syncPruneUnknown bool
+)
 
 var syncCmd = clix.NewCommand("sync",
 	clix.WithCommandShort("Sync git submodules to match lockfile"),
@@ -29,9 +41,41 @@ func init() {
 		},
 		Value: &syncDepRoot,
 	})
+	syncCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "dry-run",
+			Usage: "Report submodules of removed dependencies without removing them",
+		},
+		Value: &syncDryRun,
+	})
+	syncCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "keep",
+			Usage: "Keep submodules of removed dependencies",
+		},
+		Value: &syncKeep,
+	})
+	syncCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "prune-unknown",
+			Usage: "Also remove submodules under the dependency root that cpkg did not add",
+		},
+		Value: &syncPruneUnknown,
+	})
 }
 
 func runSync(ctx *clix.Context) error {
+	if dirs, ok, err := patternDirs(ctx.Args); ok {
+		if err != nil {
+			return err
+		}
+		return runPattern(ctx, dirs, runSync)
+	}
+
+	if inWorkspace, err := forEachMember(ctx, runSync); inWorkspace {
+		return err
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -50,10 +94,51 @@ func runSync(ctx *clix.Context) error {
 		// We can't call tidy directly, so return error
 		return fmt.Errorf("lockfile not found, run 'cpkg tidy' first")
 	}
+	if IsFrozen() {
+		m, err := manifest.Load(manifestPath)
+		if err != nil {
+			return fmt.Errorf("failed to load manifest: %w", err)
+		}
+		if err := checkFrozen(m, filepath.Dir(manifestPath), lock); err != nil {
+			return err
+		}
+	}
 
 	// Note: depRoot is determined from lockfile, paths are already set in lock.Dependencies
 	// syncDepRoot is not used here as paths come from lockfile
+	return syncDependencies(ctx.App.Out, ctx.App.Err, cwd, manifestPath, lock, syncOptions{DryRun: syncDryRun, Keep: syncKeep, PruneUnknown: syncPruneUnknown})
+}
 
+// syncOptions controls what sync does with submodules under the dependency
+// root that are no longer in the lockfile. By default those cpkg added are
+// removed, and those it did not are kept with a warning.
+type syncOptions struct {
+	DryRun       bool // Report them, but do not remove them
+	Keep         bool // Leave them alone
+	PruneUnknown bool // Remove those cpkg did not add as well
+}
+
+// gitmodulesMu serializes the submodule operations that write .gitmodules and
+// the superproject's index and config, which git does not allow concurrently.
+var gitmodulesMu sync.Mutex
+
+// syncResult is the outcome of syncing one dependency.
+type syncResult struct {
+	added      bool
+	urlUpdated bool
+	local      bool
+	commit     string
+	err        error
+}
+
+// syncDependencies makes the submodules of the project match lock, syncing up
+// to --jobs dependencies concurrently. Adding submodules and updating their
+// URLs is serialized; fetching, checking out and verifying are not. Each
+// dependency is reported on out as soon as it is done, followed by a summary.
+// A failure does not stop the other dependencies: every failure is reported
+// and returned together. Submodules of dependencies that are no longer locked
+// are pruned afterwards, according to opts.
+func syncDependencies(out, errOut io.Writer, cwd, manifestPath string, lock *lockfile.Lockfile, opts syncOptions) error {
 	// Resolve symlinks in working directory (important for macOS where /tmp is a symlink)
 	realCwd, err := filepath.EvalSymlinks(cwd)
 	if err != nil {
@@ -64,71 +149,323 @@ func runSync(ctx *clix.Context) error {
 		realManifestPath = manifestPath // Fallback to original if resolution fails
 	}
 
-	// Sync each dependency
-	for modulePath, dep := range lock.Dependencies {
-		path := dep.Path
-		if !filepath.IsAbs(path) {
-			path = filepath.Join(filepath.Dir(realManifestPath), path)
+	top, err := submodule.Toplevel(filepath.Dir(realManifestPath))
+	if err != nil {
+		return err
+	}
+	if realTop, err := filepath.EvalSymlinks(top); err == nil {
+		top = realTop
+	}
+
+	modules := make([]string, 0, len(lock.Dependencies))
+	for modulePath := range lock.Dependencies {
+		modules = append(modules, modulePath)
+	}
+	sort.Strings(modules)
+
+	var outMu sync.Mutex
+	results := make([]syncResult, len(modules))
+	forEachParallel(len(modules), func(i int) error {
+		modulePath := modules[i]
+		dep := lock.Dependencies[modulePath]
+		result := syncDependency(modulePath, dep, realCwd, top, filepath.Dir(realManifestPath), errOut, &outMu)
+		results[i] = result
+
+		outMu.Lock()
+		defer outMu.Unlock()
+		switch {
+		case result.err != nil:
+			fmt.Fprintf(out, "✗ %s: %v\n", modulePath, result.err)
+		case result.local:
+			// Local replacements are used in place and never become submodules
+			fmt.Fprintf(out, "= %s => %s (local)\n", modulePath, dep.Replace)
+		default:
+			if result.added {
+				fmt.Fprintf(out, "+ %s\n", modulePath)
+			} else if result.urlUpdated {
+				fmt.Fprintf(out, "~ %s (URL updated)\n", modulePath)
+			}
+			shortCommit := result.commit
+			if len(shortCommit) > 7 {
+				shortCommit = shortCommit[:7]
+			}
+			fmt.Fprintf(out, "✓ %s @ %s (%s)\n", modulePath, lockedVersion(dep), shortCommit)
 		}
+		return nil
+	})
 
-		// Resolve symlinks in the path (important for macOS)
-		resolvedPath, err := submodule.ResolveSymlinks(path)
-		if err == nil {
-			path = resolvedPath
+	var errs []error
+	added, updated, local := 0, 0, 0
+	for i, result := range results {
+		switch {
+		case result.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", modules[i], result.err))
+		case result.local:
+			local++
+		case result.added:
+			added++
+		case result.urlUpdated:
+			updated++
 		}
-		// If resolution fails, use original path (might not exist yet)
+	}
+
+	pruned, pruneErrs := pruneSubmodules(out, errOut, filepath.Dir(realManifestPath), lock, opts)
+
+	fmt.Fprintf(out, "\nSynced %d of %d dependencies (%d added, %d URL updated, %d local)\n", len(modules)-len(errs), len(modules), added, updated, local)
+	if pruned > 0 {
+		fmt.Fprintf(out, "Removed %d submodules of dependencies no longer in %s\n", pruned, lockfile.LockfileName)
+	}
+
+	var syncErr error
+	if len(errs) > 0 {
+		syncErr = fmt.Errorf("%d of %d dependencies failed to sync:\n%w", len(errs), len(modules), errors.Join(errs...))
+	}
+	return errors.Join(append([]error{syncErr}, pruneErrs...)...)
+}
+
+// pruneSubmodules handles the submodules under the lockfile's dependency root
+// that belong to no locked dependency, e.g. because the dependency was removed
+// from cpkg.yaml: each one is reported with a "-" marker and, unless opts says
+// otherwise, removed. Only submodules cpkg added are removed; others, such as
+// ones added by hand, are kept with a warning on errOut unless
+// opts.PruneUnknown is set. In a workspace, the dependencies of every member
+// count as locked, as members may share a dependency root. A submodule with
+// local work is never removed. It returns the number of submodules removed
+// and an error for each one that could not be.
+func pruneSubmodules(out, errOut io.Writer, projectRoot string, lock *lockfile.Lockfile, opts syncOptions) (int, []error) {
+	if lock.DepRoot == "" {
+		return 0, nil
+	}
+	depRoot := filepath.Join(projectRoot, lock.DepRoot)
+
+	locked, err := lockedPaths(projectRoot, lock)
+	if err != nil {
+		return 0, []error{err}
+	}
+
+	top, err := submodule.Toplevel(projectRoot)
+	if err != nil {
+		return 0, []error{err}
+	}
+	if realTop, err := filepath.EvalSymlinks(top); err == nil {
+		top = realTop
+	}
+	submodules, err := submodule.ListSubmodules(top)
+	if err != nil {
+		return 0, []error{err}
+	}
 
-		// Check if submodule exists (use relative path from git root)
-		relPath, err := filepath.Rel(realCwd, path)
+	pruned := 0
+	var errs []error
+	for _, sub := range submodules {
+		// .gitmodules paths are relative to the top of the repository, which
+		// need not be the module's directory
+		path := filepath.Join(top, filepath.FromSlash(sub.Path))
+		rel, err := filepath.Rel(depRoot, path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") || locked[path] {
+			continue
+		}
+
+		// Submodules under the dependency root are named by module path
+		module := filepath.ToSlash(rel)
+		if sub.Module == "" && !opts.PruneUnknown {
+			fmt.Fprintf(errOut, "Warning: %s was not added by cpkg, keeping it; run sync with --prune-unknown to remove it\n", sub.Path)
+			continue
+		}
+		switch {
+		case opts.Keep:
+			fmt.Fprintf(out, "- %s (kept)\n", module)
+			continue
+		case opts.DryRun:
+			fmt.Fprintf(out, "- %s (would be removed)\n", module)
+			continue
+		}
+
+		if err := removeSubmodule(top, sub, path); err != nil {
+			fmt.Fprintf(out, "✗ %s: %v\n", module, err)
+			if errors.Is(err, errLocalWork) {
+				err = fmt.Errorf("%w; remove %s by hand or run sync with --keep", err, sub.Path)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", module, err))
+			continue
+		}
+		fmt.Fprintf(out, "- %s\n", module)
+		pruned++
+	}
+	return pruned, errs
+}
+
+// lockedPaths returns the checkout paths of the dependencies in lock, the
+// lockfile of the module in projectRoot, and, if the module is a workspace
+// member, in the lockfiles of the other members.
+func lockedPaths(projectRoot string, lock *lockfile.Lockfile) (map[string]bool, error) {
+	locked := make(map[string]bool)
+	add := func(root string, lock *lockfile.Lockfile) {
+		for _, dep := range lock.Dependencies {
+			path := dep.Path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(root, path)
+			}
+			locked[filepath.Clean(path)] = true
+		}
+	}
+	add(projectRoot, lock)
+
+	ws, _, err := loadWorkspace(projectRoot)
+	if err != nil || ws == nil {
+		return locked, err
+	}
+	for _, dir := range ws.Use {
+		root := filepath.Join(ws.Root, dir)
+		if root == projectRoot {
+			continue
+		}
+		memberLock, err := lockfile.Load(filepath.Join(root, lockfile.LockfileName))
 		if err != nil {
-			relPath = path // Fallback to absolute if relative fails
+			continue // Not tidied yet, so nothing of it was synced
 		}
-		exists := submodule.SubmoduleExists(relPath)
-		currentURL, _ := submodule.GetSubmoduleURL(relPath)
+		add(root, memberLock)
+	}
+	return locked, nil
+}
 
+// errLocalWork is returned by removeSubmodule for a checkout it will not
+// remove.
+var errLocalWork = errors.New("not removed, it has local changes or unpublished commits")
+
+// removeSubmodule removes sub, a submodule of the work tree whose top is top
+// checked out at path, unless the checkout has local work.
+func removeSubmodule(top string, sub submodule.Submodule, path string) error {
+	// A submodule that is not checked out has nothing to lose
+	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+		if dirty, err := submodule.HasLocalWork(path); err != nil || dirty {
+			return errLocalWork
+		}
+	}
+
+	gitmodulesMu.Lock()
+	defer gitmodulesMu.Unlock()
+	return submodule.RemoveSubmodule(top, sub)
+}
+
+// syncDependency adds or updates the submodule of one dependency and checks
+// out its locked commit. projectRoot is the directory of the manifest and top
+// the top of its work tree, which .gitmodules paths are relative to;
+// submodule commands take paths relative to cwd. Warnings are written to
+// errOut while holding outMu.
+func syncDependency(modulePath string, dep lockfile.Dependency, cwd, top, projectRoot string, errOut io.Writer, outMu *sync.Mutex) syncResult {
+	var result syncResult
+	if dep.IsLocal() {
+		result.local = true
+		return result
+	}
+
+	path := dep.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projectRoot, path)
+	}
+
+	// Resolve symlinks in the path (important for macOS)
+	resolvedPath, err := submodule.ResolveSymlinks(path)
+	if err == nil {
+		path = resolvedPath
+	}
+	// If resolution fails, use original path (might not exist yet)
+
+	// Use relative path for git submodule commands
+	relPath, err := filepath.Rel(cwd, path)
+	if err != nil {
+		relPath = path // Fallback to absolute if relative fails
+	}
+	topPath, err := filepath.Rel(top, path)
+	if err != nil {
+		topPath = path
+	}
+
+	// Bring the module cache up to date first, so that the clone made while
+	// holding the lock below only copies local objects
+	git.Mirror(dep.RepoURL)
+
+	gitmodulesMu.Lock()
+	err = func() error {
+		exists := submodule.SubmoduleExists(top, topPath)
+		currentURL, _ := submodule.GetSubmoduleURL(top, topPath)
 		if !exists {
-			// Add submodule (use relative path for git submodule add)
 			if err := submodule.EnsureDir(path); err != nil {
-				return fmt.Errorf("failed to create directory for %s: %w", modulePath, err)
+				return fmt.Errorf("failed to create directory: %w", err)
 			}
 			if err := submodule.AddSubmodule(dep.RepoURL, relPath); err != nil {
-				return fmt.Errorf("failed to add submodule %s: %w", modulePath, err)
+				return err
 			}
-			fmt.Fprintf(ctx.App.Out, "+ %s\n", modulePath)
+			result.added = true
 		} else if currentURL != dep.RepoURL {
-			// Update submodule URL
 			if err := submodule.SetSubmoduleURL(relPath, dep.RepoURL); err != nil {
-				return fmt.Errorf("failed to update submodule URL for %s: %w", modulePath, err)
+				return err
 			}
-			fmt.Fprintf(ctx.App.Out, "~ %s (URL updated)\n", modulePath)
+			result.urlUpdated = true
 		}
-
-		// Initialize if needed
-		if err := submodule.InitSubmodule(relPath); err != nil {
-			// Ignore error if already initialized
+		// Marked on every sync, so submodules added by earlier versions of
+		// cpkg are pruned too once their dependency is removed
+		if err := submodule.MarkSubmodule(top, topPath, modulePath); err != nil {
+			return err
 		}
+		// Initialize if needed; already initialized submodules report an error
+		submodule.InitSubmodule(relPath)
+		return nil
+	}()
+	gitmodulesMu.Unlock()
+	if err != nil {
+		result.err = err
+		return result
+	}
 
-		// Fetch tags and commits (use absolute path for git -C)
-		if err := submodule.FetchTags(path); err != nil {
-			return fmt.Errorf("failed to fetch tags for %s: %w", modulePath, err)
-		}
-		if err := submodule.FetchCommit(path); err != nil {
-			return fmt.Errorf("failed to fetch commits for %s: %w", modulePath, err)
-		}
+	// The locked content hash is of the files as committed
+	if err := submodule.DisableFilters(path); err != nil {
+		result.err = err
+		return result
+	}
 
-		// Checkout the locked commit (use absolute path for git -C)
-		if err := submodule.Checkout(path, dep.Commit); err != nil {
-			return fmt.Errorf("failed to checkout %s@%s: %w", modulePath, dep.Version, err)
-		}
+	// Fetch the locked commit, preferring the module cache (use absolute path for git -C)
+	if err := submodule.FetchLockedCommit(path, dep.RepoURL, dep.Commit); err != nil {
+		result.err = fmt.Errorf("failed to fetch %s: %w", dep.Version, err)
+		return result
+	}
+	if err := submodule.Checkout(path, dep.Commit); err != nil {
+		result.err = fmt.Errorf("failed to checkout %s: %w", dep.Version, err)
+		return result
+	}
 
-		// Get current commit for display (use absolute path for git -C)
-		currentCommit, _ := submodule.GetSubmoduleCommit(path)
-		shortCommit := currentCommit
-		if len(shortCommit) > 7 {
-			shortCommit = shortCommit[:7]
-		}
-		fmt.Fprintf(ctx.App.Out, "✓ %s @ %s (%s)\n", modulePath, dep.Version, shortCommit)
+	// Verify the checked-out source against the locked content hash
+	if err := verifySum(modulePath, dep, filepath.Join(path, dep.Subdir)); errors.Is(err, errUnverifiedSum) {
+		outMu.Lock()
+		fmt.Fprintf(errOut, "Warning: %v\n", err)
+		outMu.Unlock()
+	} else if err != nil {
+		result.err = err
+		return result
 	}
 
+	result.commit, _ = submodule.GetSubmoduleCommit(path)
+	return result
+}
+
+// errUnverifiedSum is returned by verifySum for lockfile entries whose sum is
+// not a content hash, e.g. from lockfiles written by earlier versions of cpkg.
+var errUnverifiedSum = errors.New("no content hash recorded")
+
+// verifySum checks that the files in dir, the source directory of dep, match
+// the content hash in the lockfile.
+func verifySum(modulePath string, dep lockfile.Dependency, dir string) error {
+	if !dirhash.IsHash(dep.Sum) {
+		return fmt.Errorf("%s: %w, run 'cpkg tidy' to record one", modulePath, errUnverifiedSum)
+	}
+	sum, err := git.HashCheckout(dir)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", modulePath, err)
+	}
+	if sum != dep.Sum {
+		return fmt.Errorf("checksum mismatch for %s@%s in %s:\n  lockfile: %s\n  on disk:  %s\nthe source does not match the locked content; it may have been modified locally, or the tag or mirror may serve different content",
+			modulePath, dep.Version, dir, dep.Sum, sum)
+	}
 	return nil
 }
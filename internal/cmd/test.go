@@ -41,6 +41,10 @@ func init() {
 }
 
 func runTest(ctx *clix.Context) error {
+	if inWorkspace, err := forEachMember(ctx, runTest); inWorkspace {
+		return err
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
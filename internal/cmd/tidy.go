@@ -1,13 +1,16 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/format"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
+	"github.com/SCKelemen/cpkg/internal/resolver"
 )
 
 var (
@@ -15,6 +18,12 @@ var (
 	tidyCheck   bool
 )
 
+// tidyConflictOutput is written in place of the summary when resolution fails
+// because of incompatible requirements and a structured format is requested.
+type tidyConflictOutput struct {
+	Conflict *resolver.ConflictError `json:"conflict" yaml:"conflict"`
+}
+
 var tidyCmd = clix.NewCommand("tidy",
 	clix.WithCommandShort("Resolve dependency graph and write lockfile"),
 	clix.WithCommandRun(func(ctx *clix.Context) error {
@@ -41,6 +50,17 @@ func init() {
 }
 
 func runTidy(ctx *clix.Context) error {
+	if dirs, ok, err := patternDirs(ctx.Args); ok {
+		if err != nil {
+			return err
+		}
+		return runPattern(ctx, dirs, runTidy)
+	}
+
+	if inWorkspace, err := forEachMember(ctx, runTidy); inWorkspace {
+		return err
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -69,9 +89,29 @@ func runTidy(ctx *clix.Context) error {
 	lockfilePath := filepath.Join(filepath.Dir(manifestPath), lockfile.LockfileName)
 	existingLock, _ := lockfile.Load(lockfilePath)
 
+	// Frozen, the lockfile is only checked against the manifest
+	if IsFrozen() {
+		if err := checkFrozen(m, filepath.Dir(manifestPath), existingLock); err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.App.Out, "%s is up to date\n", lockfile.LockfileName)
+		return nil
+	}
+
 	// Resolve dependencies
-	lock, err := resolveDependencies(m, depRoot)
+	lock, err := resolveDependencies(m, filepath.Dir(manifestPath), depRoot)
 	if err != nil {
+		var conflict *resolver.ConflictError
+		if errors.As(err, &conflict) && GetFormat() != format.FormatText {
+			if writeErr := format.Write(ctx.App.Out, GetFormat(), tidyConflictOutput{Conflict: conflict}); writeErr != nil {
+				return writeErr
+			}
+		}
+		return err
+	}
+
+	// Every project sharing the sums store must agree on each version's content
+	if err := checkSums(lock, tidyCheck); err != nil {
 		return err
 	}
 
@@ -81,8 +121,11 @@ func runTidy(ctx *clix.Context) error {
 	for modulePath, lockDep := range lock.Dependencies {
 		if existingLock != nil {
 			if existingDep, exists := existingLock.Dependencies[modulePath]; exists {
-				if existingDep.Version != lockDep.Version {
-					updatedDeps = append(updatedDeps, fmt.Sprintf("%s: %s → %s", modulePath, existingDep.Version, lockDep.Version))
+				if existingDep.Version != lockDep.Version || existingDep.Replace != lockDep.Replace {
+					updatedDeps = append(updatedDeps, fmt.Sprintf("%s: %s → %s", modulePath, lockedVersion(existingDep), lockedVersion(lockDep)))
+				} else if existingDep.Commit != "" && existingDep.Commit != lockDep.Commit {
+					fmt.Fprintf(ctx.App.Err, "Warning: %s@%s now points to commit %s instead of the locked %s; the tag may have been moved\n",
+						modulePath, lockDep.Version, lockDep.Commit, existingDep.Commit)
 				}
 			} else {
 				newDeps = append(newDeps, modulePath)
@@ -104,7 +147,11 @@ func runTidy(ctx *clix.Context) error {
 	// Print summary
 	if len(newDeps) > 0 {
 		for _, dep := range newDeps {
-			fmt.Fprintf(ctx.App.Out, "+ %s @ %s\n", dep, lock.Dependencies[dep].Version)
+			if lock.Dependencies[dep].Indirect {
+				fmt.Fprintf(ctx.App.Out, "+ %s @ %s (indirect)\n", dep, lockedVersion(lock.Dependencies[dep]))
+			} else {
+				fmt.Fprintf(ctx.App.Out, "+ %s @ %s\n", dep, lockedVersion(lock.Dependencies[dep]))
+			}
 		}
 	}
 	if len(updatedDeps) > 0 {
@@ -129,7 +176,7 @@ func runTidy(ctx *clix.Context) error {
 		}
 		for module, dep := range lock.Dependencies {
 			existingDep, exists := existingLock.Dependencies[module]
-			if !exists || existingDep.Version != dep.Version || existingDep.Commit != dep.Commit {
+			if !exists || existingDep.Version != dep.Version || existingDep.Commit != dep.Commit || existingDep.Sum != dep.Sum || existingDep.Replace != dep.Replace {
 				return fmt.Errorf("lockfile would change")
 			}
 		}
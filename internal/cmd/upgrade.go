@@ -4,18 +4,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/SCKelemen/clix"
-	"github.com/SCKelemen/cpkg/internal/git"
 	"github.com/SCKelemen/cpkg/internal/lockfile"
 	"github.com/SCKelemen/cpkg/internal/manifest"
-	"github.com/SCKelemen/cpkg/internal/modulepath"
+	"github.com/SCKelemen/cpkg/internal/resolver"
 	"github.com/SCKelemen/cpkg/internal/semver"
 )
 
 var (
-	upgradeAll    bool
-	upgradeDepRoot string
+	upgradeAll              bool
+	upgradeDepRoot          string
+	upgradeWriteConstraints bool
 )
 
 var upgradeCmd = clix.NewCommand("upgrade",
@@ -42,9 +44,20 @@ func init() {
 		},
 		Value: &upgradeDepRoot,
 	})
+	upgradeCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "write-constraints",
+			Usage: "Raise the constraints in cpkg.yaml to the upgraded versions",
+		},
+		Value: &upgradeWriteConstraints,
+	})
 }
 
 func runUpgrade(ctx *clix.Context) error {
+	if IsFrozen() {
+		return fmt.Errorf("cannot upgrade in frozen mode: %s would change", lockfile.LockfileName)
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -55,10 +68,21 @@ func runUpgrade(ctx *clix.Context) error {
 		return fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
 	}
 
-	m, err := manifest.Load(manifestPath)
+	doc, err := manifest.LoadDocument(manifestPath)
 	if err != nil {
 		return fmt.Errorf("failed to load manifest: %w", err)
 	}
+	m, err := doc.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
+	}
+
+	// Minimal version selection only moves when constraints move, so there is
+	// nothing to upgrade within the existing constraints unless they are
+	// raised too.
+	if m.Resolution == string(resolver.StrategyMinimal) && !upgradeWriteConstraints {
+		return fmt.Errorf("upgrade has no effect with 'resolution: minimal'; run it with --write-constraints or raise the constraint with 'cpkg add <module>@<version>' instead")
+	}
 
 	lockfilePath := filepath.Join(filepath.Dir(manifestPath), lockfile.LockfileName)
 	lock, err := lockfile.Load(lockfilePath)
@@ -66,46 +90,34 @@ func runUpgrade(ctx *clix.Context) error {
 		return fmt.Errorf("no lockfile found, run 'cpkg tidy' first: %w", err)
 	}
 
+	src, err := newGitSource(m, filepath.Dir(manifestPath))
+	if err != nil {
+		return err
+	}
+	src.Prefetch(sortedDependencies(m))
+
 	upgraded := false
 	updates := make(map[string]string) // module -> new version
 
 	// Check each dependency for updates
-	for modulePath, dep := range m.Dependencies {
+	for _, modulePath := range sortedDependencies(m) {
+		dep := m.Dependencies[modulePath]
 		lockDep, exists := lock.Dependencies[modulePath]
-		if !exists {
+		// Pinned dependencies follow their branch, commit or tag; tidy moves them
+		if !exists || lockDep.IsLocal() || dep.IsPinned() {
 			continue
 		}
 
 		currentVersion := lockDep.Version
 		constraint := dep.Version
 
-		// Parse module path to extract repo URL and subpath
-		mp, err := modulepath.ParseModulePath(modulePath)
+		// Excluded and retracted versions are never upgraded to
+		versionTags, err := src.Versions(modulePath)
 		if err != nil {
-			fmt.Fprintf(ctx.App.Err, "Warning: invalid module path %s: %v\n", modulePath, err)
+			fmt.Fprintf(ctx.App.Err, "Warning: %v\n", err)
 			continue
 		}
 
-		repoURL := git.ModulePathToRepoURL(mp.RepoURL)
-		allTags, err := git.LsRemoteTags(repoURL)
-		if err != nil {
-			fmt.Fprintf(ctx.App.Err, "Warning: failed to fetch tags for %s: %v\n", modulePath, err)
-			continue
-		}
-
-		// Filter tags for this subpath
-		tags := modulepath.FilterTagsForSubpath(allTags, mp.Subpath)
-
-		// Extract version from tags
-		var versionTags []string
-		for _, tag := range tags {
-			version, err := modulepath.ExtractVersionFromTag(tag, mp.Subpath)
-			if err != nil {
-				continue
-			}
-			versionTags = append(versionTags, version)
-		}
-
 		// Find latest compatible version
 		latestCompatible, err := findCompatibleVersion(versionTags, constraint)
 		if err != nil {
@@ -139,6 +151,15 @@ func runUpgrade(ctx *clix.Context) error {
 		return nil
 	}
 
+	if upgradeWriteConstraints {
+		if err := writeConstraints(ctx, doc, m, updates); err != nil {
+			return err
+		}
+		if err := doc.Save(manifestPath); err != nil {
+			return fmt.Errorf("failed to save manifest: %w", err)
+		}
+	}
+
 	// Run tidy to update lockfile
 	fmt.Fprintf(ctx.App.Out, "\nResolving dependencies...\n")
 	depRoot := upgradeDepRoot
@@ -156,7 +177,7 @@ func runUpgrade(ctx *clix.Context) error {
 
 	// Run sync to update submodules
 	fmt.Fprintf(ctx.App.Out, "Syncing submodules...\n")
-	if err := runSyncInternal(cwd, depRoot); err != nil {
+	if err := runSyncInternal(ctx.App.Out, ctx.App.Err, cwd, depRoot); err != nil {
 		return fmt.Errorf("failed to sync submodules: %w", err)
 	}
 
@@ -164,3 +185,59 @@ func runUpgrade(ctx *clix.Context) error {
 	return nil
 }
 
+// writeConstraints raises the constraint of each upgraded dependency in doc
+// so that it requires at least the version it was upgraded to, e.g. ^1.0.0
+// to ^1.3.0.
+func writeConstraints(ctx *clix.Context, doc *manifest.Document, m *manifest.Manifest, updates map[string]string) error {
+	modules := make([]string, 0, len(updates))
+	for modulePath := range updates {
+		modules = append(modules, modulePath)
+	}
+	sort.Strings(modules)
+
+	for _, modulePath := range modules {
+		constraint := m.Dependencies[modulePath].Version
+		raised, ok := raiseConstraint(constraint, updates[modulePath])
+		if !ok {
+			fmt.Fprintf(ctx.App.Err, "Warning: not rewriting constraint %q of %s; edit it by hand\n", constraint, modulePath)
+			continue
+		}
+		if raised == constraint {
+			continue
+		}
+		if err := doc.SetDependency(modulePath, manifest.Dependency{Version: raised}); err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.App.Out, "Raising %s in %s: %s → %s\n", modulePath, manifest.ManifestFileName, constraint, raised)
+	}
+	return nil
+}
+
+// raiseConstraint returns constraint with its lower bound raised to version,
+// keeping its operator and any "v" prefix: "^1.0.0", "~1.0" and ">=v1.0.0"
+// become "^1.3.0", "~1.0.4" and ">=v1.3.0". Other constraints, e.g. ranges
+// with an upper bound or unions, have no single bound to raise and are
+// reported with ok false.
+func raiseConstraint(constraint, version string) (string, bool) {
+	v, err := semver.Parse(version)
+	if err != nil {
+		return constraint, false
+	}
+	c := strings.TrimSpace(constraint)
+	for _, op := range []string{"^", "~", ">="} {
+		rest, found := strings.CutPrefix(c, op)
+		if !found {
+			continue
+		}
+		if rest = strings.TrimSpace(rest); rest == "" || strings.ContainsAny(rest, " <>=|,") {
+			return constraint, false
+		}
+		// Keep the constraint's "v" prefix, or lack of one
+		prefix := ""
+		if strings.HasPrefix(rest, "v") {
+			prefix = "v"
+		}
+		return op + prefix + v.String(), true
+	}
+	return constraint, false
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -65,6 +66,15 @@ func runVendor(ctx *clix.Context) error {
 	if err != nil {
 		return fmt.Errorf("no lockfile found, run 'cpkg tidy' first: %w", err)
 	}
+	if IsFrozen() {
+		m, err := manifest.Load(manifestPath)
+		if err != nil {
+			return fmt.Errorf("failed to load manifest: %w", err)
+		}
+		if err := checkFrozen(m, filepath.Dir(manifestPath), lock); err != nil {
+			return err
+		}
+	}
 
 	// Determine vendor root
 	vRoot := vendorRoot
@@ -99,6 +109,16 @@ func runVendor(ctx *clix.Context) error {
 			continue
 		}
 
+		// Never vendor sources that differ from what was locked. Local
+		// replacements are working copies and have no locked content.
+		if !dep.IsLocal() {
+			if err := verifySum(modulePath, dep, sourcePath); errors.Is(err, errUnverifiedSum) {
+				fmt.Fprintf(ctx.App.Err, "Warning: %v\n", err)
+			} else if err != nil {
+				return err
+			}
+		}
+
 		destPath := filepath.Join(vendorDir, modulePath)
 
 		// Create destination directory
@@ -134,14 +154,14 @@ func runVendor(ctx *clix.Context) error {
 			if err := os.Symlink(linkTarget, destPath); err != nil {
 				return fmt.Errorf("failed to create symlink for %s: %w", modulePath, err)
 			}
-			fmt.Fprintf(ctx.App.Out, "Symlinked %s @ %s\n", modulePath, dep.Version)
+			fmt.Fprintf(ctx.App.Out, "Symlinked %s @ %s\n", modulePath, lockedVersion(dep))
 			fmt.Fprintf(ctx.App.Out, "  %s -> %s\n", relDest, relSource)
 		} else {
 			// Copy directory (simplified - in production you might want to use a proper copy library)
 			if err := copyDir(sourcePath, destPath); err != nil {
 				return fmt.Errorf("failed to copy %s: %w", modulePath, err)
 			}
-			fmt.Fprintf(ctx.App.Out, "Vendored %s @ %s\n", modulePath, dep.Version)
+			fmt.Fprintf(ctx.App.Out, "Vendored %s @ %s\n", modulePath, lockedVersion(dep))
 			fmt.Fprintf(ctx.App.Out, "  %s <- %s\n", relDest, relSource)
 		}
 
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/dirhash"
+	"github.com/SCKelemen/cpkg/internal/format"
+	"github.com/SCKelemen/cpkg/internal/git"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+)
+
+var verifyCmd = clix.NewCommand("verify",
+	clix.WithCommandShort("Verify on-disk dependencies against the lockfile"),
+	clix.WithCommandLong("Check, without network access, that every dependency in the lockfile is checked out at its locked commit, has no local modifications, and matches its locked content hash. Exits non-zero if any dependency fails."),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runVerify(ctx)
+	}),
+)
+
+type verifyOutput struct {
+	Dependencies []verifyDependency `json:"dependencies" yaml:"dependencies"`
+	Verified     bool               `json:"verified" yaml:"verified"`
+}
+
+type verifyDependency struct {
+	Module        string   `json:"module" yaml:"module"`
+	Version       string   `json:"version" yaml:"version"`
+	Status        string   `json:"status" yaml:"status"` // OK, LOCAL, MISSING, OUT_OF_SYNC, DIRTY, SUM_MISMATCH or NO_SUM
+	Commit        string   `json:"commit,omitempty" yaml:"commit,omitempty"`
+	CurrentCommit string   `json:"current_commit,omitempty" yaml:"current_commit,omitempty"`
+	Sum           string   `json:"sum,omitempty" yaml:"sum,omitempty"`
+	CurrentSum    string   `json:"current_sum,omitempty" yaml:"current_sum,omitempty"`
+	Problems      []string `json:"problems,omitempty" yaml:"problems,omitempty"`
+}
+
+func runVerify(ctx *clix.Context) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	manifestPath, err := manifest.FindManifest(cwd)
+	if err != nil {
+		return fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
+	}
+
+	lockfilePath := filepath.Join(filepath.Dir(manifestPath), lockfile.LockfileName)
+	lock, err := lockfile.Load(lockfilePath)
+	if err != nil {
+		return fmt.Errorf("no lockfile found, run 'cpkg tidy' first: %w", err)
+	}
+
+	modules := make([]string, 0, len(lock.Dependencies))
+	for modulePath := range lock.Dependencies {
+		modules = append(modules, modulePath)
+	}
+	sort.Strings(modules)
+
+	output := verifyOutput{
+		Dependencies: make([]verifyDependency, 0, len(modules)),
+		Verified:     true,
+	}
+	failed := 0
+	for _, modulePath := range modules {
+		dep := verifyLocked(cwd, filepath.Dir(manifestPath), modulePath, lock.Dependencies[modulePath])
+		if len(dep.Problems) > 0 {
+			failed++
+			output.Verified = false
+		}
+		output.Dependencies = append(output.Dependencies, dep)
+	}
+
+	// Output in requested format
+	if outputFormat := GetFormat(); outputFormat != format.FormatText {
+		if err := format.Write(ctx.App.Out, outputFormat, output); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(ctx.App.Out, "%-50s %-15s %s\n", "MODULE", "VERSION", "STATUS")
+		fmt.Fprintf(ctx.App.Out, "%-50s %-15s %s\n",
+			"──────────────────────────────────────────────────",
+			"───────────────", "───────────────")
+		for _, dep := range output.Dependencies {
+			fmt.Fprintf(ctx.App.Out, "%-50s %-15s %s\n", dep.Module, dep.Version, dep.Status)
+			for _, problem := range dep.Problems {
+				fmt.Fprintf(ctx.App.Out, "  ✗ %s\n", problem)
+			}
+		}
+		if failed == 0 {
+			fmt.Fprintf(ctx.App.Out, "\nAll %d dependencies verified.\n", len(modules))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dependencies failed verification", failed, len(modules))
+	}
+	return nil
+}
+
+// verifyLocked checks a single lockfile entry against the working tree. The
+// status is the most severe problem found; every problem is listed.
+func verifyLocked(cwd, projectRoot, modulePath string, dep lockfile.Dependency) verifyDependency {
+	state := inspectLocalState(projectRoot, dep)
+	result := verifyDependency{
+		Module:        modulePath,
+		Version:       lockedVersion(dep),
+		Status:        "OK",
+		Commit:        dep.Commit,
+		CurrentCommit: state.CurrentCommit,
+		Sum:           dep.Sum,
+	}
+	fail := func(status, problem string, args ...interface{}) {
+		if len(result.Problems) == 0 {
+			result.Status = status
+		}
+		result.Problems = append(result.Problems, fmt.Sprintf(problem, args...))
+	}
+
+	if dep.IsLocal() {
+		// Local replacements are working copies with nothing locked to compare
+		result.Status = "LOCAL"
+		if !state.Exists {
+			fail("MISSING", "local directory %s does not exist", dep.Path)
+		}
+		return result
+	}
+
+	if !state.Exists {
+		fail("MISSING", "submodule %s is not checked out, run 'cpkg sync'", dep.Path)
+		return result
+	}
+	if !state.InSync {
+		current := state.CurrentCommit
+		if current == "" {
+			current = "unknown"
+		}
+		fail("OUT_OF_SYNC", "checked out at %s, locked at %s", current, dep.Commit)
+	}
+	if state.Dirty {
+		fail("DIRTY", "%s has uncommitted changes or untracked files", dep.Path)
+	}
+
+	if !dirhash.IsHash(dep.Sum) {
+		fail("NO_SUM", "no content hash recorded in the lockfile, run 'cpkg tidy'")
+		return result
+	}
+	sum, err := git.HashCheckout(state.SourceDir)
+	if err != nil {
+		fail("SUM_MISMATCH", "failed to hash %s: %v", state.SourceDir, err)
+		return result
+	}
+	result.CurrentSum = sum
+	if sum != dep.Sum {
+		fail("SUM_MISMATCH", "content hash %s does not match the locked %s", sum, dep.Sum)
+	}
+	return result
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/format"
+	"github.com/SCKelemen/cpkg/internal/lockfile"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+)
+
+var (
+	whyAll      bool
+	whyMaxPaths int
+)
+
+type whyOutput struct {
+	Module    string      `json:"module" yaml:"module"`
+	Version   string      `json:"version,omitempty" yaml:"version,omitempty"`
+	Paths     [][]whyStep `json:"paths" yaml:"paths"`
+	Truncated bool        `json:"truncated,omitempty" yaml:"truncated,omitempty"` // More paths than --max-paths exist
+}
+
+// whyStep is a module on a requirement path. Every step but the first, the
+// root module, is required by the step before it with Constraint.
+type whyStep struct {
+	Module     string `json:"module" yaml:"module"`
+	Version    string `json:"version,omitempty" yaml:"version,omitempty"`
+	Constraint string `json:"constraint,omitempty" yaml:"constraint,omitempty"`
+}
+
+var whyCmd = clix.NewCommand("why",
+	clix.WithCommandShort("Explain why a module is needed"),
+	clix.WithCommandLong("Show the shortest path of requirements from the root module to a module in lock.cpkg.yaml, or all paths with --all, with the constraint each step requires"),
+	clix.WithCommandRun(func(ctx *clix.Context) error {
+		return runWhy(ctx)
+	}),
+	clix.WithCommandArguments(
+		clix.NewArgument(
+			clix.WithArgName("module"),
+			clix.WithArgRequired(),
+		),
+	),
+)
+
+func init() {
+	whyCmd.Flags = clix.NewFlagSet("why")
+	whyCmd.Flags.BoolVar(clix.BoolVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "all",
+			Usage: "Show every path to the module, not only the shortest",
+		},
+		Value: &whyAll,
+	})
+	whyCmd.Flags.IntVar(clix.IntVarOptions{
+		FlagOptions: clix.FlagOptions{
+			Name:  "max-paths",
+			Usage: "With --all, stop after this many paths (default: 100, 0 for no limit)",
+		},
+		Default: "100",
+		Value:   &whyMaxPaths,
+	})
+}
+
+func runWhy(ctx *clix.Context) error {
+	if len(ctx.Args) == 0 {
+		return fmt.Errorf("module path required")
+	}
+	modulePath := ctx.Args[0]
+	if whyMaxPaths < 0 {
+		return fmt.Errorf("--max-paths must not be negative")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	manifestPath, err := manifest.FindManifest(cwd)
+	if err != nil {
+		return fmt.Errorf("no %s found: %w", manifest.ManifestFileName, err)
+	}
+
+	m, err := manifest.Load(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %w", err)
+	}
+
+	lockfilePath := filepath.Join(filepath.Dir(manifestPath), lockfile.LockfileName)
+	lock, err := lockfile.Load(lockfilePath)
+	if err != nil {
+		return fmt.Errorf("no lockfile found, run 'cpkg tidy' first: %w", err)
+	}
+
+	if _, ok := lock.Dependencies[modulePath]; !ok && modulePath != lock.Module {
+		return fmt.Errorf("%s is not required by %s (not in %s)", modulePath, lock.Module, lockfile.LockfileName)
+	}
+
+	g := newDependencyGraph(m, lock)
+	var paths [][]string
+	truncated := false
+	if whyAll {
+		paths, truncated = g.allPaths(modulePath, whyMaxPaths)
+	} else {
+		paths = [][]string{g.shortestPath(modulePath)}
+	}
+
+	output := whyOutput{
+		Module:    modulePath,
+		Version:   g.version(modulePath),
+		Paths:     make([][]whyStep, 0, len(paths)),
+		Truncated: truncated,
+	}
+	for _, path := range paths {
+		steps := []whyStep{{Module: path[0]}}
+		for i := 1; i < len(path); i++ {
+			steps = append(steps, whyStep{
+				Module:     path[i],
+				Version:    g.version(path[i]),
+				Constraint: g.constraint(path[i-1], path[i]),
+			})
+		}
+		output.Paths = append(output.Paths, steps)
+	}
+
+	outputFormat := GetFormat()
+	if outputFormat != format.FormatText {
+		return format.Write(ctx.App.Out, outputFormat, output)
+	}
+
+	// Text output: each path as a chain from the root module
+	fmt.Fprintf(ctx.App.Out, "# %s\n", strings.TrimSpace(output.Module+" "+output.Version))
+	for i, steps := range output.Paths {
+		if i > 0 {
+			fmt.Fprintln(ctx.App.Out)
+		}
+		fmt.Fprintf(ctx.App.Out, "%s\n", steps[0].Module)
+		for j, step := range steps[1:] {
+			line := fmt.Sprintf("%s└─ %s %s", strings.Repeat("   ", j), step.Module, step.Version)
+			if step.Constraint != "" {
+				line += fmt.Sprintf(" (%s)", step.Constraint)
+			}
+			fmt.Fprintln(ctx.App.Out, line)
+		}
+	}
+	switch {
+	case output.Truncated:
+		fmt.Fprintf(ctx.App.Out, "\nStopped after %d paths; raise --max-paths to see the rest\n", len(output.Paths))
+	case whyAll && len(output.Paths) > 1:
+		fmt.Fprintf(ctx.App.Out, "\n%d paths\n", len(output.Paths))
+	}
+	return nil
+}
+
+// constraint returns the constraint with which from requires to.
+func (g *dependencyGraph) constraint(from, to string) string {
+	for _, r := range g.edges[from] {
+		if r.Module == to {
+			return r.Constraint
+		}
+	}
+	return ""
+}
+
+// shortestPath returns a shortest requirement path from the root module to
+// module, inclusive. Of several equally short paths, the first in the order
+// of module paths is returned.
+func (g *dependencyGraph) shortestPath(module string) []string {
+	parents := map[string]string{g.lock.Module: ""}
+	queue := []string{g.lock.Module}
+	for len(queue) > 0 && module != g.lock.Module {
+		current := queue[0]
+		queue = queue[1:]
+		for _, r := range g.edges[current] {
+			if _, ok := parents[r.Module]; !ok {
+				parents[r.Module] = current
+				queue = append(queue, r.Module)
+			}
+		}
+		if _, ok := parents[module]; ok {
+			break
+		}
+	}
+	if _, ok := parents[module]; !ok {
+		return []string{module} // Not reachable from the root
+	}
+
+	path := []string{module}
+	for current := module; current != g.lock.Module; {
+		current = parents[current]
+		path = append([]string{current}, path...)
+	}
+	return path
+}
+
+// allPaths returns the requirement paths from the root module to module
+// that do not visit a module twice, shortest first. Their number grows
+// exponentially with shared dependencies, so the search stops after max paths
+// (0 for no limit) and reports whether there were more.
+func (g *dependencyGraph) allPaths(module string, max int) ([][]string, bool) {
+	// Only modules that lead to module are worth walking through
+	requiredBy := make(map[string][]string)
+	for from, requirements := range g.edges {
+		for _, r := range requirements {
+			requiredBy[r.Module] = append(requiredBy[r.Module], from)
+		}
+	}
+	leads := map[string]bool{module: true}
+	queue := []string{module}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, from := range requiredBy[current] {
+			if !leads[from] {
+				leads[from] = true
+				queue = append(queue, from)
+			}
+		}
+	}
+
+	var paths [][]string
+	truncated := false
+	onPath := make(map[string]bool)
+	var walk func(path []string)
+	walk = func(path []string) {
+		current := path[len(path)-1]
+		if current == module {
+			if max > 0 && len(paths) == max {
+				truncated = true
+				return
+			}
+			paths = append(paths, append([]string(nil), path...))
+			return
+		}
+		onPath[current] = true
+		for _, r := range g.edges[current] {
+			if truncated {
+				break
+			}
+			if leads[r.Module] && !onPath[r.Module] {
+				walk(append(path, r.Module))
+			}
+		}
+		onPath[current] = false
+	}
+	if leads[g.lock.Module] {
+		walk([]string{g.lock.Module})
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool { return len(paths[i]) < len(paths[j]) })
+	return paths, truncated
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/SCKelemen/clix"
+	"github.com/SCKelemen/cpkg/internal/manifest"
+	"github.com/SCKelemen/cpkg/internal/resolver"
+)
+
+// loadWorkspace returns the workspace the module in projectRoot is a member
+// of, and the workspace's members, or nil if there is no workspace. A module
+// inside a workspace that is not one of its members is an error, as it would
+// resolve its siblings differently from the members.
+func loadWorkspace(projectRoot string) (*manifest.Workspace, []manifest.Member, error) {
+	path := manifest.FindWorkspace(projectRoot)
+	if path == "" {
+		return nil, nil, nil
+	}
+	ws, err := manifest.LoadWorkspace(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := ws.MemberDir(projectRoot); !ok {
+		return nil, nil, fmt.Errorf("%s is inside the workspace in %s but not one of its members; add it to use, or set %s=off",
+			projectRoot, path, manifest.WorkspaceEnvVar)
+	}
+	members, err := ws.Members()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ws, members, nil
+}
+
+// newWorkspaceSource returns a source resolving the members of a workspace
+// together. Each member replaces its module with its directory, and the
+// replace and exclude directives of every member apply to all of them, with
+// local paths made relative to the workspace root. Members may not replace
+// the same module differently.
+func newWorkspaceSource(ws *manifest.Workspace, members []manifest.Member) (*gitSource, error) {
+	memberDirs := make(map[string]string)
+	for _, member := range members {
+		memberDirs[member.Manifest.Module] = member.Dir
+	}
+
+	var replacements []manifest.Replacement
+	declared := make(map[string]manifest.Replacement) // Old@OldVersion -> replacement
+	declaredBy := make(map[string]string)
+	exclusions := make(map[string][]string)
+	for _, member := range members {
+		memberReplacements, err := member.Manifest.Replacements()
+		if err != nil {
+			return nil, fmt.Errorf("workspace member %s: %w", member.Dir, err)
+		}
+		for _, r := range memberReplacements {
+			if _, ok := memberDirs[r.Old]; ok {
+				continue // Members are always used from the workspace
+			}
+			if r.IsLocal() && !filepath.IsAbs(r.Dir) {
+				r.Dir = filepath.Join(member.Dir, r.Dir)
+			}
+			key := r.Old + "@" + r.OldVersion
+			if other, ok := declared[key]; ok {
+				if other != r {
+					return nil, fmt.Errorf("workspace members %s and %s replace %s differently", declaredBy[key], member.Dir, r.Old)
+				}
+				continue
+			}
+			declared[key], declaredBy[key] = r, member.Dir
+			replacements = append(replacements, r)
+		}
+
+		memberExclusions, err := member.Manifest.Exclusions()
+		if err != nil {
+			return nil, fmt.Errorf("workspace member %s: %w", member.Dir, err)
+		}
+		for module, versions := range memberExclusions {
+			exclusions[module] = append(exclusions[module], versions...)
+		}
+	}
+	for _, member := range members {
+		replacements = append(replacements, manifest.Replacement{Old: member.Manifest.Module, Dir: member.Dir})
+	}
+	sort.Slice(replacements, func(i, j int) bool {
+		if replacements[i].Old != replacements[j].Old {
+			return replacements[i].Old < replacements[j].Old
+		}
+		return replacements[i].OldVersion < replacements[j].OldVersion
+	})
+
+	src := newSource(replacements, exclusions, ws.Root)
+	src.workspace = ws
+	src.members = members
+	return src, nil
+}
+
+// workspaceRequirements returns the requirements of a workspace, which
+// requires each of its members, and the resolution strategy they share.
+func (s *gitSource) workspaceRequirements() (map[string]string, string, error) {
+	requirements := make(map[string]string, len(s.members))
+	var resolution resolver.Strategy
+	for i, member := range s.members {
+		strategy, err := resolver.ParseStrategy(member.Manifest.Resolution)
+		if err != nil {
+			return nil, "", fmt.Errorf("workspace member %s: %w", member.Dir, err)
+		}
+		if i > 0 && strategy != resolution {
+			return nil, "", fmt.Errorf("workspace members %s and %s use different resolution strategies (%s and %s)",
+				s.members[0].Dir, member.Dir, resolution, strategy)
+		}
+		resolution = strategy
+		requirements[member.Manifest.Module] = ""
+	}
+	return requirements, string(resolution), nil
+}
+
+// requiredBy returns the modules of a resolution reachable from the
+// requirements of module, excluding module itself.
+func requiredBy(result *resolver.Result, module string) map[string]*resolver.Selection {
+	modules := make(map[string]*resolver.Selection)
+	var queue []string
+	if sel, ok := result.Modules[module]; ok {
+		for required := range sel.Requires {
+			queue = append(queue, required)
+		}
+	}
+	for len(queue) > 0 {
+		modulePath := queue[0]
+		queue = queue[1:]
+		sel, ok := result.Modules[modulePath]
+		if !ok || modules[modulePath] != nil {
+			continue
+		}
+		modules[modulePath] = sel
+		for required := range sel.Requires {
+			queue = append(queue, required)
+		}
+	}
+	delete(modules, module)
+	return modules
+}
+
+// sharedResolutions holds the resolution of each workspace, by root, while
+// forEachMember runs a command in every member, so that the workspace is
+// resolved once rather than once per member. The members' manifests are read
+// from disk then and do not change in between. It is nil otherwise.
+var sharedResolutions map[string]*sharedResolution
+
+// sharedResolution is the outcome of resolving a workspace and the source it
+// was resolved from, which caches the commits the lockfiles need.
+type sharedResolution struct {
+	src    *gitSource
+	result *resolver.Result
+	err    error
+}
+
+// forEachMember runs run in the directory of every member of the workspace,
+// in the order of use, if the current directory is the root of a workspace
+// that is not itself a module; it reports whether it was. Each member's
+// output is headed by its directory. A failing member does not stop the
+// others: each failure is reported on the error output as it happens, and an
+// error counting them is returned at the end.
+func forEachMember(ctx *clix.Context, run func(ctx *clix.Context) error) (bool, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	path := manifest.FindWorkspace(cwd)
+	if path == "" || filepath.Dir(path) != cwd {
+		return false, nil
+	}
+	if _, err := os.Stat(filepath.Join(cwd, manifest.ManifestFileName)); err == nil {
+		return false, nil // Commands run in the root module
+	}
+	ws, err := manifest.LoadWorkspace(path)
+	if err != nil {
+		return true, err
+	}
+	defer os.Chdir(cwd)
+	if sharedResolutions == nil {
+		sharedResolutions = make(map[string]*sharedResolution)
+		defer func() { sharedResolutions = nil }()
+	}
+
+	failed := 0
+	for i, dir := range ws.Use {
+		writeModuleHeading(ctx.App.Out, i, dir)
+		err := os.Chdir(filepath.Join(ws.Root, dir))
+		if err == nil {
+			err = run(ctx)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(ctx.App.Err, "✗ %s: %v\n", dir, err)
+		}
+	}
+	if failed > 0 {
+		return true, fmt.Errorf("failed in %d of %d workspace members", failed, len(ws.Use))
+	}
+	return true, nil
+}
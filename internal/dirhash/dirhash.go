@@ -0,0 +1,189 @@
+// Package dirhash computes the content hash recorded in the lockfile's sum
+// field.
+//
+// The hash ("h1:") covers every file of a dependency's source tree and
+// nothing else, so it can be computed both from a git commit at resolution
+// time and from a checkout on disk when verifying it:
+//
+//  1. Each file contributes one line, "<sha256 of contents>  <mode>  <name>\n",
+//     where name is the slash-separated path relative to the hashed directory
+//     and mode is normalized to git's file modes: 100644 for regular files,
+//     100755 for executables and 120000 for symlinks (whose contents are the
+//     link target).
+//  2. Lines are sorted by name and hashed with SHA-256.
+//  3. The result is "h1:" followed by the standard base64 encoding of that hash.
+//
+// Directories are not hashed themselves, so empty directories do not count,
+// and .git entries are skipped.
+package dirhash
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Prefix identifies the hash algorithm described in the package comment.
+const Prefix = "h1:"
+
+// Normalized file modes.
+const (
+	ModeRegular    = "100644"
+	ModeExecutable = "100755"
+	ModeSymlink    = "120000"
+)
+
+// File is a single file of a hashed tree.
+type File struct {
+	Name string            // Slash-separated path relative to the tree root
+	Mode string            // One of ModeRegular, ModeExecutable or ModeSymlink
+	Sum  [sha256.Size]byte // SHA-256 of the contents (the target for symlinks)
+}
+
+// Hash returns the h1 hash of files, in any order.
+func Hash(files []File) (string, error) {
+	sorted := make([]File, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	h := sha256.New()
+	for i, f := range sorted {
+		if strings.Contains(f.Name, "\n") {
+			return "", fmt.Errorf("file name contains a newline: %q", f.Name)
+		}
+		if i > 0 && sorted[i-1].Name == f.Name {
+			return "", fmt.Errorf("duplicate file name: %s", f.Name)
+		}
+		switch f.Mode {
+		case ModeRegular, ModeExecutable, ModeSymlink:
+		default:
+			return "", fmt.Errorf("unsupported mode %s for %s", f.Mode, f.Name)
+		}
+		fmt.Fprintf(h, "%x  %s  %s\n", f.Sum, f.Mode, f.Name)
+	}
+	return Prefix + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashDir returns the h1 hash of the files under dir.
+func HashDir(dir string) (string, error) {
+	var files []File
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil // A submodule's .git file
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, ok, err := fileAt(dir, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		if ok {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", dir, err)
+	}
+	return Hash(files)
+}
+
+// HashFiles returns the h1 hash of the named files under dir, given as
+// slash-separated paths relative to dir, e.g. the files git tracks there.
+// Names that do not exist are left out, so that a deleted file changes the
+// hash like any other change.
+func HashFiles(dir string, names []string) (string, error) {
+	files := make([]File, 0, len(names))
+	for _, name := range names {
+		f, ok, err := fileAt(dir, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to hash %s: %w", dir, err)
+		}
+		if ok {
+			files = append(files, f)
+		}
+	}
+	return Hash(files)
+}
+
+// fileAt reads the file name under dir for hashing. It reports false for
+// directories and files that cannot come from git, such as sockets.
+func fileAt(dir, name string) (File, bool, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	f := File{Name: name}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return f, false, err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return f, false, err
+		}
+		f.Mode = ModeSymlink
+		f.Sum = sha256.Sum256([]byte(filepath.ToSlash(target)))
+	case info.Mode().IsRegular():
+		f.Mode = ModeRegular
+		if info.Mode()&0111 != 0 {
+			f.Mode = ModeExecutable
+		}
+		if f.Sum, err = sumFile(path); err != nil {
+			return f, false, err
+		}
+	default:
+		return f, false, nil
+	}
+	return f, true, nil
+}
+
+// Sum returns the SHA-256 of the contents read from r.
+func Sum(r io.Reader) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+func sumFile(path string) ([sha256.Size]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	defer f.Close()
+	return Sum(f)
+}
+
+// IsHash reports whether sum is an h1 hash, as opposed to e.g. the commit
+// prefix placeholder written by earlier versions of cpkg.
+func IsHash(sum string) bool {
+	if !strings.HasPrefix(sum, Prefix) {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sum, Prefix))
+	return err == nil && len(decoded) == sha256.Size
+}
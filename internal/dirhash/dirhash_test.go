@@ -0,0 +1,125 @@
+package dirhash
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatalf("failed to chmod file: %v", err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	a := File{Name: "a.c", Mode: ModeRegular, Sum: sha256.Sum256([]byte("a"))}
+	b := File{Name: "inc/b.h", Mode: ModeRegular, Sum: sha256.Sum256([]byte("b"))}
+
+	h1, err := Hash([]File{a, b})
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	h2, _ := Hash([]File{b, a})
+	if h1 != h2 {
+		t.Errorf("hash depends on order: %s != %s", h1, h2)
+	}
+	if !IsHash(h1) {
+		t.Errorf("IsHash(%s) = false", h1)
+	}
+
+	a.Mode = ModeExecutable
+	if h3, _ := Hash([]File{a, b}); h3 == h1 {
+		t.Error("hash should change with the file mode")
+	}
+
+	if _, err := Hash([]File{a, a}); err == nil {
+		t.Error("expected an error for duplicate names")
+	}
+	if _, err := Hash([]File{{Name: "x", Mode: "100664"}}); err == nil {
+		t.Error("expected an error for an unnormalized mode")
+	}
+}
+
+func TestHashDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "src", "lib.c"), "int x;\n", 0644)
+	writeFile(t, filepath.Join(dir, "include", "lib.h"), "extern int x;\n", 0600)
+
+	want, err := Hash([]File{
+		{Name: "src/lib.c", Mode: ModeRegular, Sum: sha256.Sum256([]byte("int x;\n"))},
+		{Name: "include/lib.h", Mode: ModeRegular, Sum: sha256.Sum256([]byte("extern int x;\n"))},
+	})
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	got, err := HashDir(dir)
+	if err != nil {
+		t.Fatalf("HashDir() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("HashDir() = %s, want %s", got, want)
+	}
+
+	// .git entries and empty directories are not part of the tree
+	writeFile(t, filepath.Join(dir, ".git"), "gitdir: ../.git/modules/x\n", 0644)
+	os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+	if got, _ := HashDir(dir); got != want {
+		t.Errorf("HashDir() with .git and an empty directory = %s, want %s", got, want)
+	}
+
+	writeFile(t, filepath.Join(dir, "src", "lib.c"), "int y;\n", 0644)
+	if got, _ := HashDir(dir); got == want {
+		t.Error("hash should change with file contents")
+	}
+
+	if runtime.GOOS != "windows" {
+		writeFile(t, filepath.Join(dir, "src", "lib.c"), "int x;\n", 0755)
+		if got, _ := HashDir(dir); got == want {
+			t.Error("hash should change when a file becomes executable")
+		}
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "src", "lib.c"), "int x;\n", 0644)
+	writeFile(t, filepath.Join(dir, "build", "lib.o"), "object\n", 0644)
+
+	want, err := Hash([]File{{Name: "src/lib.c", Mode: ModeRegular, Sum: sha256.Sum256([]byte("int x;\n"))}})
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if got, err := HashFiles(dir, []string{"src/lib.c"}); err != nil || got != want {
+		t.Errorf("HashFiles() = %s, %v; want %s, only the named files", got, err, want)
+	}
+
+	// A missing file is left out, which changes the hash
+	if got, err := HashFiles(dir, []string{"src/lib.c", "src/gone.c"}); err != nil || got != want {
+		t.Errorf("HashFiles() with a missing file = %s, %v; want %s", got, err, want)
+	}
+	if got, _ := HashFiles(dir, []string{"src/gone.c"}); got == want {
+		t.Error("hash should change when a file is missing")
+	}
+}
+
+func TestIsHash(t *testing.T) {
+	for _, sum := range []string{"", "h1:0123456789abcdef", "h2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="} {
+		if IsHash(sum) {
+			t.Errorf("IsHash(%q) = true", sum)
+		}
+	}
+	if !IsHash("h1:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=") {
+		t.Error("IsHash() = false for a valid hash")
+	}
+}
@@ -1,6 +1,7 @@
 package format
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,8 +16,22 @@ const (
 	FormatText Format = "text"
 	FormatJSON Format = "json"
 	FormatYAML Format = "yaml"
+
+	// Graph formats, for dependency graphs only (see Graph)
+	FormatDOT     Format = "dot"
+	FormatMermaid Format = "mermaid"
+	FormatGraphML Format = "graphml"
+
+	// FormatMarkdown is for reports meant to be pasted into pull requests and
+	// issues, for output implementing Markdown only
+	FormatMarkdown Format = "markdown"
 )
 
+// Markdown is implemented by output that can be written as Markdown.
+type Markdown interface {
+	WriteMarkdown(w io.Writer) error
+}
+
 // ParseFormat parses a format string and returns the Format
 func ParseFormat(s string) (Format, error) {
 	switch s {
@@ -26,8 +41,16 @@ func ParseFormat(s string) (Format, error) {
 		return FormatJSON, nil
 	case "yaml":
 		return FormatYAML, nil
+	case "dot":
+		return FormatDOT, nil
+	case "mermaid":
+		return FormatMermaid, nil
+	case "graphml":
+		return FormatGraphML, nil
+	case "markdown":
+		return FormatMarkdown, nil
 	default:
-		return FormatText, fmt.Errorf("invalid format: %s (must be text, json, or yaml)", s)
+		return FormatText, fmt.Errorf("invalid format: %s (must be text, json, yaml, dot, mermaid, graphml, or markdown)", s)
 	}
 }
 
@@ -42,6 +65,14 @@ func Write(w io.Writer, format Format, data interface{}) error {
 		enc := yaml.NewEncoder(w)
 		defer enc.Close()
 		return enc.Encode(data)
+	case FormatDOT, FormatMermaid, FormatGraphML:
+		return writeGraph(w, format, data)
+	case FormatMarkdown:
+		md, ok := data.(Markdown)
+		if !ok {
+			return fmt.Errorf("format %s is only supported for lockfile diffs (cpkg lock diff)", format)
+		}
+		return md.WriteMarkdown(w)
 	case FormatText:
 		// Text format is handled by the command itself
 		return nil
@@ -50,6 +81,31 @@ func Write(w io.Writer, format Format, data interface{}) error {
 	}
 }
 
+// Decode parses output written by Write in the given format into a value
+// that Write encodes back the same way, e.g. to embed the output of one
+// command in a larger report. Field order is kept.
+func Decode(format Format, data []byte) (interface{}, error) {
+	switch format {
+	case FormatJSON:
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("invalid JSON output")
+		}
+		return json.RawMessage(bytes.TrimSpace(data)), nil
+	case FormatYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid YAML output: %w", err)
+		}
+		// Text parses as a YAML scalar; structured output never is one
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind == yaml.ScalarNode {
+			return nil, fmt.Errorf("invalid YAML output: expected a single mapping or sequence")
+		}
+		return doc.Content[0], nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
 // GetFormatFromContext extracts the format from a context or returns default
 func GetFormatFromContext(formatFlag string) Format {
 	if formatFlag == "" {
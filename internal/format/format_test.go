@@ -3,6 +3,8 @@ package format
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"strings"
 	"testing"
 
@@ -19,6 +21,10 @@ func TestParseFormat(t *testing.T) {
 		{"text format", "text", FormatText, false},
 		{"json format", "json", FormatJSON, false},
 		{"yaml format", "yaml", FormatYAML, false},
+		{"dot format", "dot", FormatDOT, false},
+		{"mermaid format", "mermaid", FormatMermaid, false},
+		{"graphml format", "graphml", FormatGraphML, false},
+		{"markdown format", "markdown", FormatMarkdown, false},
 		{"empty string defaults to text", "", FormatText, false},
 		{"invalid format", "invalid", FormatText, true},
 		{"xml format (invalid)", "xml", FormatText, true},
@@ -165,6 +171,28 @@ func TestWrite_InvalidFormat(t *testing.T) {
 	}
 }
 
+type markdownReport struct{ Title string }
+
+func (r markdownReport) WriteMarkdown(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "## %s\n", r.Title)
+	return err
+}
+
+func TestWrite_Markdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatMarkdown, markdownReport{Title: "Changes"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if buf.String() != "## Changes\n" {
+		t.Errorf("Write() = %q, want the output of WriteMarkdown", buf.String())
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatMarkdown, map[string]string{"key": "value"}); err == nil {
+		t.Error("Write() should fail for data without a Markdown form")
+	}
+}
+
 func TestWrite_ComplexStructure(t *testing.T) {
 	type Nested struct {
 		Value int `json:"value" yaml:"value"`
@@ -275,3 +303,52 @@ func TestWrite_EmptyData(t *testing.T) {
 	}
 }
 
+func TestDecode(t *testing.T) {
+	type inner struct {
+		Zeta  string `json:"zeta" yaml:"zeta"`
+		Alpha int    `json:"alpha" yaml:"alpha"`
+	}
+	type report struct {
+		Name   string      `json:"name" yaml:"name"`
+		Result interface{} `json:"result" yaml:"result"`
+	}
+
+	for _, f := range []Format{FormatJSON, FormatYAML} {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, f, inner{Zeta: "z", Alpha: 1}); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			decoded, err := Decode(f, buf.Bytes())
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			// Embedded in another value, the output keeps its fields and their order
+			var out bytes.Buffer
+			if err := Write(&out, f, report{Name: "a", Result: decoded}); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			var got struct {
+				Result inner `json:"result" yaml:"result"`
+			}
+			if f == FormatJSON {
+				err = json.Unmarshal(out.Bytes(), &got)
+			} else {
+				err = yaml.Unmarshal(out.Bytes(), &got)
+			}
+			if err != nil || got.Result != (inner{Zeta: "z", Alpha: 1}) {
+				t.Errorf("embedded output = %+v (err=%v):\n%s", got.Result, err, out.String())
+			}
+			if strings.Index(out.String(), "zeta") > strings.Index(out.String(), "alpha") {
+				t.Errorf("field order not kept:\n%s", out.String())
+			}
+		})
+	}
+
+	for _, f := range []Format{FormatJSON, FormatYAML} {
+		if _, err := Decode(f, []byte("+ example.com/acme/lib @ v1.0.0\nLockfile written\n")); err == nil {
+			t.Errorf("Decode(%s) of text output succeeded, want an error", f)
+		}
+	}
+}
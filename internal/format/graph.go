@@ -0,0 +1,161 @@
+package format
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Graph is a directed graph, written by Write in the graph formats (dot,
+// mermaid and graphml). Commands with other output than a graph do not
+// support these formats.
+type Graph struct {
+	Name  string
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// GraphNode is a node of a Graph. Its label may span several lines.
+type GraphNode struct {
+	ID        string
+	Label     string
+	Highlight bool // Drawn in a warning color
+}
+
+// GraphEdge is an edge between the nodes with IDs From and To, optionally
+// labeled.
+type GraphEdge struct {
+	From  string
+	To    string
+	Label string
+}
+
+// IsGraph reports whether the format can only be used for graphs.
+func (f Format) IsGraph() bool {
+	return f == FormatDOT || f == FormatMermaid || f == FormatGraphML
+}
+
+func writeGraph(w io.Writer, f Format, data interface{}) error {
+	var g *Graph
+	switch v := data.(type) {
+	case Graph:
+		g = &v
+	case *Graph:
+		g = v
+	default:
+		return fmt.Errorf("format %s is only supported for dependency graphs (cpkg graph)", f)
+	}
+
+	switch f {
+	case FormatDOT:
+		return writeDOT(w, g)
+	case FormatMermaid:
+		return writeMermaid(w, g)
+	default:
+		return writeGraphML(w, g)
+	}
+}
+
+// writeDOT writes g in the Graphviz DOT language.
+func writeDOT(w io.Writer, g *Graph) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph \"%s\" {\n", quote.Replace(g.Name))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  \"%s\" [label=\"%s\"", quote.Replace(n.ID), quote.Replace(n.Label))
+		if n.Highlight {
+			b.WriteString(", style=filled, fillcolor=\"#ffcccc\", color=\"#cc0000\"")
+		}
+		b.WriteString("];\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  \"%s\" -> \"%s\"", quote.Replace(e.From), quote.Replace(e.To))
+		if e.Label != "" {
+			fmt.Fprintf(&b, " [label=\"%s\"]", quote.Replace(e.Label))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeMermaid writes g as a Mermaid flowchart. Mermaid node IDs cannot hold
+// module paths, so nodes are numbered in order.
+func writeMermaid(w io.Writer, g *Graph) error {
+	quote := strings.NewReplacer(`"`, "#quot;", "\n", "<br/>")
+	ids := make(map[string]string, len(g.Nodes))
+	var highlighted []string
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for i, n := range g.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[n.ID] = id
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", id, quote.Replace(n.Label))
+		if n.Highlight {
+			highlighted = append(highlighted, id)
+		}
+	}
+	for _, e := range g.Edges {
+		from, ok := ids[e.From]
+		if !ok {
+			return fmt.Errorf("edge from unknown node %s", e.From)
+		}
+		to, ok := ids[e.To]
+		if !ok {
+			return fmt.Errorf("edge to unknown node %s", e.To)
+		}
+		if e.Label != "" {
+			fmt.Fprintf(&b, "  %s -->|\"%s\"| %s\n", from, quote.Replace(e.Label), to)
+		} else {
+			fmt.Fprintf(&b, "  %s --> %s\n", from, to)
+		}
+	}
+	if len(highlighted) > 0 {
+		b.WriteString("  classDef highlight fill:#ffcccc,stroke:#cc0000\n")
+		fmt.Fprintf(&b, "  class %s highlight\n", strings.Join(highlighted, ","))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGraphML writes g as GraphML, with the labels and highlighting as data
+// attributes of its nodes and edges.
+func writeGraphML(w io.Writer, g *Graph) error {
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	b.WriteString("  <key id=\"label\" for=\"node\" attr.name=\"label\" attr.type=\"string\"/>\n")
+	b.WriteString("  <key id=\"highlight\" for=\"node\" attr.name=\"highlight\" attr.type=\"boolean\"/>\n")
+	b.WriteString("  <key id=\"edgeLabel\" for=\"edge\" attr.name=\"label\" attr.type=\"string\"/>\n")
+	fmt.Fprintf(&b, "  <graph id=\"%s\" edgedefault=\"directed\">\n", escape(g.Name))
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "    <node id=\"%s\">\n", escape(n.ID))
+		fmt.Fprintf(&b, "      <data key=\"label\">%s</data>\n", escape(n.Label))
+		if n.Highlight {
+			b.WriteString("      <data key=\"highlight\">true</data>\n")
+		}
+		b.WriteString("    </node>\n")
+	}
+	for _, e := range g.Edges {
+		if e.Label == "" {
+			fmt.Fprintf(&b, "    <edge source=\"%s\" target=\"%s\"/>\n", escape(e.From), escape(e.To))
+			continue
+		}
+		fmt.Fprintf(&b, "    <edge source=\"%s\" target=\"%s\">\n", escape(e.From), escape(e.To))
+		fmt.Fprintf(&b, "      <data key=\"edgeLabel\">%s</data>\n", escape(e.Label))
+		b.WriteString("    </edge>\n")
+	}
+	b.WriteString("  </graph>\n")
+	b.WriteString("</graphml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,106 @@
+package format
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testGraph() Graph {
+	return Graph{
+		Name: "example.com/app",
+		Nodes: []GraphNode{
+			{ID: "example.com/app", Label: "example.com/app"},
+			{ID: "github.com/user/lib", Label: "github.com/user/lib\nv1.2.0"},
+			{ID: "github.com/user/repo/a", Label: `github.com/user/repo/a "quoted"`, Highlight: true},
+		},
+		Edges: []GraphEdge{
+			{From: "example.com/app", To: "github.com/user/lib", Label: "^1.0.0"},
+			{From: "github.com/user/lib", To: "github.com/user/repo/a"},
+		},
+	}
+}
+
+func TestWrite_Graph(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   []string
+	}{
+		{FormatDOT, []string{
+			`digraph "example.com/app" {`,
+			`"github.com/user/lib" [label="github.com/user/lib\nv1.2.0"];`,
+			`"github.com/user/repo/a" [label="github.com/user/repo/a \"quoted\"", style=filled`,
+			`"example.com/app" -> "github.com/user/lib" [label="^1.0.0"];`,
+			`"github.com/user/lib" -> "github.com/user/repo/a";`,
+		}},
+		{FormatMermaid, []string{
+			"graph LR\n",
+			`n1["github.com/user/lib<br/>v1.2.0"]`,
+			`n2["github.com/user/repo/a #quot;quoted#quot;"]`,
+			`n0 -->|"^1.0.0"| n1`,
+			"n1 --> n2\n",
+			"class n2 highlight\n",
+		}},
+		{FormatGraphML, []string{
+			`<graph id="example.com/app" edgedefault="directed">`,
+			`<node id="github.com/user/lib">`,
+			`<data key="label">github.com/user/repo/a &#34;quoted&#34;</data>`,
+			`<data key="highlight">true</data>`,
+			`<edge source="example.com/app" target="github.com/user/lib">`,
+			`<data key="edgeLabel">^1.0.0</data>`,
+			`<edge source="github.com/user/lib" target="github.com/user/repo/a"/>`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, tt.format, testGraph()); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+
+	t.Run("graphml is well-formed", func(t *testing.T) {
+		var buf bytes.Buffer
+		g := testGraph()
+		if err := Write(&buf, FormatGraphML, &g); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		dec := xml.NewDecoder(&buf)
+		for {
+			_, err := dec.Token()
+			if err != nil {
+				if err != io.EOF {
+					t.Fatalf("invalid GraphML: %v", err)
+				}
+				break
+			}
+		}
+	})
+}
+
+func TestWrite_GraphFormatRequiresGraph(t *testing.T) {
+	for _, f := range []Format{FormatDOT, FormatMermaid, FormatGraphML} {
+		var buf bytes.Buffer
+		if err := Write(&buf, f, map[string]string{"key": "value"}); err == nil {
+			t.Errorf("Write(%s) of a map should fail", f)
+		}
+	}
+}
+
+func TestWrite_MermaidUnknownNode(t *testing.T) {
+	g := testGraph()
+	g.Edges = append(g.Edges, GraphEdge{From: "example.com/app", To: "missing"})
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatMermaid, g); err == nil {
+		t.Error("Write() should fail for an edge to an unknown node")
+	}
+}
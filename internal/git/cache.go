@@ -0,0 +1,305 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CacheEnvVar overrides the module cache location. The value "off" disables
+// the cache, so every operation goes to the remote.
+const CacheEnvVar = "CPKG_CACHE"
+
+// CacheDir returns the module cache directory: $CPKG_CACHE, or cpkg under
+// the user's cache directory. It returns "" if the cache is disabled.
+//
+// The cache holds a bare mirror of every repository cpkg has fetched, under
+// git/<scheme>/<host>/<path>.git, shared by all projects on the machine. Listing tags,
+// reading manifests and hashing trees are answered from the mirrors, and
+// submodules are cloned with the mirror as a reference, so each repository is
+// only downloaded once.
+func CacheDir() (string, error) {
+	dir := os.Getenv(CacheEnvVar)
+	if dir == "off" {
+		return "", nil
+	}
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate the module cache, set %s: %w", CacheEnvVar, err)
+		}
+		dir = filepath.Join(userCache, "cpkg")
+	}
+	return dir, nil
+}
+
+// OfflineEnvVar enables offline mode when set to a true value ("1", "true").
+const OfflineEnvVar = "CPKG_OFFLINE"
+
+// ErrOffline is returned (wrapped) by operations that would need the network
+// in offline mode.
+var ErrOffline = errors.New("network access is disabled in offline mode")
+
+var offline bool
+
+// SetOffline turns offline mode on or off for this process, in addition to
+// CPKG_OFFLINE. Offline, repositories are only read from the module cache:
+// mirrors are never cloned or fetched, remotes are never queried, and anything
+// the cache does not hold fails with ErrOffline.
+func SetOffline(on bool) {
+	offline = on
+}
+
+// Offline reports whether offline mode is enabled.
+func Offline() bool {
+	if offline {
+		return true
+	}
+	on, _ := strconv.ParseBool(os.Getenv(OfflineEnvVar))
+	return on
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._/-]`)
+
+// MirrorDir returns where the mirror of repoURL lives in cacheDir:
+// git/<scheme>/<host>/<path>.git. The scheme is part of the key, so the same
+// path fetched over different transports, which need not be the same
+// repository or carry the same credentials, never shares a mirror. scp-like
+// URLs count as ssh and plain paths as file.
+func MirrorDir(cacheDir, repoURL string) string {
+	scheme, p := "file", repoURL
+	if i := strings.Index(p, "://"); i >= 0 {
+		scheme, p = strings.ToLower(p[:i]), p[i+3:]
+	} else if i := strings.Index(p, ":"); i >= 0 && !strings.Contains(p[:i], "/") {
+		scheme, p = "ssh", p[:i]+"/"+p[i+1:] // scp-like git@host:path
+	}
+	if i := strings.Index(p, "@"); i >= 0 && !strings.Contains(p[:i], "/") {
+		p = p[i+1:] // User info
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+
+	var parts []string
+	for _, part := range strings.Split(unsafePathChars.ReplaceAllString(p, "_"), "/") {
+		if part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	return filepath.Join(cacheDir, "git", unsafePathChars.ReplaceAllString(scheme, "_"), filepath.Join(parts...)+".git")
+}
+
+var (
+	mirrorsMu sync.Mutex
+	mirrors   = make(map[string]*mirrorState) // By mirror directory
+)
+
+// mirrorState serializes updates of one mirror within this process, so
+// different repositories can be cloned and fetched concurrently.
+type mirrorState struct {
+	mu      sync.Mutex // Held while the mirror is cloned or fetched
+	updated bool       // Fetched by this process
+}
+
+func mirrorStateFor(dir string) *mirrorState {
+	mirrorsMu.Lock()
+	defer mirrorsMu.Unlock()
+	state, ok := mirrors[dir]
+	if !ok {
+		state = &mirrorState{}
+		mirrors[dir] = state
+	}
+	return state
+}
+
+// Mirror returns the cache mirror of repoURL, cloning it on first use and
+// fetching it at most once per process so that new tags are seen. It returns
+// "" if the cache is disabled. Offline, the mirror is returned as it is, and it
+// is an error if there is none.
+func Mirror(repoURL string) (string, error) {
+	if Offline() {
+		if dir := cachedMirror(repoURL); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("%s is not in the module cache: %w", repoURL, ErrOffline)
+	}
+
+	cacheDir, err := CacheDir()
+	if err != nil || cacheDir == "" {
+		return "", err
+	}
+	dir := MirrorDir(cacheDir, repoURL)
+
+	state := mirrorStateFor(dir)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.updated {
+		return dir, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+		args := append([]string{"-C", dir, "fetch", "--quiet", "--prune", "origin"}, mirrorRefspecs...)
+		output, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("failed to update mirror of %s: %w\noutput: %s", repoURL, err, string(output))
+		}
+	} else if err := cloneMirror(repoURL, dir); err != nil {
+		return "", err
+	}
+
+	state.updated = true
+	return dir, nil
+}
+
+// queryMirror returns the mirror to answer queries about repoURL from, or ""
+// if the remote should be queried directly because the cache is disabled or
+// unusable. Offline, a repository that is not cached is an error instead.
+func queryMirror(repoURL string) (string, error) {
+	dir, err := Mirror(repoURL)
+	if err != nil && !Offline() {
+		return "", nil
+	}
+	return dir, err
+}
+
+// cachedMirror returns the existing cache mirror of repoURL without updating
+// it, or "" if there is none.
+func cachedMirror(repoURL string) string {
+	cacheDir, err := CacheDir()
+	if err != nil || cacheDir == "" {
+		return ""
+	}
+	dir := MirrorDir(cacheDir, repoURL)
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		return ""
+	}
+	return dir
+}
+
+// mirrorRefspecs are the refs kept in a mirror: branches and tags, but not
+// the pull request, review or other refs a hosting service may publish, which
+// can be far more numerous.
+var mirrorRefspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// cloneMirror clones repoURL into dir via a temporary directory, so an
+// interrupted clone never leaves a partial mirror behind.
+func cloneMirror(repoURL, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create module cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create module cache: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	output, err := exec.Command("git", "clone", "--quiet", "--bare", repoURL, tmp).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to mirror %s: %w\noutput: %s", repoURL, err, string(output))
+	}
+	// A bare clone records no refspec to fetch with
+	for i, refspec := range mirrorRefspecs {
+		args := []string{"-C", tmp, "config", "remote.origin.fetch", refspec}
+		if i > 0 {
+			args = []string{"-C", tmp, "config", "--add", "remote.origin.fetch", refspec}
+		}
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to configure mirror of %s: %w\noutput: %s", repoURL, err, string(output))
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		// Another process may have created the mirror first
+		if _, statErr := os.Stat(filepath.Join(dir, "HEAD")); statErr == nil {
+			return nil
+		}
+		return fmt.Errorf("failed to create mirror of %s: %w", repoURL, err)
+	}
+	return nil
+}
+
+// HasCommit reports whether the repository at gitDir contains commit.
+func HasCommit(gitDir, commit string) bool {
+	return exec.Command("git", "-C", gitDir, "cat-file", "-e", commit+"^{commit}").Run() == nil
+}
+
+// CachedRepo is a mirror in the module cache.
+type CachedRepo struct {
+	RepoURL string `json:"repo_url" yaml:"repo_url"`
+	Dir     string `json:"dir" yaml:"dir"`
+	Size    int64  `json:"size" yaml:"size"` // Bytes on disk
+}
+
+// ListCache returns the mirrors in cacheDir, sorted by directory.
+func ListCache(cacheDir string) ([]CachedRepo, error) {
+	var repos []CachedRepo
+	root := filepath.Join(cacheDir, "git")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() || !strings.HasSuffix(path, ".git") {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(path, "HEAD")); err != nil {
+			return nil
+		}
+
+		repo := CachedRepo{Dir: path}
+		if output, err := exec.Command("git", "-C", path, "config", "--get", "remote.origin.url").Output(); err == nil {
+			repo.RepoURL = strings.TrimSpace(string(output))
+		}
+		repo.Size, _ = dirSize(path)
+		repos = append(repos, repo)
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list module cache: %w", err)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Dir < repos[j].Dir })
+	return repos, nil
+}
+
+// CleanCache removes every mirror from cacheDir.
+func CleanCache(cacheDir string) error {
+	if err := os.RemoveAll(filepath.Join(cacheDir, "git")); err != nil {
+		return fmt.Errorf("failed to clean module cache: %w", err)
+	}
+	mirrorsMu.Lock()
+	mirrors = make(map[string]*mirrorState)
+	mirrorsMu.Unlock()
+	return nil
+}
+
+// VerifyMirror checks the integrity of the objects in a cache mirror.
+func VerifyMirror(dir string) error {
+	output, err := exec.Command("git", "-C", dir, "fsck", "--no-dangling", "--no-progress").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w\noutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,216 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirrorDir(t *testing.T) {
+	cacheDir := filepath.Join("cache")
+	tests := []struct {
+		repoURL string
+		want    string
+	}{
+		{"https://github.com/user/repo.git", "https/github.com/user/repo.git"},
+		{"https://github.com/user/repo", "https/github.com/user/repo.git"},
+		{"http://github.com/user/repo.git", "http/github.com/user/repo.git"},
+		{"git@github.com:user/repo.git", "ssh/github.com/user/repo.git"},
+		{"ssh://git@github.com/user/repo.git", "ssh/github.com/user/repo.git"},
+		{"ssh://git@git.internal:2222/team/hal.git", "ssh/git.internal_2222/team/hal.git"},
+		{"HTTPS://github.com/user/repo.git", "https/github.com/user/repo.git"},
+		{"file:///srv/git/repo.git", "file/srv/git/repo.git"},
+		{"/srv/git/repo.git", "file/srv/git/repo.git"},
+		{"https://example.com/../../etc.git", "https/example.com/etc.git"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repoURL, func(t *testing.T) {
+			want := filepath.Join(cacheDir, "git", filepath.FromSlash(tt.want))
+			if got := MirrorDir(cacheDir, tt.repoURL); got != want {
+				t.Errorf("MirrorDir() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "cpkg")
+	t.Setenv("GIT_AUTHOR_EMAIL", "cpkg@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "cpkg")
+	t.Setenv("GIT_COMMITTER_EMAIL", "cpkg@example.com")
+
+	root := t.TempDir()
+	cacheDir := filepath.Join(root, "cache")
+	t.Setenv(CacheEnvVar, cacheDir)
+
+	repo := filepath.Join(root, "repo")
+	run := func(args ...string) string {
+		t.Helper()
+		output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %v\noutput: %s", strings.Join(args, " "), err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	if output, err := exec.Command("git", "init", "--quiet", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\noutput: %s", err, output)
+	}
+	run("commit", "--quiet", "--allow-empty", "-m", "first")
+	run("tag", "v1.0.0")
+	run("update-ref", "refs/pull/1/head", "HEAD")
+	commit := run("rev-parse", "HEAD")
+	repoURL := "file://" + filepath.ToSlash(repo)
+
+	tags, err := LsRemoteTags(repoURL)
+	if err != nil || len(tags) != 1 || tags[0] != "v1.0.0" {
+		t.Fatalf("LsRemoteTags() = %v, %v", tags, err)
+	}
+	if got, err := GetCommitForTag(repoURL, "v1.0.0"); err != nil || got != commit {
+		t.Errorf("GetCommitForTag() = %s, %v; want %s", got, err, commit)
+	}
+
+	repos, err := ListCache(cacheDir)
+	if err != nil || len(repos) != 1 || repos[0].RepoURL != repoURL {
+		t.Fatalf("ListCache() = %+v, %v", repos, err)
+	}
+	if !HasCommit(repos[0].Dir, commit) {
+		t.Error("the mirror should contain the tagged commit")
+	}
+	if err := VerifyMirror(repos[0].Dir); err != nil {
+		t.Errorf("VerifyMirror() error = %v", err)
+	}
+
+	// Only branches and tags are mirrored, and refreshing prunes them
+	mirrorRefs := func() string {
+		t.Helper()
+		output, err := exec.Command("git", "-C", repos[0].Dir, "for-each-ref", "--format=%(refname)").Output()
+		if err != nil {
+			t.Fatalf("git for-each-ref failed: %v", err)
+		}
+		return string(output)
+	}
+	if refs := mirrorRefs(); strings.Contains(refs, "refs/pull/") {
+		t.Errorf("mirror refs after clone =\n%s\nwant branches and tags only", refs)
+	}
+	run("branch", "feature")
+	run("update-ref", "refs/pull/2/head", "HEAD")
+	mirrorsMu.Lock()
+	mirrors = make(map[string]*mirrorState)
+	mirrorsMu.Unlock()
+	if _, err := Mirror(repoURL); err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+	if refs := mirrorRefs(); !strings.Contains(refs, "refs/heads/feature\n") || strings.Contains(refs, "refs/pull/") {
+		t.Errorf("mirror refs after fetch =\n%s\nwant branches and tags only", refs)
+	}
+	run("branch", "--delete", "feature")
+	run("tag", "v1.1.0")
+	mirrorsMu.Lock()
+	mirrors = make(map[string]*mirrorState)
+	mirrorsMu.Unlock()
+	if _, err := Mirror(repoURL); err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+	if refs := mirrorRefs(); strings.Contains(refs, "refs/heads/feature\n") || !strings.Contains(refs, "refs/tags/v1.1.0\n") {
+		t.Errorf("mirror refs after fetch =\n%s\nwant the deleted branch pruned and the new tag", refs)
+	}
+
+	if err := CleanCache(cacheDir); err != nil {
+		t.Fatalf("CleanCache() error = %v", err)
+	}
+	if repos, _ := ListCache(cacheDir); len(repos) != 0 {
+		t.Errorf("ListCache() after CleanCache() = %+v", repos)
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	t.Setenv("LocalAppData", filepath.Join(home, "AppData", "Local"))
+
+	t.Setenv(CacheEnvVar, "")
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		t.Skipf("no user cache directory: %v", err)
+	}
+	if got, err := CacheDir(); err != nil || got != filepath.Join(userCache, "cpkg") {
+		t.Errorf("CacheDir() = %q, %v; want cpkg under %s", got, err, userCache)
+	}
+
+	t.Setenv(CacheEnvVar, filepath.Join(home, "custom"))
+	if got, err := CacheDir(); err != nil || got != filepath.Join(home, "custom") {
+		t.Errorf("CacheDir() = %q, %v; want the %s value", got, err, CacheEnvVar)
+	}
+
+	t.Setenv(CacheEnvVar, "off")
+	if got, err := CacheDir(); err != nil || got != "" {
+		t.Errorf("CacheDir() = %q, %v; want disabled", got, err)
+	}
+}
+
+// With the cache off, every query goes to the remote and nothing is mirrored.
+func TestCacheOff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "cpkg")
+	t.Setenv("GIT_AUTHOR_EMAIL", "cpkg@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "cpkg")
+	t.Setenv("GIT_COMMITTER_EMAIL", "cpkg@example.com")
+
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	t.Setenv("LocalAppData", filepath.Join(home, "AppData", "Local"))
+	t.Setenv(CacheEnvVar, "off")
+
+	repo := filepath.Join(root, "repo")
+	run := func(args ...string) string {
+		t.Helper()
+		output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %v\noutput: %s", strings.Join(args, " "), err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	if output, err := exec.Command("git", "init", "--quiet", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\noutput: %s", err, output)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "cpkg.yaml"), []byte("module: example.com/repo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", ".")
+	run("commit", "--quiet", "-m", "first")
+	run("tag", "v1.0.0")
+	commit := run("rev-parse", "HEAD")
+	repoURL := "file://" + filepath.ToSlash(repo)
+
+	if dir, err := Mirror(repoURL); err != nil || dir != "" {
+		t.Errorf("Mirror() = %q, %v; want no mirror", dir, err)
+	}
+	tags, err := LsRemoteTags(repoURL)
+	if err != nil || len(tags) != 1 || tags[0] != "v1.0.0" {
+		t.Errorf("LsRemoteTags() = %v, %v", tags, err)
+	}
+	if got, err := GetCommitForTag(repoURL, "v1.0.0"); err != nil || got != commit {
+		t.Errorf("GetCommitForTag() = %s, %v; want %s", got, err, commit)
+	}
+	if data, err := ReadFile(repoURL, commit, "cpkg.yaml"); err != nil || !strings.Contains(string(data), "example.com/repo") {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	if _, err := ComputeTreeHash(repoURL, commit, ""); err != nil {
+		t.Errorf("ComputeTreeHash() error = %v", err)
+	}
+
+	if _, err := os.Stat(home); !os.IsNotExist(err) {
+		t.Errorf("the home directory was written to with the cache off: %v", err)
+	}
+}
@@ -1,76 +1,321 @@
 package git
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/SCKelemen/cpkg/internal/dirhash"
 )
 
+// LsRemoteTags returns the tag names of repoURL, sorted. Use a RefsCache to
+// look up several tags or modules of the same repository.
 func LsRemoteTags(repoURL string) ([]string, error) {
-	cmd := exec.Command("git", "ls-remote", "--tags", repoURL)
-	output, err := cmd.Output()
+	refs, err := ListRefs(repoURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list remote tags: %w", err)
+		return nil, err
 	}
+	return refs.TagNames(), nil
+}
 
-	var tags []string
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// GetCommitForTag returns the commit tag points to in repoURL.
+func GetCommitForTag(repoURL, tag string) (string, error) {
+	return NewRefsCache().CommitForTag(repoURL, tag)
+}
+
+// GetCommitForBranch returns the commit at the head of branch.
+func GetCommitForBranch(repoURL, branch string) (string, error) {
+	return NewRefsCache().CommitForBranch(repoURL, branch)
+}
+
+// ResolveCommit expands a possibly abbreviated commit SHA to the full SHA.
+// Full SHAs are returned unchanged without contacting the remote.
+func ResolveCommit(repoURL, rev string) (string, error) {
+	if isFullSHA(rev) {
+		return strings.ToLower(rev), nil
+	}
+
+	var commit string
+	err := withRefs(repoURL, func(gitDir string) error {
+		output, err := exec.Command("git", "-C", gitDir, "rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+		if err != nil {
+			return fmt.Errorf("commit %s not found in %s", rev, repoURL)
 		}
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			continue
+		commit = strings.TrimSpace(string(output))
+		return nil
+	})
+	return commit, err
+}
+
+// CommitTime returns the committer time of commit.
+func CommitTime(repoURL, commit string) (time.Time, error) {
+	var t time.Time
+	err := withCommit(repoURL, commit, func(gitDir string) error {
+		output, err := exec.Command("git", "-C", gitDir, "show", "-s", "--format=%ct", commit).Output()
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", commit, err)
+		}
+		seconds, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid commit time for %s: %w", commit, err)
 		}
-		ref := parts[1]
-		if strings.HasPrefix(ref, "refs/tags/") {
-			tag := strings.TrimPrefix(ref, "refs/tags/")
-			// Remove ^{} suffix from annotated tags
-			tag = strings.TrimSuffix(tag, "^{}")
-			tags = append(tags, tag)
+		t = time.Unix(seconds, 0).UTC()
+		return nil
+	})
+	return t, err
+}
+
+func isFullSHA(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, r := range strings.ToLower(s) {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
 		}
 	}
+	return true
+}
 
-	return tags, nil
+// ReadFile returns the contents of path at commit in the repository at repoURL.
+// If the file does not exist at that commit, the returned error wraps
+// os.ErrNotExist.
+func ReadFile(repoURL, commit, path string) ([]byte, error) {
+	var data []byte
+	err := withCommit(repoURL, commit, func(gitDir string) error {
+		object := commit + ":" + path
+		if err := exec.Command("git", "-C", gitDir, "cat-file", "-e", object).Run(); err != nil {
+			return fmt.Errorf("%s not found at %s: %w", path, commit, os.ErrNotExist)
+		}
+		output, err := exec.Command("git", "-C", gitDir, "cat-file", "blob", object).Output()
+		if err != nil {
+			return fmt.Errorf("failed to read %s at %s: %w", path, commit, err)
+		}
+		data = output
+		return nil
+	})
+	return data, err
 }
 
-func GetCommitForTag(repoURL, tag string) (string, error) {
-	// Use ^{} to dereference annotated tags to get the actual commit
-	cmd := exec.Command("git", "ls-remote", repoURL, tag+"^{}")
-	output, err := cmd.Output()
+// HasPath reports whether path, a file or directory, exists at commit in the
+// repository at repoURL.
+func HasPath(repoURL, commit, path string) (bool, error) {
+	var exists bool
+	err := withCommit(repoURL, commit, func(gitDir string) error {
+		object := commit + ":" + strings.Trim(filepath.ToSlash(path), "/")
+		exists = exec.Command("git", "-C", gitDir, "cat-file", "-e", object).Run() == nil
+		return nil
+	})
+	return exists, err
+}
+
+// withCommit calls fn with the git directory of a repository containing
+// commit: the module cache mirror of repoURL if it has (or, once updated,
+// gets) the commit, otherwise a scratch bare repository the commit is fetched
+// into, which is removed afterwards. Offline, only the mirror is used.
+func withCommit(repoURL, commit string, fn func(gitDir string) error) error {
+	if dir := cachedMirror(repoURL); dir != "" && HasCommit(dir, commit) {
+		return fn(dir)
+	}
+	if Offline() {
+		return fmt.Errorf("commit %s of %s is not in the module cache: %w", commit, repoURL, ErrOffline)
+	}
+	if dir, err := Mirror(repoURL); err == nil && dir != "" && HasCommit(dir, commit) {
+		return fn(dir)
+	}
+
+	return withScratchRepo(func(gitDir string) error {
+		// Fetching a single commit by SHA is cheap but not every server allows
+		// it; fall back to fetching all branches and tags, which must contain
+		// any locked commit.
+		if err := exec.Command("git", "-C", gitDir, "fetch", "--quiet", "--depth", "1", repoURL, commit).Run(); err != nil {
+			if err := fetchRefs(gitDir, repoURL); err != nil {
+				return fmt.Errorf("failed to fetch %s from %s: %w", commit, repoURL, err)
+			}
+		}
+		return fn(gitDir)
+	})
+}
+
+// withRefs calls fn with the git directory of a repository holding all
+// branches and tags of repoURL: its module cache mirror, or a scratch bare
+// repository they are fetched into.
+func withRefs(repoURL string, fn func(gitDir string) error) error {
+	dir, err := queryMirror(repoURL)
 	if err != nil {
-		// Fallback: try without ^{} in case it's a lightweight tag
-		cmd = exec.Command("git", "ls-remote", repoURL, tag)
-		output, err = cmd.Output()
-		if err != nil {
-			return "", fmt.Errorf("failed to get commit for tag: %w", err)
+		return err
+	}
+	if dir != "" {
+		return fn(dir)
+	}
+	return withScratchRepo(func(gitDir string) error {
+		if err := fetchRefs(gitDir, repoURL); err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", repoURL, err)
 		}
+		return fn(gitDir)
+	})
+}
+
+func withScratchRepo(fn func(gitDir string) error) error {
+	gitDir, err := os.MkdirTemp("", "cpkg-fetch-")
+	if err != nil {
+		return fmt.Errorf("failed to create scratch repository: %w", err)
 	}
+	defer os.RemoveAll(gitDir)
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(lines) == 0 {
-		return "", fmt.Errorf("no commit found for tag %s", tag)
+	if output, err := exec.Command("git", "init", "--bare", "--quiet", gitDir).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to init scratch repository: %w\noutput: %s", err, string(output))
 	}
+	return fn(gitDir)
+}
 
-	parts := strings.Fields(lines[0])
-	if len(parts) < 1 {
-		return "", fmt.Errorf("invalid output from git ls-remote")
+func fetchRefs(gitDir, repoURL string) error {
+	output, err := exec.Command("git", "-C", gitDir, "fetch", "--quiet", repoURL,
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w\noutput: %s", err, string(output))
+	}
+	return nil
+}
+
+// ComputeTreeHash returns the dirhash (h1) content hash of the files under
+// subdir at commit, or of the whole tree if subdir is empty. It hashes the
+// blobs as committed, so it matches HashCheckout of a checkout only if that
+// checkout applied no line ending conversion or filter (e.g. Git LFS), as
+// checkouts made by cpkg sync do.
+func ComputeTreeHash(repoURL, commit, subdir string) (string, error) {
+	var sum string
+	err := withCommit(repoURL, commit, func(gitDir string) error {
+		files, err := treeFiles(gitDir, commit, subdir)
+		if err != nil {
+			return err
+		}
+		sum, err = dirhash.Hash(files)
+		return err
+	})
+	return sum, err
+}
+
+// HashCheckout returns the dirhash (h1) content hash of the files git tracks
+// under dir, a directory of a checkout, as they are on disk. Untracked and
+// ignored files, such as build outputs, and nested submodules are not part of
+// it, as they are not part of the tree ComputeTreeHash hashes. A directory
+// outside any work tree is hashed whole.
+func HashCheckout(dir string) (string, error) {
+	if exec.Command("git", "-C", dir, "rev-parse", "--is-inside-work-tree").Run() != nil {
+		return dirhash.HashDir(dir)
+	}
+	output, err := exec.Command("git", "-C", dir, "ls-files", "--stage", "-z").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to list files of %s: %w", dir, err)
 	}
 
-	return parts[0], nil
+	var names []string
+	for _, entry := range strings.Split(string(output), "\x00") {
+		if entry == "" {
+			continue
+		}
+		// "<mode> <object> <stage>\t<path>", with path relative to dir
+		meta, name, ok := strings.Cut(entry, "\t")
+		if !ok {
+			return "", fmt.Errorf("unexpected ls-files output: %q", entry)
+		}
+		if strings.HasPrefix(meta, "160000 ") {
+			continue // Gitlink
+		}
+		if len(names) > 0 && names[len(names)-1] == name {
+			continue // Unmerged entries are listed once per stage
+		}
+		names = append(names, name)
+	}
+	return dirhash.HashFiles(dir, names)
 }
 
-func ComputeTreeHash(repoURL, commit string) (string, error) {
-	// For v0, we use a simplified checksum based on the commit SHA
-	// In a full implementation, we'd want to compute a proper tree hash
-	// by cloning the repo and computing a hash of the tree contents
-	// For now, we use the commit SHA as the checksum (truncated for readability)
-	if len(commit) < 16 {
-		return "", fmt.Errorf("invalid commit SHA: %s", commit)
+// treeFiles lists the files under subdir at commit with the SHA-256 of their
+// contents. Gitlinks (nested submodules) are not files of the tree.
+func treeFiles(gitDir, commit, subdir string) ([]dirhash.File, error) {
+	treeish := commit
+	if subdir != "" {
+		treeish = commit + ":" + strings.Trim(filepath.ToSlash(subdir), "/")
+	}
+	output, err := exec.Command("git", "-C", gitDir, "ls-tree", "-r", "-z", treeish).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tree %s: %w", treeish, err)
+	}
+
+	var files []dirhash.File
+	var blobs []string
+	for _, entry := range strings.Split(string(output), "\x00") {
+		if entry == "" {
+			continue
+		}
+		// "<mode> <type> <object>\t<path>"
+		meta, name, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected ls-tree output: %q", entry)
+		}
+		if fields[1] != "blob" {
+			continue
+		}
+		mode := fields[0]
+		if mode != dirhash.ModeExecutable && mode != dirhash.ModeSymlink {
+			mode = dirhash.ModeRegular
+		}
+		files = append(files, dirhash.File{Name: name, Mode: mode})
+		blobs = append(blobs, fields[2])
+	}
+	if len(blobs) == 0 {
+		return files, nil
+	}
+
+	// Stream every blob through a single cat-file process
+	cmd := exec.Command("git", "-C", gitDir, "cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(strings.Join(blobs, "\n") + "\n")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to read blobs: %w", err)
+	}
+	r := bufio.NewReader(stdout)
+	for i := range files {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("failed to read blob %s: %w", blobs[i], err)
+		}
+		// "<object> blob <size>"
+		fields := strings.Fields(header)
+		if len(fields) != 3 || fields[1] != "blob" {
+			cmd.Wait()
+			return nil, fmt.Errorf("unexpected cat-file output: %q", header)
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("unexpected cat-file output: %q", header)
+		}
+		if files[i].Sum, err = dirhash.Sum(io.LimitReader(r, size)); err != nil {
+			cmd.Wait()
+			return nil, err
+		}
+		if _, err := r.Discard(1); err != nil { // Trailing newline
+			cmd.Wait()
+			return nil, err
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to read blobs: %w", err)
 	}
-	return "h1:" + commit[:16], nil
+	return files, nil
 }
 
 func ModulePathToRepoURL(modulePath string) string {
@@ -0,0 +1,153 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Refs are the tags and branches of a repository with the commit each one
+// points to. Annotated tags are peeled to the commit they tag.
+type Refs struct {
+	Tags     map[string]string // Tag name -> commit
+	Branches map[string]string // Branch name -> commit
+}
+
+// TagNames returns the names of the tags, sorted.
+func (r *Refs) TagNames() []string {
+	names := make([]string, 0, len(r.Tags))
+	for name := range r.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListRefs returns the tags and branches of repoURL with a single query: from
+// its module cache mirror, or with one ls-remote if the cache is disabled.
+func ListRefs(repoURL string) (*Refs, error) {
+	dir, err := queryMirror(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []byte
+	if dir != "" {
+		// %(*objectname) is the tagged object of an annotated tag
+		output, err = exec.Command("git", "-C", dir, "for-each-ref",
+			"--format=%(objectname) %(refname)%0a%(*objectname) %(refname)^{}",
+			"refs/tags/", "refs/heads/").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list refs of %s: %w", repoURL, err)
+		}
+	} else {
+		output, err = exec.Command("git", "ls-remote", "--tags", "--heads", repoURL).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list remote refs of %s: %w", repoURL, err)
+		}
+	}
+	return parseRefs(string(output)), nil
+}
+
+// parseRefs parses "<object> <ref>" lines as printed by ls-remote, where a
+// "<ref>^{}" line gives the commit an annotated tag points to.
+func parseRefs(output string) *Refs {
+	refs := &Refs{Tags: make(map[string]string), Branches: make(map[string]string)}
+	peeled := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue // Includes the empty peeled line of lightweight refs
+		}
+		object, ref := parts[0], parts[1]
+		switch {
+		case strings.HasPrefix(ref, "refs/tags/") && strings.HasSuffix(ref, "^{}"):
+			peeled[strings.TrimSuffix(strings.TrimPrefix(ref, "refs/tags/"), "^{}")] = object
+		case strings.HasPrefix(ref, "refs/tags/"):
+			refs.Tags[strings.TrimPrefix(ref, "refs/tags/")] = object
+		case strings.HasPrefix(ref, "refs/heads/"):
+			refs.Branches[strings.TrimPrefix(ref, "refs/heads/")] = object
+		}
+	}
+	for tag, commit := range peeled {
+		refs.Tags[tag] = commit
+	}
+	return refs
+}
+
+// RefsCache remembers the refs of each repository for the lifetime of a
+// command, so that modules sharing a repository (e.g. several subdirectories
+// of one monorepo) and repeated tag lookups cost a single query. It is safe
+// for concurrent use; concurrent requests for the same repository wait for
+// one query instead of each making their own.
+type RefsCache struct {
+	mu      sync.Mutex
+	entries map[string]*refsEntry
+}
+
+type refsEntry struct {
+	done chan struct{} // Closed once refs and err are set
+	refs *Refs
+	err  error
+}
+
+// NewRefsCache returns an empty RefsCache.
+func NewRefsCache() *RefsCache {
+	return &RefsCache{entries: make(map[string]*refsEntry)}
+}
+
+// Refs returns the refs of repoURL, listing them on first use.
+func (c *RefsCache) Refs(repoURL string) (*Refs, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[repoURL]
+	if !ok {
+		entry = &refsEntry{done: make(chan struct{})}
+		c.entries[repoURL] = entry
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-entry.done
+	} else {
+		entry.refs, entry.err = ListRefs(repoURL)
+		close(entry.done)
+	}
+	return entry.refs, entry.err
+}
+
+// Tags returns the tag names of repoURL, sorted.
+func (c *RefsCache) Tags(repoURL string) ([]string, error) {
+	refs, err := c.Refs(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	return refs.TagNames(), nil
+}
+
+// CommitForTag returns the commit tag points to in repoURL.
+func (c *RefsCache) CommitForTag(repoURL, tag string) (string, error) {
+	refs, err := c.Refs(repoURL)
+	if err != nil {
+		return "", err
+	}
+	commit, ok := refs.Tags[tag]
+	if !ok {
+		return "", fmt.Errorf("no commit found for tag %s", tag)
+	}
+	return commit, nil
+}
+
+// CommitForBranch returns the commit at the head of branch in repoURL.
+func (c *RefsCache) CommitForBranch(repoURL, branch string) (string, error) {
+	refs, err := c.Refs(repoURL)
+	if err != nil {
+		return "", err
+	}
+	commit, ok := refs.Branches[branch]
+	if !ok {
+		return "", fmt.Errorf("no branch %s in %s", branch, repoURL)
+	}
+	return commit, nil
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRefs(t *testing.T) {
+	output := "1111 refs/heads/main\n" +
+		"2222 refs/tags/v1.0.0\n" +
+		"3333 refs/tags/v1.1.0\n" +
+		"4444 refs/tags/v1.1.0^{}\n" +
+		" refs/tags/v1.0.0^{}\n"
+
+	refs := parseRefs(output)
+	if refs.Branches["main"] != "1111" {
+		t.Errorf("main = %q, want 1111", refs.Branches["main"])
+	}
+	if refs.Tags["v1.0.0"] != "2222" {
+		t.Errorf("lightweight tag v1.0.0 = %q, want 2222", refs.Tags["v1.0.0"])
+	}
+	if refs.Tags["v1.1.0"] != "4444" {
+		t.Errorf("annotated tag v1.1.0 = %q, want the peeled commit 4444", refs.Tags["v1.1.0"])
+	}
+	if got := strings.Join(refs.TagNames(), " "); got != "v1.0.0 v1.1.0" {
+		t.Errorf("TagNames() = %s", got)
+	}
+}
+
+func TestRefsCache(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "cpkg")
+	t.Setenv("GIT_AUTHOR_EMAIL", "cpkg@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "cpkg")
+	t.Setenv("GIT_COMMITTER_EMAIL", "cpkg@example.com")
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	run := func(args ...string) string {
+		t.Helper()
+		output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %v\noutput: %s", strings.Join(args, " "), err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	if output, err := exec.Command("git", "init", "--quiet", "--initial-branch", "main", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\noutput: %s", err, output)
+	}
+	run("commit", "--quiet", "--allow-empty", "-m", "first")
+	run("tag", "-a", "-m", "release", "v1.0.0")
+	commit := run("rev-parse", "HEAD")
+	repoURL := "file://" + filepath.ToSlash(repo)
+
+	// Both from a mirror and straight from the remote
+	for _, cache := range []string{filepath.Join(t.TempDir(), "cache"), "off"} {
+		t.Run("cache="+filepath.Base(cache), func(t *testing.T) {
+			t.Setenv(CacheEnvVar, cache)
+			c := NewRefsCache()
+			if got, err := c.CommitForTag(repoURL, "v1.0.0"); err != nil || got != commit {
+				t.Errorf("CommitForTag() = %s, %v; want %s", got, err, commit)
+			}
+			if got, err := c.CommitForBranch(repoURL, "main"); err != nil || got != commit {
+				t.Errorf("CommitForBranch() = %s, %v; want %s", got, err, commit)
+			}
+			if _, err := c.CommitForTag(repoURL, "v9.9.9"); err == nil {
+				t.Error("CommitForTag() of a missing tag should fail")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package git
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -49,4 +50,20 @@ func IsGitRepo(dir string) bool {
 	return err == nil || filepath.IsAbs(gitDir)
 }
 
-
+// ShowFile returns the contents of path, relative to dir, at rev in the
+// repository containing dir, e.g. a lockfile as of HEAD. If the file does not
+// exist at rev, the returned error wraps os.ErrNotExist.
+func ShowFile(dir, rev, path string) ([]byte, error) {
+	if err := exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}").Run(); err != nil {
+		return nil, fmt.Errorf("unknown revision %s", rev)
+	}
+	object := rev + ":./" + filepath.ToSlash(path)
+	if err := exec.Command("git", "-C", dir, "cat-file", "-e", object).Run(); err != nil {
+		return nil, fmt.Errorf("%s not found at %s: %w", path, rev, os.ErrNotExist)
+	}
+	output, err := exec.Command("git", "-C", dir, "cat-file", "blob", object).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at %s: %w", path, rev, err)
+	}
+	return output, nil
+}
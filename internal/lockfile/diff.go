@@ -0,0 +1,153 @@
+package lockfile
+
+import (
+	"sort"
+
+	"github.com/SCKelemen/cpkg/internal/semver"
+)
+
+// Kinds of change reported by Diff.
+const (
+	ChangeAdded      = "added"
+	ChangeRemoved    = "removed"
+	ChangeUpgraded   = "upgraded"
+	ChangeDowngraded = "downgraded"
+	ChangeChanged    = "changed" // Same version, but another commit, repository or replacement
+)
+
+// Semantic version levels of an upgrade or downgrade.
+const (
+	LevelMajor      = "major"
+	LevelMinor      = "minor"
+	LevelPatch      = "patch"
+	LevelPrerelease = "prerelease"
+)
+
+// Change is the difference between two lockfiles for one module. Fields of
+// the old or new entry are empty for added and removed modules.
+type Change struct {
+	Module string `json:"module" yaml:"module"`
+	Change string `json:"change" yaml:"change"`
+	// Level is the most significant part of the version that changed, for
+	// upgrades and downgrades between semantic versions.
+	Level      string `json:"level,omitempty" yaml:"level,omitempty"`
+	OldVersion string `json:"oldVersion,omitempty" yaml:"oldVersion,omitempty"`
+	NewVersion string `json:"newVersion,omitempty" yaml:"newVersion,omitempty"`
+	OldCommit  string `json:"oldCommit,omitempty" yaml:"oldCommit,omitempty"`
+	NewCommit  string `json:"newCommit,omitempty" yaml:"newCommit,omitempty"`
+	OldRepoURL string `json:"oldRepoURL,omitempty" yaml:"oldRepoURL,omitempty"`
+	NewRepoURL string `json:"newRepoURL,omitempty" yaml:"newRepoURL,omitempty"`
+	OldReplace string `json:"oldReplace,omitempty" yaml:"oldReplace,omitempty"`
+	NewReplace string `json:"newReplace,omitempty" yaml:"newReplace,omitempty"`
+	Indirect   bool   `json:"indirect,omitempty" yaml:"indirect,omitempty"` // In the new lockfile, or the old one if removed
+}
+
+// RepoURLChanged reports whether the module moved to another repository.
+func (c Change) RepoURLChanged() bool {
+	return c.OldRepoURL != "" && c.NewRepoURL != "" && c.OldRepoURL != c.NewRepoURL
+}
+
+// CommitChanged reports whether the module is locked at another commit.
+func (c Change) CommitChanged() bool {
+	return c.OldCommit != "" && c.NewCommit != "" && c.OldCommit != c.NewCommit
+}
+
+// Diff returns the changes from the dependencies of old to those of new,
+// sorted by module. Modules locked identically in both are left out; the sum
+// and paths are not compared, as they follow from the commit.
+func Diff(old, new *Lockfile) []Change {
+	modules := make(map[string]bool)
+	for module := range old.Dependencies {
+		modules[module] = true
+	}
+	for module := range new.Dependencies {
+		modules[module] = true
+	}
+	sorted := make([]string, 0, len(modules))
+	for module := range modules {
+		sorted = append(sorted, module)
+	}
+	sort.Strings(sorted)
+
+	changes := []Change{}
+	for _, module := range sorted {
+		o, inOld := old.Dependencies[module]
+		n, inNew := new.Dependencies[module]
+		switch {
+		case !inOld:
+			changes = append(changes, Change{
+				Module:     module,
+				Change:     ChangeAdded,
+				NewVersion: n.Version,
+				NewCommit:  n.Commit,
+				NewRepoURL: n.RepoURL,
+				NewReplace: n.Replace,
+				Indirect:   n.Indirect,
+			})
+		case !inNew:
+			changes = append(changes, Change{
+				Module:     module,
+				Change:     ChangeRemoved,
+				OldVersion: o.Version,
+				OldCommit:  o.Commit,
+				OldRepoURL: o.RepoURL,
+				OldReplace: o.Replace,
+				Indirect:   o.Indirect,
+			})
+		default:
+			if o.Version == n.Version && o.Commit == n.Commit && o.RepoURL == n.RepoURL && o.Replace == n.Replace {
+				continue
+			}
+			c := Change{
+				Module:     module,
+				Change:     ChangeChanged,
+				OldVersion: o.Version,
+				NewVersion: n.Version,
+				OldCommit:  o.Commit,
+				NewCommit:  n.Commit,
+				OldRepoURL: o.RepoURL,
+				NewRepoURL: n.RepoURL,
+				OldReplace: o.Replace,
+				NewReplace: n.Replace,
+				Indirect:   n.Indirect,
+			}
+			if o.Version != n.Version {
+				c.Change, c.Level = compareVersions(o.Version, n.Version)
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// compareVersions returns whether going from old to new is an upgrade or a
+// downgrade, and at which level. Versions that are not semantic versions are
+// only reported as changed.
+func compareVersions(old, new string) (string, string) {
+	o, err := semver.Parse(old)
+	if err != nil {
+		return ChangeChanged, ""
+	}
+	n, err := semver.Parse(new)
+	if err != nil {
+		return ChangeChanged, ""
+	}
+
+	change := ChangeUpgraded
+	cmp := n.Compare(o)
+	if cmp < 0 {
+		change = ChangeDowngraded
+	} else if cmp == 0 {
+		return ChangeChanged, "" // Only the build metadata differs
+	}
+	switch {
+	case o.Major != n.Major:
+		return change, LevelMajor
+	case o.Minor != n.Minor:
+		return change, LevelMinor
+	case o.Patch != n.Patch:
+		return change, LevelPatch
+	default:
+		return change, LevelPrerelease
+	}
+}
@@ -0,0 +1,73 @@
+package lockfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Lockfile{Dependencies: map[string]Dependency{
+		"example.com/acme/same":    {Version: "v1.0.0", Commit: "aaa", RepoURL: "https://example.com/acme/same.git", Sum: "h1:old"},
+		"example.com/acme/gone":    {Version: "v0.3.0", Commit: "bbb", RepoURL: "https://example.com/acme/gone.git", Indirect: true},
+		"example.com/acme/major":   {Version: "v1.9.0", Commit: "c1", RepoURL: "https://example.com/acme/major.git"},
+		"example.com/acme/minor":   {Version: "v1.2.0", Commit: "d1", RepoURL: "https://example.com/acme/minor.git"},
+		"example.com/acme/down":    {Version: "v1.2.3", Commit: "e1", RepoURL: "https://example.com/acme/down.git"},
+		"example.com/acme/rc":      {Version: "v2.0.0-rc.1", Commit: "f1", RepoURL: "https://example.com/acme/rc.git"},
+		"example.com/acme/retag":   {Version: "v1.0.0", Commit: "g1", RepoURL: "https://example.com/acme/retag.git"},
+		"example.com/acme/moved":   {Version: "v1.0.0", Commit: "h1", RepoURL: "https://example.com/acme/moved.git"},
+		"example.com/acme/release": {Version: "release-2024", Commit: "i1", RepoURL: "https://example.com/acme/release.git"},
+	}}
+	new := &Lockfile{Dependencies: map[string]Dependency{
+		"example.com/acme/same":    {Version: "v1.0.0", Commit: "aaa", RepoURL: "https://example.com/acme/same.git", Sum: "h1:new"},
+		"example.com/acme/added":   {Version: "v0.1.0", Commit: "zzz", RepoURL: "https://example.com/acme/added.git"},
+		"example.com/acme/major":   {Version: "v2.0.1", Commit: "c2", RepoURL: "https://example.com/acme/major.git"},
+		"example.com/acme/minor":   {Version: "v1.3.0", Commit: "d2", RepoURL: "https://example.com/acme/minor.git"},
+		"example.com/acme/down":    {Version: "v1.2.2", Commit: "e2", RepoURL: "https://example.com/acme/down.git"},
+		"example.com/acme/rc":      {Version: "v2.0.0", Commit: "f2", RepoURL: "https://example.com/acme/rc.git"},
+		"example.com/acme/retag":   {Version: "v1.0.0", Commit: "g2", RepoURL: "https://example.com/acme/retag.git"},
+		"example.com/acme/moved":   {Version: "v1.0.0", Commit: "h1", RepoURL: "https://example.com/fork/moved.git"},
+		"example.com/acme/release": {Version: "release-2025", Commit: "i2", RepoURL: "https://example.com/acme/release.git"},
+	}}
+
+	type summary struct{ module, change, level string }
+	var got []summary
+	changes := Diff(old, new)
+	for _, c := range changes {
+		got = append(got, summary{c.Module, c.Change, c.Level})
+	}
+	want := []summary{
+		{"example.com/acme/added", ChangeAdded, ""},
+		{"example.com/acme/down", ChangeDowngraded, LevelPatch},
+		{"example.com/acme/gone", ChangeRemoved, ""},
+		{"example.com/acme/major", ChangeUpgraded, LevelMajor},
+		{"example.com/acme/minor", ChangeUpgraded, LevelMinor},
+		{"example.com/acme/moved", ChangeChanged, ""},
+		{"example.com/acme/rc", ChangeUpgraded, LevelPrerelease},
+		{"example.com/acme/release", ChangeChanged, ""},
+		{"example.com/acme/retag", ChangeChanged, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() =\n%v\nwant\n%v", got, want)
+	}
+
+	for _, c := range changes {
+		switch c.Module {
+		case "example.com/acme/gone":
+			if !c.Indirect || c.OldVersion != "v0.3.0" || c.NewVersion != "" {
+				t.Errorf("removed module = %+v", c)
+			}
+		case "example.com/acme/moved":
+			if !c.RepoURLChanged() || c.CommitChanged() {
+				t.Errorf("moved module = %+v, want only the repository changed", c)
+			}
+		case "example.com/acme/retag":
+			if !c.CommitChanged() || c.RepoURLChanged() {
+				t.Errorf("re-tagged module = %+v, want only the commit changed", c)
+			}
+		}
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("Diff() of a lockfile with itself = %v, want no changes", changes)
+	}
+}
@@ -11,6 +11,12 @@ import (
 
 const LockfileName = "lock.cpkg.yaml"
 
+// VCS values recorded for dependencies.
+const (
+	VCSGit   = "git"
+	VCSLocal = "local" // Replaced by a local directory; not managed as a submodule
+)
+
 type Lockfile struct {
 	APIVersion   string                `yaml:"apiVersion"`
 	Kind         string                `yaml:"kind"`
@@ -30,6 +36,21 @@ type Dependency struct {
 	Path       string `yaml:"path"`             // Submodule path (entire repo checkout)
 	Subdir     string `yaml:"subdir,omitempty"` // Subdirectory within the repo (e.g., "intrusive_list", "span")
 	SourcePath string `yaml:"sourcePath"`       // Actual path to source files (path + subdir if subdir exists)
+
+	// Indirect is true for modules that are only required by other dependencies,
+	// not by the root manifest.
+	Indirect bool `yaml:"indirect,omitempty"`
+	// Requires lists the dependencies declared in this module's own cpkg.yaml
+	// at the locked commit (module path -> constraint).
+	Requires map[string]string `yaml:"requires,omitempty"`
+	// Replace records the replace directive applied to this module: the
+	// replacement module@version, or the local directory for VCS "local".
+	Replace string `yaml:"replace,omitempty"`
+}
+
+// IsLocal reports whether the dependency is replaced by a local directory.
+func (d Dependency) IsLocal() bool {
+	return d.VCS == VCSLocal
 }
 
 func FindLockfile(startDir string) (string, error) {
@@ -52,6 +73,12 @@ func Load(path string) (*Lockfile, error) {
 		return nil, err
 	}
 
+	return Parse(data)
+}
+
+// Parse decodes a lockfile from raw YAML, such as a lock.cpkg.yaml read from
+// a git commit.
+func Parse(data []byte) (*Lockfile, error) {
 	var l Lockfile
 	if err := yaml.Unmarshal(data, &l); err != nil {
 		return nil, fmt.Errorf("failed to parse lockfile: %w", err)
@@ -0,0 +1,9 @@
+package lockfile
+
+import _ "embed"
+
+// JSONSchema is the JSON Schema of lock.cpkg.yaml, for editors and other
+// tools.
+//
+//go:embed lock.cpkg.schema.json
+var JSONSchema []byte
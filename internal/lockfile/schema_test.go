@@ -0,0 +1,47 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestJSONSchema checks that the JSON Schema describes the fields of Lockfile
+// and Dependency, so that the two do not drift apart.
+func TestJSONSchema(t *testing.T) {
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Defs       struct {
+			Dependency struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"dependency"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(JSONSchema, &schema); err != nil {
+		t.Fatalf("JSONSchema is not valid JSON: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		typ        reflect.Type
+		properties map[string]json.RawMessage
+	}{
+		{"Lockfile", reflect.TypeOf(Lockfile{}), schema.Properties},
+		{"Dependency", reflect.TypeOf(Dependency{}), schema.Defs.Dependency.Properties},
+	} {
+		var fields, properties []string
+		for i := 0; i < tt.typ.NumField(); i++ {
+			fields = append(fields, strings.Split(tt.typ.Field(i).Tag.Get("yaml"), ",")[0])
+		}
+		for property := range tt.properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(fields)
+		sort.Strings(properties)
+		if !reflect.DeepEqual(fields, properties) {
+			t.Errorf("%s fields = %v, schema properties = %v", tt.name, fields, properties)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document is a cpkg.yaml opened for editing. Unlike Save, which marshals a
+// Manifest, it edits the YAML node tree in place, so comments, the order of
+// keys and fields this version of cpkg does not know about survive; only the
+// entries that are edited are re-encoded. Blank lines are not preserved.
+type Document struct {
+	root   yaml.Node // The document node
+	indent int       // Indentation of the original file
+}
+
+// LoadDocument reads the cpkg.yaml at path for editing. Like Load, it rejects
+// a manifest that fails Validate.
+func LoadDocument(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFile(path, data); err != nil {
+		return nil, err
+	}
+	return ParseDocument(data)
+}
+
+// ParseDocument parses a cpkg.yaml for editing, without validating it.
+func ParseDocument(data []byte) (*Document, error) {
+	d := &Document{indent: detectIndent(data)}
+	if err := yaml.Unmarshal(data, &d.root); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if d.root.Kind == 0 {
+		// Empty file
+		d.root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if d.root.Kind != yaml.DocumentNode || len(d.root.Content) != 1 || d.root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("failed to parse manifest: expected a mapping at the top level")
+	}
+	return d, nil
+}
+
+// NewDocument returns a document holding m, e.g. for a new cpkg.yaml.
+func NewDocument(m *Manifest) (*Document, error) {
+	d := &Document{indent: 2}
+	var node yaml.Node
+	if err := node.Encode(m); err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	d.root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&node}}
+	return d, nil
+}
+
+// Manifest decodes the document.
+func (d *Document) Manifest() (*Manifest, error) {
+	data, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// SetDependency adds the dependency on module, or replaces its requirement.
+// The fields of an existing entry other than version, branch, commit and tag
+// are kept, as are its comments. A new entry is added in sorted position if
+// the dependencies are sorted, and at the end otherwise.
+func (d *Document) SetDependency(module string, dep Dependency) error {
+	deps := d.mapping("dependencies", true)
+	if value := lookup(deps, module); value != nil && value.Kind == yaml.MappingNode {
+		setRequirement(value, dep)
+		return nil
+	}
+
+	var value yaml.Node
+	if err := value.Encode(dep); err != nil {
+		return fmt.Errorf("failed to marshal dependency %s: %w", module, err)
+	}
+	if i := lookupIndex(deps, module); i >= 0 {
+		// Not a mapping; replace it outright
+		deps.Content[i+1] = &value
+		return nil
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: module}
+	at := len(deps.Content)
+	if sortedKeys(deps) {
+		for i := 0; i < len(deps.Content); i += 2 {
+			if deps.Content[i].Value > module {
+				at = i
+				break
+			}
+		}
+	}
+	deps.Content = append(deps.Content[:at], append([]*yaml.Node{key, &value}, deps.Content[at:]...)...)
+	return nil
+}
+
+// RemoveDependency removes the dependency on module and reports whether there
+// was one.
+func (d *Document) RemoveDependency(module string) bool {
+	deps := d.mapping("dependencies", false)
+	if deps == nil {
+		return false
+	}
+	return remove(deps, module)
+}
+
+// Bytes encodes the document.
+func (d *Document) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(d.indent)
+	if err := enc.Encode(&d.root); err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Save writes the document to path.
+func (d *Document) Save(path string) error {
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// mapping returns the mapping under key at the top level. If there is none
+// and create is set, an empty one is added (or replaces an empty value such
+// as "dependencies:" with nothing after it).
+func (d *Document) mapping(key string, create bool) *yaml.Node {
+	top := d.root.Content[0]
+	value := lookup(top, key)
+	switch {
+	case value != nil && value.Kind == yaml.MappingNode:
+		if create {
+			value.Style &^= yaml.FlowStyle // "dependencies: {}" grows into a block
+		}
+		return value
+	case !create:
+		return nil
+	case value != nil:
+		*value = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: value.HeadComment, LineComment: value.LineComment, FootComment: value.FootComment}
+		return value
+	}
+	value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	top.Content = append(top.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
+
+// lookup returns the value of key in mapping, or nil.
+func lookup(mapping *yaml.Node, key string) *yaml.Node {
+	if i := lookupIndex(mapping, key); i >= 0 {
+		return mapping.Content[i+1]
+	}
+	return nil
+}
+
+// lookupIndex returns the index of key's node in mapping's content, or -1.
+func lookupIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// setRequirement sets the version, branch, commit and tag fields of the
+// dependency entry mapping to those of dep. A field replacing another, e.g. a
+// version replacing a branch pin, takes its place and comments.
+func setRequirement(mapping *yaml.Node, dep Dependency) {
+	var added []string
+	var vacated []int
+	for _, field := range []struct{ key, value string }{
+		{"version", dep.Version},
+		{"branch", dep.Branch},
+		{"commit", dep.Commit},
+		{"tag", dep.Tag},
+	} {
+		i := lookupIndex(mapping, field.key)
+		switch {
+		case i >= 0 && field.value == "":
+			vacated = append(vacated, i)
+		case i >= 0:
+			setScalar(mapping, field.key, field.value)
+		case field.value != "":
+			added = append(added, field.key, field.value)
+		}
+	}
+
+	sort.Ints(vacated)
+	for len(added) > 0 && len(vacated) > 0 {
+		mapping.Content[vacated[0]].Value = added[0]
+		value := mapping.Content[vacated[0]+1]
+		value.Kind, value.Tag, value.Value, value.Style, value.Content = yaml.ScalarNode, "!!str", added[1], 0, nil
+		added, vacated = added[2:], vacated[1:]
+	}
+	for i := len(vacated) - 1; i >= 0; i-- {
+		mapping.Content = append(mapping.Content[:vacated[i]], mapping.Content[vacated[i]+2:]...)
+	}
+	for ; len(added) > 0; added = added[2:] {
+		setScalar(mapping, added[0], added[1])
+	}
+}
+
+// setScalar sets key in mapping to the string value, keeping the comments of
+// an existing entry.
+func setScalar(mapping *yaml.Node, key, value string) {
+	if node := lookup(mapping, key); node != nil && node.Kind == yaml.ScalarNode {
+		node.Value, node.Tag = value, "!!str"
+		if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			node.Style = 0
+		}
+		return
+	}
+	remove(mapping, key)
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// remove deletes key from mapping and reports whether it was there.
+func remove(mapping *yaml.Node, key string) bool {
+	i := lookupIndex(mapping, key)
+	if i < 0 {
+		return false
+	}
+	mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+	return true
+}
+
+// sortedKeys reports whether the keys of mapping are in sorted order.
+func sortedKeys(mapping *yaml.Node) bool {
+	for i := 2; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i-2].Value > mapping.Content[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+// detectIndent returns the indentation of the first indented mapping key in
+// data, so that edits do not reindent the file; 2 if there is none.
+func detectIndent(data []byte) int {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		n := len(line) - len(trimmed)
+		if n == 0 || trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		if n >= 2 && n <= 8 {
+			return n
+		}
+		break
+	}
+	return 2
+}
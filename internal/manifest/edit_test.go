@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+)
+
+const editTestManifest = `# Firmware for the widget board
+apiVersion: cpkg.ringil.dev/v0
+kind: Module
+module: example.com/acme/widget
+depRoot: third_party/cpkg
+
+# Kept sorted by hand
+dependencies:
+  example.com/acme/crc:
+    version: ^1.0.0 # CRC tables
+  # Pinned until the DMA fix is released
+  example.com/acme/hal:
+    branch: dma-fix
+    note: see issue 42
+  example.com/acme/tls:
+    version: ^2.1.0
+
+x-owner: firmware-team
+`
+
+func TestDocument_PreservesUnrelatedContent(t *testing.T) {
+	d, err := ParseDocument([]byte(editTestManifest))
+	if err != nil {
+		t.Fatalf("ParseDocument() error = %v", err)
+	}
+
+	if err := d.SetDependency("example.com/acme/hal", Dependency{Version: "^1.4.0"}); err != nil {
+		t.Fatalf("SetDependency() error = %v", err)
+	}
+	if err := d.SetDependency("example.com/acme/crc", Dependency{Version: "^1.2.0"}); err != nil {
+		t.Fatalf("SetDependency() error = %v", err)
+	}
+	if err := d.SetDependency("example.com/acme/log", Dependency{Version: "^0.3.0"}); err != nil {
+		t.Fatalf("SetDependency() error = %v", err)
+	}
+	if !d.RemoveDependency("example.com/acme/tls") {
+		t.Error("RemoveDependency() = false, want true")
+	}
+	if d.RemoveDependency("example.com/acme/missing") {
+		t.Error("RemoveDependency() of a missing dependency = true, want false")
+	}
+
+	data, err := d.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		"# Firmware for the widget board\n",
+		"# Kept sorted by hand\n",
+		"version: ^1.2.0 # CRC tables\n",
+		"  # Pinned until the DMA fix is released\n  example.com/acme/hal:\n    version: ^1.4.0\n    note: see issue 42\n",
+		"x-owner: firmware-team\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("edited manifest should contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "tls") || strings.Contains(got, "branch") {
+		t.Errorf("edited manifest should not contain tls or the branch pin:\n%s", got)
+	}
+
+	// Keys keep their order, and the new dependency goes in sorted position
+	order := []string{"apiVersion:", "kind:", "module:", "depRoot:", "dependencies:", "example.com/acme/crc:", "example.com/acme/hal:", "example.com/acme/log:", "x-owner:"}
+	last := -1
+	for _, key := range order {
+		i := strings.Index(got, key)
+		if i < last {
+			t.Errorf("%s is out of order:\n%s", key, got)
+		}
+		last = i
+	}
+
+	m, err := d.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	if len(m.Dependencies) != 3 || m.Dependencies["example.com/acme/log"].Version != "^0.3.0" {
+		t.Errorf("Manifest().Dependencies = %+v", m.Dependencies)
+	}
+}
+
+func TestDocument_AddsDependencies(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"no section", "module: example.com/acme/app\n"},
+		{"empty section", "module: example.com/acme/app\ndependencies:\n"},
+		{"flow section", "module: example.com/acme/app\ndependencies: {}\n"},
+		{"empty file", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := ParseDocument([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("ParseDocument() error = %v", err)
+			}
+			if err := d.SetDependency("example.com/acme/lib", Dependency{Commit: "0123456789abcdef0123456789abcdef01234567"}); err != nil {
+				t.Fatalf("SetDependency() error = %v", err)
+			}
+			data, err := d.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			want := "dependencies:\n  example.com/acme/lib:\n    commit: 0123456789abcdef0123456789abcdef01234567\n"
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Bytes() = %q, want it to contain %q", data, want)
+			}
+		})
+	}
+}
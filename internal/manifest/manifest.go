@@ -1,25 +1,43 @@
 package manifest
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/SCKelemen/cpkg/internal/semver"
 	"gopkg.in/yaml.v3"
 )
 
 const ManifestFileName = "cpkg.yaml"
 
 type Manifest struct {
-	APIVersion  string                 `yaml:"apiVersion"`
-	Kind        string                 `yaml:"kind"`
-	Module      string                 `yaml:"module"`
-	Version     string                 `yaml:"version,omitempty"`
-	DepRoot     string                 `yaml:"depRoot,omitempty"`
-	Language    Language               `yaml:"language,omitempty"`
-	Build       *Build                 `yaml:"build,omitempty"`
-	Test        *Test                  `yaml:"test,omitempty"`
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Module     string `yaml:"module"`
+	Version    string `yaml:"version,omitempty"`
+	DepRoot    string `yaml:"depRoot,omitempty"`
+	// Resolution selects how versions are chosen: "highest" (default) picks the
+	// newest version satisfying all constraints, "minimal" the oldest.
+	Resolution   string                `yaml:"resolution,omitempty"`
+	Language     Language              `yaml:"language,omitempty"`
+	Build        *Build                `yaml:"build,omitempty"`
+	Test         *Test                 `yaml:"test,omitempty"`
 	Dependencies map[string]Dependency `yaml:"dependencies,omitempty"`
+	// Replace substitutes another module or a local directory for a module,
+	// e.g. "github.com/acme/foo: github.com/me/foo@v1.2.4" or
+	// "github.com/acme/foo@v1.2.3: ../foo". See ParseReplacement.
+	Replace map[string]string `yaml:"replace,omitempty"`
+	// Exclude lists module@version pairs that are never selected, e.g. a
+	// broken upstream release.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Retract lists versions of this module that its author has withdrawn,
+	// as exact versions or constraints. Consumers read it from the module's
+	// latest version and skip the retracted ones.
+	Retract []string `yaml:"retract,omitempty"`
 }
 
 type Language struct {
@@ -28,7 +46,7 @@ type Language struct {
 }
 
 type Build struct {
-	Command []string            `yaml:"command,omitempty"`
+	Command []string               `yaml:"command,omitempty"`
 	Targets map[string]BuildTarget `yaml:"targets,omitempty"`
 }
 
@@ -40,21 +58,91 @@ type Test struct {
 	Command []string `yaml:"command"`
 }
 
+// Dependency is a requirement on another module. It is either a semver
+// constraint (Version) or a pin to a git branch, commit or tag, for modules
+// that publish no semver tags.
 type Dependency struct {
-	Version string `yaml:"version"`
+	Version string `yaml:"version,omitempty"`
+	Branch  string `yaml:"branch,omitempty"`
+	Commit  string `yaml:"commit,omitempty"`
+	Tag     string `yaml:"tag,omitempty"`
+}
+
+// Pin kinds returned by Dependency.Pin.
+const (
+	PinBranch = "branch"
+	PinCommit = "commit"
+	PinTag    = "tag"
+)
+
+// Pin returns the kind of git ref the dependency is pinned to and the ref
+// itself, or two empty strings if it is a version constraint. It is an error
+// to set more than one of version, branch, commit and tag.
+func (d Dependency) Pin() (string, string, error) {
+	var kind, ref string
+	set := 0
+	if d.Version != "" {
+		set++
+	}
+	for _, pin := range []struct{ kind, ref string }{
+		{PinBranch, d.Branch},
+		{PinCommit, d.Commit},
+		{PinTag, d.Tag},
+	} {
+		if pin.ref != "" {
+			kind, ref = pin.kind, pin.ref
+			set++
+		}
+	}
+	if set > 1 {
+		return "", "", fmt.Errorf("only one of version, branch, commit and tag may be set")
+	}
+	return kind, ref, nil
+}
+
+// IsPinned reports whether the dependency is pinned to a git ref rather than
+// constrained by version.
+func (d Dependency) IsPinned() bool {
+	return d.Branch != "" || d.Commit != "" || d.Tag != ""
+}
+
+// Constraint describes the requirement for display, e.g. "^1.2.0",
+// "branch:main", "commit:abcdef1" or "tag:hal-2024.1".
+func (d Dependency) Constraint() string {
+	kind, ref, err := d.Pin()
+	if err != nil || kind == "" {
+		return d.Version
+	}
+	if kind == PinCommit && len(ref) > 12 {
+		ref = ref[:12]
+	}
+	return kind + ":" + ref
 }
 
 func DefaultDepRoot() string {
 	return "third_party/cpkg"
 }
 
+// ErrWorkspaceRoot is returned (wrapped) by FindManifest when the search
+// reaches the root of a workspace without finding a manifest.
+var ErrWorkspaceRoot = errors.New("not a module but the root of a workspace; run the command in a member module")
+
+// FindManifest returns the path of the nearest cpkg.yaml in startDir or a
+// parent. The search stops at the root of a workspace (the directory of
+// cpkg.work, see FindWorkspace): a directory of the workspace that is not in
+// a member has no manifest, rather than that of a module around the
+// workspace.
 func FindManifest(startDir string) (string, error) {
+	workspace := FindWorkspace(startDir)
 	dir := startDir
 	for {
 		manifestPath := filepath.Join(dir, ManifestFileName)
 		if _, err := os.Stat(manifestPath); err == nil {
 			return manifestPath, nil
 		}
+		if workspace != "" && dir == filepath.Dir(workspace) {
+			return "", fmt.Errorf("%s is %w", dir, ErrWorkspaceRoot)
+		}
 		if dir == "/" || dir == filepath.Dir(dir) {
 			return "", fmt.Errorf("no %s found", ManifestFileName)
 		}
@@ -62,12 +150,37 @@ func FindManifest(startDir string) (string, error) {
 	}
 }
 
+// Load reads the cpkg.yaml at path. A manifest that fails Validate is
+// rejected with a *ValidationError listing every error.
 func Load(path string) (*Manifest, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateFile(path, data); err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
 
+// validateFile returns a *ValidationError if the manifest read from path has
+// errors.
+func validateFile(path string, data []byte) error {
+	problems, err := Validate(data)
+	if err != nil {
+		return err
+	}
+	if errs := Errors(problems); len(errs) > 0 {
+		return &ValidationError{Path: path, Problems: errs}
+	}
+	return nil
+}
+
+// Parse decodes a manifest from raw YAML without validating it. It is used for
+// manifests of dependencies, such as a cpkg.yaml read from a git commit, which
+// the project does not control.
+func Parse(data []byte) (*Manifest, error) {
 	var m Manifest
 	if err := yaml.Unmarshal(data, &m); err != nil {
 		return nil, fmt.Errorf("failed to parse manifest: %w", err)
@@ -80,13 +193,139 @@ func Load(path string) (*Manifest, error) {
 	return &m, nil
 }
 
-func Save(m *Manifest, path string) error {
-	data, err := yaml.Marshal(m)
-	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %w", err)
+// Replacement is a parsed entry of the replace section.
+type Replacement struct {
+	Old        string // Module path being replaced
+	OldVersion string // Only replace this version of Old; empty replaces every version
+	New        string // Replacement module path; empty for a local directory
+	NewVersion string // Version of New to use; empty uses the versions selected for Old's constraints
+	Dir        string // Local directory replacing Old, relative to the manifest
+}
+
+// IsLocal reports whether the replacement is a local directory.
+func (r Replacement) IsLocal() bool {
+	return r.Dir != ""
+}
+
+// Target describes what the module is replaced with, as written in the
+// manifest: "module@version", "module" or a local directory.
+func (r Replacement) Target() string {
+	if r.IsLocal() {
+		return r.Dir
+	}
+	if r.NewVersion != "" {
+		return r.New + "@" + r.NewVersion
+	}
+	return r.New
+}
+
+// ParseReplacement parses a replace entry. The key is a module path with an
+// optional @version; the value is either a local path (starting with ./, ../
+// or /) or a module path with an optional @version.
+func ParseReplacement(key, value string) (Replacement, error) {
+	var r Replacement
+	r.Old, r.OldVersion = splitModuleVersion(key)
+	if r.Old == "" || strings.HasPrefix(r.Old, ".") || filepath.IsAbs(r.Old) {
+		return r, fmt.Errorf("invalid replace %q: must be a module path", key)
+	}
+
+	value = strings.TrimSpace(value)
+	if isLocalPath(value) {
+		r.Dir = value
+		return r, nil
 	}
 
-	return os.WriteFile(path, data, 0644)
+	r.New, r.NewVersion = splitModuleVersion(value)
+	if r.New == "" {
+		return r, fmt.Errorf("invalid replace %q: replacement must be a module path or a local path", key)
+	}
+	if r.New == r.Old && r.NewVersion == "" {
+		return r, fmt.Errorf("invalid replace %q: module cannot replace itself without a version", key)
+	}
+	return r, nil
 }
 
+// Replacements returns the parsed replace section, sorted by module path.
+func (m *Manifest) Replacements() ([]Replacement, error) {
+	var replacements []Replacement
+	for key, value := range m.Replace {
+		r, err := ParseReplacement(key, value)
+		if err != nil {
+			return nil, err
+		}
+		replacements = append(replacements, r)
+	}
+	sort.Slice(replacements, func(i, j int) bool {
+		if replacements[i].Old != replacements[j].Old {
+			return replacements[i].Old < replacements[j].Old
+		}
+		return replacements[i].OldVersion < replacements[j].OldVersion
+	})
+	return replacements, nil
+}
 
+// FindReplacement returns the replacement that applies to module at version.
+// A replacement for that exact version takes precedence over one for every
+// version of the module. An empty version only matches the latter.
+func FindReplacement(replacements []Replacement, module, version string) (Replacement, bool) {
+	var found Replacement
+	ok := false
+	for _, r := range replacements {
+		if r.Old != module {
+			continue
+		}
+		if r.OldVersion != "" && r.OldVersion == version {
+			return r, true
+		}
+		if r.OldVersion == "" {
+			found, ok = r, true
+		}
+	}
+	return found, ok
+}
+
+func splitModuleVersion(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func isLocalPath(s string) bool {
+	return s == "." || s == ".." ||
+		strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../") ||
+		strings.HasPrefix(s, ".\\") || strings.HasPrefix(s, "..\\") ||
+		filepath.IsAbs(s)
+}
+
+// Exclusions returns the exclude list as module path -> excluded versions.
+func (m *Manifest) Exclusions() (map[string][]string, error) {
+	exclusions := make(map[string][]string)
+	for _, entry := range m.Exclude {
+		module, version := splitModuleVersion(entry)
+		if module == "" || version == "" {
+			return nil, fmt.Errorf("invalid exclude %q: must be module@version", entry)
+		}
+		exclusions[module] = append(exclusions[module], version)
+	}
+	return exclusions, nil
+}
+
+// Retracted reports whether version is covered by an entry of a retract
+// list. Entries are exact versions or constraints.
+func Retracted(retract []string, version string) bool {
+	v, err := semver.Parse(version)
+	for _, entry := range retract {
+		if entry == version {
+			return true
+		}
+		if err != nil {
+			continue
+		}
+		if ok, _ := v.Satisfies(entry); ok {
+			return true
+		}
+	}
+	return false
+}
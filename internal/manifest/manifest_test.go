@@ -4,8 +4,20 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
+// writeManifest writes m to path as YAML, as a user would.
+func writeManifest(t *testing.T, m *Manifest, path string) {
+	t.Helper()
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+	writeFile(t, path, string(data))
+}
+
 func TestFindManifest(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -22,9 +34,7 @@ func TestFindManifest(t *testing.T) {
 		Kind:       "Module",
 		Module:     "test/module",
 	}
-	if err := Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	found, err := FindManifest(tmpDir)
 	if err != nil {
@@ -35,7 +45,7 @@ func TestFindManifest(t *testing.T) {
 	}
 }
 
-func TestLoadSave(t *testing.T) {
+func TestLoad(t *testing.T) {
 	tmpDir := t.TempDir()
 	manifestPath := filepath.Join(tmpDir, ManifestFileName)
 
@@ -51,9 +61,7 @@ func TestLoadSave(t *testing.T) {
 		},
 	}
 
-	if err := Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	loaded, err := Load(manifestPath)
 	if err != nil {
@@ -82,9 +90,7 @@ func TestDefaultDepRoot(t *testing.T) {
 		// DepRoot not set
 	}
 
-	if err := Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	loaded, err := Load(manifestPath)
 	if err != nil {
@@ -105,9 +111,7 @@ func TestFindManifestInParent(t *testing.T) {
 		Kind:       "Module",
 		Module:     "test/module",
 	}
-	if err := Save(m, manifestPath); err != nil {
-		t.Fatalf("failed to save manifest: %v", err)
-	}
+	writeManifest(t, m, manifestPath)
 
 	// Create subdirectory
 	subDir := filepath.Join(tmpDir, "subdir")
@@ -127,7 +131,7 @@ func TestFindManifestInParent(t *testing.T) {
 
 func TestFindManifestNestedModule(t *testing.T) {
 	tmpDir := t.TempDir()
-	
+
 	// Create root manifest
 	rootManifestPath := filepath.Join(tmpDir, ManifestFileName)
 	rootM := &Manifest{
@@ -135,9 +139,7 @@ func TestFindManifestNestedModule(t *testing.T) {
 		Kind:       "Module",
 		Module:     "test/root",
 	}
-	if err := Save(rootM, rootManifestPath); err != nil {
-		t.Fatalf("failed to save root manifest: %v", err)
-	}
+	writeManifest(t, rootM, rootManifestPath)
 
 	// Create nested subdirectory with its own manifest
 	subDir := filepath.Join(tmpDir, "subdir", "nested")
@@ -151,9 +153,7 @@ func TestFindManifestNestedModule(t *testing.T) {
 		Kind:       "Module",
 		Module:     "test/nested",
 	}
-	if err := Save(nestedM, nestedManifestPath); err != nil {
-		t.Fatalf("failed to save nested manifest: %v", err)
-	}
+	writeManifest(t, nestedM, nestedManifestPath)
 
 	// Should find the nearest manifest (nested one), not the root one
 	found, err := FindManifest(subDir)
@@ -175,4 +175,126 @@ func TestFindManifestNestedModule(t *testing.T) {
 	}
 }
 
+func TestParseReplacement(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       Replacement
+		local      bool
+		wantErr    bool
+	}{
+		{"github.com/acme/foo", "github.com/me/foo@v1.2.4",
+			Replacement{Old: "github.com/acme/foo", New: "github.com/me/foo", NewVersion: "v1.2.4"}, false, false},
+		{"github.com/acme/foo@v1.2.3", "github.com/me/foo",
+			Replacement{Old: "github.com/acme/foo", OldVersion: "v1.2.3", New: "github.com/me/foo"}, false, false},
+		{"github.com/acme/foo", "../foo",
+			Replacement{Old: "github.com/acme/foo", Dir: "../foo"}, true, false},
+		{"github.com/acme/foo", "/src/foo",
+			Replacement{Old: "github.com/acme/foo", Dir: "/src/foo"}, true, false},
+		{"github.com/acme/foo", "github.com/acme/foo", Replacement{}, false, true},
+		{"./foo", "github.com/me/foo", Replacement{}, false, true},
+		{"github.com/acme/foo", "", Replacement{}, false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseReplacement(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseReplacement(%q, %q) error = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReplacement(%q, %q) = %+v, want %+v", tt.key, tt.value, got, tt.want)
+		}
+		if got.IsLocal() != tt.local {
+			t.Errorf("ParseReplacement(%q, %q).IsLocal() = %v, want %v", tt.key, tt.value, got.IsLocal(), tt.local)
+		}
+	}
+}
 
+func TestFindReplacement(t *testing.T) {
+	m := &Manifest{
+		Replace: map[string]string{
+			"github.com/acme/foo":        "github.com/me/foo",
+			"github.com/acme/foo@v1.0.0": "../foo",
+		},
+	}
+	replacements, err := m.Replacements()
+	if err != nil {
+		t.Fatalf("Replacements() error = %v", err)
+	}
+
+	if r, ok := FindReplacement(replacements, "github.com/acme/foo", "v1.0.0"); !ok || r.Dir != "../foo" {
+		t.Errorf("v1.0.0: got %+v, %v; want the version-specific local replacement", r, ok)
+	}
+	if r, ok := FindReplacement(replacements, "github.com/acme/foo", "v1.1.0"); !ok || r.New != "github.com/me/foo" {
+		t.Errorf("v1.1.0: got %+v, %v; want the module-wide replacement", r, ok)
+	}
+	if _, ok := FindReplacement(replacements, "github.com/acme/bar", ""); ok {
+		t.Error("unexpected replacement for an unreplaced module")
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	m := &Manifest{Exclude: []string{"github.com/acme/foo@v3.6.1", "github.com/acme/foo@v3.6.2"}}
+	exclusions, err := m.Exclusions()
+	if err != nil {
+		t.Fatalf("Exclusions() error = %v", err)
+	}
+	if got := exclusions["github.com/acme/foo"]; len(got) != 2 {
+		t.Errorf("exclusions = %v, want two versions of github.com/acme/foo", got)
+	}
+
+	m = &Manifest{Exclude: []string{"github.com/acme/foo"}}
+	if _, err := m.Exclusions(); err == nil {
+		t.Error("expected error for exclude entry without a version")
+	}
+}
+
+func TestRetracted(t *testing.T) {
+	retract := []string{"v1.0.1", "~1.2.0"}
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"v1.0.0", false},
+		{"v1.0.1", true},
+		{"v1.2.0", true},
+		{"v1.2.7", true},
+		{"v1.3.0", false},
+	}
+	for _, tt := range tests {
+		if got := Retracted(retract, tt.version); got != tt.want {
+			t.Errorf("Retracted(%v, %s) = %v, want %v", retract, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestDependencyPin(t *testing.T) {
+	tests := []struct {
+		dep        Dependency
+		kind, ref  string
+		constraint string
+		wantErr    bool
+	}{
+		{Dependency{Version: "^1.0.0"}, "", "", "^1.0.0", false},
+		{Dependency{Branch: "main"}, PinBranch, "main", "branch:main", false},
+		{Dependency{Commit: "abcdef1234567890abcdef1234567890abcdef12"}, PinCommit, "abcdef1234567890abcdef1234567890abcdef12", "commit:abcdef123456", false},
+		{Dependency{Tag: "hal-2024.1"}, PinTag, "hal-2024.1", "tag:hal-2024.1", false},
+		{Dependency{Version: "^1.0.0", Branch: "main"}, "", "", "^1.0.0", true},
+	}
+	for _, tt := range tests {
+		kind, ref, err := tt.dep.Pin()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: Pin() error = %v, wantErr %v", tt.dep, err, tt.wantErr)
+			continue
+		}
+		if kind != tt.kind || ref != tt.ref {
+			t.Errorf("%+v: Pin() = %s %s, want %s %s", tt.dep, kind, ref, tt.kind, tt.ref)
+		}
+		if got := tt.dep.Constraint(); got != tt.constraint {
+			t.Errorf("%+v: Constraint() = %s, want %s", tt.dep, got, tt.constraint)
+		}
+	}
+}
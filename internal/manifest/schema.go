@@ -0,0 +1,9 @@
+package manifest
+
+import _ "embed"
+
+// JSONSchema is the JSON Schema of cpkg.yaml, for editors and other tools.
+// Validate checks the same rules and more, with positions.
+//
+//go:embed cpkg.schema.json
+var JSONSchema []byte
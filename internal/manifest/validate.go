@@ -0,0 +1,395 @@
+package manifest
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/SCKelemen/cpkg/internal/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// APIVersion is the only manifest schema version this version of cpkg reads.
+const APIVersion = "cpkg.ringil.dev/v0"
+
+// Problem is an error or warning found by Validate, at the position of the
+// offending node in the file.
+type Problem struct {
+	Line    int    `json:"line" yaml:"line"`
+	Column  int    `json:"column" yaml:"column"`
+	Field   string `json:"field,omitempty" yaml:"field,omitempty"` // e.g. "build.targets.host.command"
+	Message string `json:"message" yaml:"message"`
+	Warning bool   `json:"warning,omitempty" yaml:"warning,omitempty"`
+}
+
+func (p Problem) String() string {
+	kind := "error"
+	if p.Warning {
+		kind = "warning"
+	}
+	if p.Field == "" {
+		return fmt.Sprintf("%d:%d: %s: %s", p.Line, p.Column, kind, p.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s: %s", p.Line, p.Column, kind, p.Field, p.Message)
+}
+
+// ValidationError is returned by Load and LoadDocument for a manifest with
+// errors. Warnings are not errors and are left out.
+type ValidationError struct {
+	Path     string
+	Problems []Problem // Errors only
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = e.Path + ":" + p.String()
+	}
+	return "invalid manifest:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Errors returns the problems that are errors rather than warnings.
+func Errors(problems []Problem) []Problem {
+	var errs []Problem
+	for _, p := range problems {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	return errs
+}
+
+// Validate checks a cpkg.yaml against the manifest schema: required fields,
+// the types and values of known fields, and the syntax of module paths,
+// constraints, replacements and exclusions. Unknown fields are warnings. The
+// error is only set if data is not YAML at all.
+func Validate(data []byte) ([]Problem, error) {
+	d, err := ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	return d.Validate(), nil
+}
+
+// Validate checks the document against the manifest schema; see Validate.
+func (d *Document) Validate() []Problem {
+	v := &validator{}
+	v.manifest(d.root.Content[0])
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].Line != v.problems[j].Line {
+			return v.problems[i].Line < v.problems[j].Line
+		}
+		return v.problems[i].Column < v.problems[j].Column
+	})
+	return v.problems
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) errorf(n *yaml.Node, field, format string, args ...interface{}) {
+	v.add(n, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(n *yaml.Node, field, format string, args ...interface{}) {
+	v.add(n, Problem{Field: field, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+func (v *validator) add(n *yaml.Node, p Problem) {
+	p.Line, p.Column = n.Line, n.Column
+	if p.Line == 0 {
+		p.Line, p.Column = 1, 1 // An empty file
+	}
+	v.problems = append(v.problems, p)
+}
+
+// fields checks each entry of the mapping n with the function for its key,
+// warns about unknown keys and reports duplicate and missing required ones.
+func (v *validator) fields(n *yaml.Node, field string, known map[string]func(field string, n *yaml.Node), required ...string) {
+	if !v.isMapping(n, field) {
+		return
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		sub := join(field, key.Value)
+		if seen[key.Value] {
+			v.errorf(key, sub, "duplicate field")
+			continue
+		}
+		seen[key.Value] = true
+		check, ok := known[key.Value]
+		if !ok {
+			v.warnf(key, sub, "unknown field")
+			continue
+		}
+		if check != nil {
+			check(sub, value)
+		}
+	}
+	for _, key := range required {
+		if !seen[key] {
+			v.errorf(n, join(field, key), "missing required field")
+		}
+	}
+}
+
+func (v *validator) manifest(n *yaml.Node) {
+	v.fields(n, "", map[string]func(string, *yaml.Node){
+		"apiVersion": func(field string, n *yaml.Node) {
+			if s, ok := v.str(n, field); ok && s != APIVersion {
+				v.errorf(n, field, "unsupported apiVersion %q, expected %q", s, APIVersion)
+			}
+		},
+		"kind": func(field string, n *yaml.Node) {
+			if s, ok := v.str(n, field); ok && s != "Module" {
+				v.errorf(n, field, "kind must be Module, not %q", s)
+			}
+		},
+		"module": func(field string, n *yaml.Node) {
+			if s, ok := v.str(n, field); ok {
+				v.modulePath(n, field, s)
+			}
+		},
+		"version": func(field string, n *yaml.Node) {
+			if s, ok := v.str(n, field); ok {
+				if _, err := semver.Parse(s); err != nil {
+					v.errorf(n, field, "invalid version %q: %v", s, err)
+				}
+			}
+		},
+		"depRoot": func(field string, n *yaml.Node) {
+			if s, ok := v.str(n, field); ok {
+				if path.IsAbs(s) || strings.HasPrefix(s, "\\") || (len(s) > 1 && s[1] == ':') {
+					v.errorf(n, field, "must be a path relative to the manifest")
+				} else if clean := path.Clean(strings.ReplaceAll(s, "\\", "/")); clean == ".." || strings.HasPrefix(clean, "../") || clean == "." {
+					v.errorf(n, field, "must be a directory inside the module")
+				}
+			}
+		},
+		"resolution": func(field string, n *yaml.Node) {
+			if s, ok := v.str(n, field); ok && s != "highest" && s != "minimal" {
+				v.errorf(n, field, "must be highest or minimal, not %q", s)
+			}
+		},
+		"language": func(field string, n *yaml.Node) {
+			v.fields(n, field, map[string]func(string, *yaml.Node){
+				"cStandard": func(field string, n *yaml.Node) { v.str(n, field) },
+				"skc":       v.boolean,
+			})
+		},
+		"build": func(field string, n *yaml.Node) {
+			v.fields(n, field, map[string]func(string, *yaml.Node){
+				"command": v.command,
+				"targets": func(field string, n *yaml.Node) {
+					v.entries(n, field, func(field string, key, value *yaml.Node) {
+						v.fields(value, field, map[string]func(string, *yaml.Node){"command": v.command}, "command")
+					})
+				},
+			})
+		},
+		"test": func(field string, n *yaml.Node) {
+			v.fields(n, field, map[string]func(string, *yaml.Node){"command": v.command}, "command")
+		},
+		"dependencies": func(field string, n *yaml.Node) {
+			v.entries(n, field, v.dependency)
+		},
+		"replace": func(field string, n *yaml.Node) {
+			v.entries(n, field, func(field string, key, value *yaml.Node) {
+				if s, ok := v.str(value, field); ok {
+					if _, err := ParseReplacement(key.Value, s); err != nil {
+						v.errorf(value, field, "%v", err)
+					}
+				}
+			})
+		},
+		"exclude": func(field string, n *yaml.Node) {
+			v.list(n, field, func(field string, n *yaml.Node) {
+				s, ok := v.str(n, field)
+				if !ok {
+					return
+				}
+				module, version := splitModuleVersion(s)
+				if module == "" || version == "" {
+					v.errorf(n, field, "invalid exclude %q: must be module@version", s)
+				} else if _, err := semver.Parse(version); err != nil {
+					v.errorf(n, field, "invalid exclude %q: %v", s, err)
+				}
+			})
+		},
+		"retract": func(field string, n *yaml.Node) {
+			v.list(n, field, func(field string, n *yaml.Node) {
+				if s, ok := v.str(n, field); ok {
+					if _, err := semver.ParseConstraint(s); err != nil {
+						v.errorf(n, field, "invalid retract %q: must be a version or constraint: %v", s, err)
+					}
+				}
+			})
+		},
+	}, "apiVersion", "kind", "module")
+}
+
+// dependency checks an entry of the dependencies section.
+func (v *validator) dependency(field string, key, value *yaml.Node) {
+	v.modulePath(key, field, key.Value)
+
+	set := 0
+	v.fields(value, field, map[string]func(string, *yaml.Node){
+		"version": func(field string, n *yaml.Node) {
+			if s, ok := v.nonEmpty(n, field); ok {
+				set++
+				if _, err := semver.ParseConstraint(s); err != nil {
+					v.errorf(n, field, "invalid constraint %q: %v", s, err)
+				}
+			}
+		},
+		"branch": func(field string, n *yaml.Node) {
+			if _, ok := v.nonEmpty(n, field); ok {
+				set++
+			}
+		},
+		"commit": func(field string, n *yaml.Node) {
+			if s, ok := v.nonEmpty(n, field); ok {
+				set++
+				if len(s) < 4 || len(s) > 40 || strings.Trim(strings.ToLower(s), "0123456789abcdef") != "" {
+					v.errorf(n, field, "invalid commit %q: must be a hexadecimal SHA", s)
+				}
+			}
+		},
+		"tag": func(field string, n *yaml.Node) {
+			if _, ok := v.nonEmpty(n, field); ok {
+				set++
+			}
+		},
+	})
+	if value.Kind != yaml.MappingNode {
+		return
+	}
+	switch {
+	case set == 0:
+		v.errorf(value, field, "one of version, branch, commit and tag is required")
+	case set > 1:
+		v.errorf(value, field, "only one of version, branch, commit and tag may be set")
+	}
+}
+
+// modulePath checks the syntax of a module path.
+func (v *validator) modulePath(n *yaml.Node, field, s string) {
+	switch {
+	case s == "":
+		v.errorf(n, field, "module path is empty")
+	case strings.ContainsAny(s, " \t\\@"):
+		v.errorf(n, field, "invalid module path %q: must not contain spaces, backslashes or @", s)
+	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "git@"):
+		// Repository URLs are accepted as module paths
+	case !strings.Contains(s, "/") || strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") || strings.Contains(s, "//"):
+		v.errorf(n, field, "invalid module path %q: expected host/owner/repo[/subdir]", s)
+	}
+	for _, elem := range strings.Split(s, "/") {
+		if elem == "." || elem == ".." {
+			v.errorf(n, field, "invalid module path %q: must not contain . or .. elements", s)
+			return
+		}
+	}
+}
+
+// command checks a command: a non-empty list of non-empty strings.
+func (v *validator) command(field string, n *yaml.Node) {
+	if !v.isSequence(n, field) {
+		return
+	}
+	if len(n.Content) == 0 {
+		v.errorf(n, field, "command must not be empty")
+		return
+	}
+	for i, arg := range n.Content {
+		if s, ok := v.str(arg, fmt.Sprintf("%s[%d]", field, i)); ok && i == 0 && s == "" {
+			v.errorf(arg, field, "the program name must not be empty")
+		}
+	}
+}
+
+func (v *validator) boolean(field string, n *yaml.Node) {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" {
+		v.errorf(n, field, "must be true or false")
+	}
+}
+
+// str checks that n is a string scalar and returns it. Numbers are accepted,
+// as YAML reads an unquoted 1.2 as one.
+func (v *validator) str(n *yaml.Node, field string) (string, bool) {
+	if n.Kind != yaml.ScalarNode || n.Tag == "!!null" {
+		v.errorf(n, field, "must be a string")
+		return "", false
+	}
+	return n.Value, true
+}
+
+// nonEmpty is like str, but the string must not be empty.
+func (v *validator) nonEmpty(n *yaml.Node, field string) (string, bool) {
+	s, ok := v.str(n, field)
+	if ok && s == "" {
+		v.errorf(n, field, "must not be empty")
+		return "", false
+	}
+	return s, ok
+}
+
+// entries calls fn for each entry of the mapping n.
+func (v *validator) entries(n *yaml.Node, field string, fn func(field string, key, value *yaml.Node)) {
+	if n.Tag == "!!null" {
+		return // Empty section
+	}
+	if !v.isMapping(n, field) {
+		return
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		sub := join(field, key.Value)
+		if seen[key.Value] {
+			v.errorf(key, sub, "duplicate entry")
+			continue
+		}
+		seen[key.Value] = true
+		fn(sub, key, value)
+	}
+}
+
+// list calls fn for each item of the sequence n.
+func (v *validator) list(n *yaml.Node, field string, fn func(field string, n *yaml.Node)) {
+	if n.Tag == "!!null" {
+		return // Empty section
+	}
+	if !v.isSequence(n, field) {
+		return
+	}
+	for i, item := range n.Content {
+		fn(fmt.Sprintf("%s[%d]", field, i), item)
+	}
+}
+
+func (v *validator) isMapping(n *yaml.Node, field string) bool {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, field, "must be a mapping")
+		return false
+	}
+	return true
+}
+
+func (v *validator) isSequence(n *yaml.Node, field string) bool {
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(n, field, "must be a list")
+		return false
+	}
+	return true
+}
+
+func join(field, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
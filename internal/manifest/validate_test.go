@@ -0,0 +1,137 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	const header = "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/app\n"
+	tests := []struct {
+		name string
+		data string
+		want []string // Problem strings, in order
+	}{
+		{"valid", header + "dependencies:\n  example.com/acme/lib:\n    version: ^1.0.0\n", nil},
+		{"empty file", "", []string{
+			"1:1: error: apiVersion: missing required field",
+			"1:1: error: kind: missing required field",
+			"1:1: error: module: missing required field",
+		}},
+		{"wrong apiVersion", "apiVersion: cpkg.ringil.dev/v1\nkind: Module\nmodule: example.com/acme/app\n", []string{
+			`1:13: error: apiVersion: unsupported apiVersion "cpkg.ringil.dev/v1", expected "cpkg.ringil.dev/v0"`,
+		}},
+		{"bad constraint", header + "dependencies:\n  example.com/acme/lib:\n    version: ^1.x.y\n", []string{
+			`6:14: error: dependencies.example.com/acme/lib.version: invalid constraint "^1.x.y"`,
+		}},
+		{"two requirements", header + "dependencies:\n  example.com/acme/lib:\n    version: ^1.0.0\n    branch: main\n", []string{
+			"6:5: error: dependencies.example.com/acme/lib: only one of version, branch, commit and tag may be set",
+		}},
+		{"no requirement", header + "dependencies:\n  example.com/acme/lib: {}\n", []string{
+			"5:25: error: dependencies.example.com/acme/lib: one of version, branch, commit and tag is required",
+		}},
+		{"empty build command", header + "build:\n  targets:\n    host:\n      command: []\n", []string{
+			"7:16: error: build.targets.host.command: command must not be empty",
+		}},
+		{"missing test command", header + "test: {}\n", []string{
+			"4:7: error: test.command: missing required field",
+		}},
+		{"unknown fields", header + "x-owner: firmware\nlanguage:\n  cstandard: c23\n", []string{
+			"4:1: warning: x-owner: unknown field",
+			"6:3: warning: language.cstandard: unknown field",
+		}},
+		{"wrong types", header + "dependencies:\n  - example.com/acme/lib\nlanguage:\n  skc: yes please\n", []string{
+			"5:3: error: dependencies: must be a mapping",
+			"7:8: error: language.skc: must be true or false",
+		}},
+		{"bad module path and replace", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: app\nreplace:\n  example.com/acme/lib: \"\"\n", []string{
+			`3:9: error: module: invalid module path "app": expected host/owner/repo[/subdir]`,
+			`5:25: error: replace.example.com/acme/lib: invalid replace "example.com/acme/lib": replacement must be a module path or a local path`,
+		}},
+		{"bad exclude and depRoot", header + "depRoot: ../deps\nexclude:\n  - example.com/acme/lib\n", []string{
+			"4:10: error: depRoot: must be a directory inside the module",
+			`6:5: error: exclude[0]: invalid exclude "example.com/acme/lib": must be module@version`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := Validate([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if len(problems) != len(tt.want) {
+				t.Fatalf("Validate() = %v, want %d problems: %v", problems, len(tt.want), tt.want)
+			}
+			for i, p := range problems {
+				if !strings.HasPrefix(p.String(), tt.want[i]) {
+					t.Errorf("problem %d = %q, want %q", i, p.String(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_RejectsInvalidManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ManifestFileName)
+	data := "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/app\nresolution: newest\nx-owner: firmware\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	_, err := Load(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Load() error = %v, want a *ValidationError", err)
+	}
+	// Warnings do not fail loading and are not reported
+	if len(verr.Problems) != 1 || !strings.Contains(err.Error(), path+":4:13: error: resolution: must be highest or minimal") {
+		t.Errorf("Load() error = %v", err)
+	}
+	if _, err := LoadDocument(path); !errors.As(err, &verr) {
+		t.Errorf("LoadDocument() error = %v, want a *ValidationError", err)
+	}
+}
+
+// TestJSONSchema checks that the JSON Schema describes the fields of Manifest
+// and Dependency, so that the two do not drift apart.
+func TestJSONSchema(t *testing.T) {
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Defs       struct {
+			Dependency struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"dependency"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(JSONSchema, &schema); err != nil {
+		t.Fatalf("JSONSchema is not valid JSON: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		typ        reflect.Type
+		properties map[string]json.RawMessage
+	}{
+		{"Manifest", reflect.TypeOf(Manifest{}), schema.Properties},
+		{"Dependency", reflect.TypeOf(Dependency{}), schema.Defs.Dependency.Properties},
+	} {
+		var fields, properties []string
+		for i := 0; i < tt.typ.NumField(); i++ {
+			fields = append(fields, strings.Split(tt.typ.Field(i).Tag.Get("yaml"), ",")[0])
+		}
+		for property := range tt.properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(fields)
+		sort.Strings(properties)
+		if !reflect.DeepEqual(fields, properties) {
+			t.Errorf("%s fields = %v, schema properties = %v", tt.name, fields, properties)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const WorkspaceFileName = "cpkg.work"
+
+// WorkspaceEnvVar turns workspaces off when set to "off", so that every
+// module is resolved on its own.
+const WorkspaceEnvVar = "CPKG_WORK"
+
+// Workspace is a cpkg.work file, which groups modules of one repository that
+// depend on each other. Members are resolved together, so they agree on the
+// version of every shared dependency, and are used from their directories
+// instead of their tags when they depend on each other.
+type Workspace struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	// Use lists the directories of the member modules, relative to the
+	// directory of cpkg.work.
+	Use []string `yaml:"use"`
+
+	// Root is the directory of cpkg.work, set by LoadWorkspace.
+	Root string `yaml:"-"`
+}
+
+// Member is a module of a workspace.
+type Member struct {
+	Dir      string // Directory relative to the workspace root, as listed in use
+	Manifest *Manifest
+}
+
+// FindWorkspace returns the path of the nearest cpkg.work in startDir or a
+// parent, or an empty path if there is none or workspaces are turned off.
+func FindWorkspace(startDir string) string {
+	if os.Getenv(WorkspaceEnvVar) == "off" {
+		return ""
+	}
+
+	dir := startDir
+	for {
+		path := filepath.Join(dir, WorkspaceFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		if dir == "/" || dir == filepath.Dir(dir) {
+			return ""
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// LoadWorkspace reads the cpkg.work at path.
+func LoadWorkspace(path string) (*Workspace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var w Workspace
+	if err := yaml.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if w.APIVersion != APIVersion {
+		return nil, fmt.Errorf("%s: unsupported apiVersion %q, expected %q", path, w.APIVersion, APIVersion)
+	}
+	if w.Kind != "Workspace" {
+		return nil, fmt.Errorf("%s: kind must be Workspace", path)
+	}
+	if len(w.Use) == 0 {
+		return nil, fmt.Errorf("%s: use must list at least one module directory", path)
+	}
+	for i, dir := range w.Use {
+		if dir == "" || filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("%s: use[%d]: must be a directory relative to the workspace", path, i)
+		}
+		w.Use[i] = filepath.Clean(filepath.FromSlash(dir))
+	}
+	w.Root = filepath.Dir(path)
+	return &w, nil
+}
+
+// Members loads the manifest of every member, in the order of use. Two
+// members may not declare the same module path.
+func (w *Workspace) Members() ([]Member, error) {
+	members := make([]Member, 0, len(w.Use))
+	dirs := make(map[string]string) // Module path -> directory
+	for _, dir := range w.Use {
+		m, err := Load(filepath.Join(w.Root, dir, ManifestFileName))
+		if err != nil {
+			return nil, fmt.Errorf("workspace member %s: %w", dir, err)
+		}
+		if other, ok := dirs[m.Module]; ok {
+			return nil, fmt.Errorf("workspace members %s and %s are both module %s", other, dir, m.Module)
+		}
+		dirs[m.Module] = dir
+		members = append(members, Member{Dir: dir, Manifest: m})
+	}
+	return members, nil
+}
+
+// MemberDir returns the entry of use naming dir, if dir is the directory of
+// a member.
+func (w *Workspace) MemberDir(dir string) (string, bool) {
+	rel, err := filepath.Rel(w.Root, dir)
+	if err != nil {
+		return "", false
+	}
+	for _, use := range w.Use {
+		if use == rel {
+			return use, true
+		}
+	}
+	return "", false
+}
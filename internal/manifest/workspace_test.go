@@ -0,0 +1,94 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	root := t.TempDir()
+	// A module around the workspace, which its directories must not find
+	writeFile(t, filepath.Join(filepath.Dir(root), ManifestFileName), "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/outer\n")
+	writeFile(t, filepath.Join(root, WorkspaceFileName), "apiVersion: cpkg.ringil.dev/v0\nkind: Workspace\nuse:\n  - libs/span\n  - ./app/\n")
+	writeFile(t, filepath.Join(root, "libs/span", ManifestFileName), "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/span\n")
+	writeFile(t, filepath.Join(root, "app", ManifestFileName), "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/app\n")
+
+	// Discovery from a member and from the root
+	path := FindWorkspace(filepath.Join(root, "libs/span"))
+	if path != filepath.Join(root, WorkspaceFileName) {
+		t.Fatalf("FindWorkspace() = %q", path)
+	}
+	if found, err := FindManifest(filepath.Join(root, "app")); err != nil || found != filepath.Join(root, "app", ManifestFileName) {
+		t.Errorf("FindManifest(app) = %q, %v", found, err)
+	}
+	if _, err := FindManifest(filepath.Join(root, "libs")); !errors.Is(err, ErrWorkspaceRoot) {
+		t.Errorf("FindManifest(libs) error = %v, want ErrWorkspaceRoot", err)
+	}
+
+	w, err := LoadWorkspace(path)
+	if err != nil {
+		t.Fatalf("LoadWorkspace() error = %v", err)
+	}
+	if w.Root != root || len(w.Use) != 2 || w.Use[1] != "app" {
+		t.Errorf("LoadWorkspace() = %+v", w)
+	}
+	if dir, ok := w.MemberDir(filepath.Join(root, "libs/span")); !ok || dir != filepath.Join("libs", "span") {
+		t.Errorf("MemberDir(libs/span) = %q, %v", dir, ok)
+	}
+	if _, ok := w.MemberDir(filepath.Join(root, "libs")); ok {
+		t.Error("MemberDir(libs) = true, want false")
+	}
+	members, err := w.Members()
+	if err != nil {
+		t.Fatalf("Members() error = %v", err)
+	}
+	if len(members) != 2 || members[0].Manifest.Module != "example.com/acme/span" || members[1].Dir != "app" {
+		t.Errorf("Members() = %+v", members)
+	}
+
+	// Two members with the same module path
+	writeFile(t, filepath.Join(root, "app", ManifestFileName), "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nmodule: example.com/acme/span\n")
+	if _, err := w.Members(); err == nil || !strings.Contains(err.Error(), "are both module example.com/acme/span") {
+		t.Errorf("Members() error = %v", err)
+	}
+
+	// Turned off, there is no workspace
+	t.Setenv(WorkspaceEnvVar, "off")
+	if path := FindWorkspace(root); path != "" {
+		t.Errorf("FindWorkspace() with %s=off = %q", WorkspaceEnvVar, path)
+	}
+}
+
+func TestLoadWorkspace_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"wrong kind", "apiVersion: cpkg.ringil.dev/v0\nkind: Module\nuse: [app]\n", "kind must be Workspace"},
+		{"no members", "apiVersion: cpkg.ringil.dev/v0\nkind: Workspace\n", "use must list at least one module directory"},
+		{"absolute member", "apiVersion: cpkg.ringil.dev/v0\nkind: Workspace\nuse: [/src/app]\n", "use[0]: must be a directory relative to the workspace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), WorkspaceFileName)
+			writeFile(t, path, tt.data)
+			if _, err := LoadWorkspace(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadWorkspace() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+// Package resolver selects a version for every module in a dependency graph.
+//
+// The resolver knows nothing about git: module versions and the requirements
+// declared at each version are supplied by a Source. Starting from the root
+// module's requirements, it repeatedly selects a version for each required
+// module that satisfies every constraint placed on it, reads that version's own
+// requirements, and continues until the selection no longer changes.
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SCKelemen/cpkg/internal/semver"
+)
+
+// maxSteps bounds the number of times modules are re-evaluated, so that a
+// pathological graph cannot loop forever.
+const maxSteps = 10000
+
+// Strategy decides which of the versions satisfying all constraints is selected.
+type Strategy string
+
+const (
+	// StrategyHighest selects the newest version satisfying all constraints.
+	StrategyHighest Strategy = "highest"
+	// StrategyMinimal selects the oldest version satisfying all constraints
+	// (minimal version selection). The result only changes when constraints
+	// change, not when new versions are published.
+	StrategyMinimal Strategy = "minimal"
+)
+
+// ParseStrategy parses a strategy name. An empty string selects StrategyHighest.
+func ParseStrategy(s string) (Strategy, error) {
+	switch s {
+	case "", string(StrategyHighest):
+		return StrategyHighest, nil
+	case string(StrategyMinimal):
+		return StrategyMinimal, nil
+	default:
+		return StrategyHighest, fmt.Errorf("invalid resolution strategy: %s (must be highest or minimal)", s)
+	}
+}
+
+// Source provides the information the resolver needs about modules.
+type Source interface {
+	// Versions returns the versions available for module, in any order. It
+	// is called each time the module is re-evaluated, since the list may grow
+	// during resolution (e.g. as commits pinned by a requirer are discovered),
+	// so implementations should cache expensive lookups themselves.
+	Versions(module string) ([]string, error)
+	// Requirements returns the dependencies declared by module at version,
+	// as module path -> constraint.
+	Requirements(module, version string) (map[string]string, error)
+}
+
+// Prefetcher is implemented by Sources that can look modules up ahead of
+// time. Before evaluating the queued modules, the resolver passes the ones it
+// has not seen yet to Prefetch, so a source backed by the network can query
+// them concurrently instead of one Versions call at a time.
+type Prefetcher interface {
+	Prefetch(modules []string)
+}
+
+// Options configures a resolution.
+type Options struct {
+	Strategy Strategy
+	// Fixed maps modules to the version that must be selected for them
+	// regardless of the constraints placed on them, e.g. because a replace
+	// directive substitutes a specific version or a local directory. Their
+	// versions are never listed from the Source.
+	Fixed map[string]string
+}
+
+// Selection is the version selected for a module.
+type Selection struct {
+	Module   string
+	Version  string
+	Requires map[string]string // Requirements declared at Version
+}
+
+// Result is the outcome of a resolution.
+type Result struct {
+	Root    string
+	Modules map[string]*Selection // Every module reachable from the root
+}
+
+// Resolve resolves the dependency graph of the root module, whose direct
+// requirements are given as module path -> constraint.
+func Resolve(root string, requirements map[string]string, src Source, opts Options) (*Result, error) {
+	r := &resolution{
+		root:         root,
+		src:          src,
+		strategy:     opts.Strategy,
+		fixed:        opts.Fixed,
+		requirements: make(map[string]map[string]string),
+		selected:     make(map[string]*Selection),
+		versions:     make(map[string][]string),
+		conflicts:    make(map[string]bool),
+		prefetched:   make(map[string]bool),
+	}
+	if r.strategy == "" {
+		r.strategy = StrategyHighest
+	}
+
+	r.require(root, requirements)
+
+	for steps := 0; ; steps++ {
+		if steps > maxSteps {
+			return nil, fmt.Errorf("dependency resolution did not converge after %d steps", maxSteps)
+		}
+
+		if len(r.queue) == 0 {
+			// A conflict seen mid-resolution may come from a requirer that
+			// has since dropped out of the graph; only report the ones that
+			// remain once everything else has settled.
+			if err := r.settleConflicts(); err != nil {
+				return nil, err
+			}
+			if len(r.queue) == 0 {
+				break
+			}
+		}
+
+		r.prefetch()
+		module := r.queue[0]
+		r.queue = r.queue[1:]
+		if err := r.visit(module); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Result{
+		Root:    root,
+		Modules: r.reachable(requirements),
+	}, nil
+}
+
+type resolution struct {
+	root     string
+	src      Source
+	strategy Strategy
+	fixed    map[string]string
+
+	requirements map[string]map[string]string // module -> requirer -> constraint
+	selected     map[string]*Selection
+	versions     map[string][]string // Latest Source.Versions results
+	conflicts    map[string]bool     // Modules whose constraints could not be satisfied
+	prefetched   map[string]bool     // Modules passed to Prefetcher.Prefetch
+	queue        []string
+}
+
+// prefetch passes the queued modules that have not been prefetched yet to
+// the source, if it is a Prefetcher. Fixed modules are never looked up.
+func (r *resolution) prefetch() {
+	p, ok := r.src.(Prefetcher)
+	if !ok {
+		return
+	}
+	var modules []string
+	for _, module := range r.queue {
+		if _, fixed := r.fixed[module]; fixed || r.prefetched[module] {
+			continue
+		}
+		r.prefetched[module] = true
+		modules = append(modules, module)
+	}
+	if len(modules) > 0 {
+		p.Prefetch(modules)
+	}
+}
+
+// require records the requirements declared by requirer and queues the
+// required modules for (re-)evaluation.
+func (r *resolution) require(requirer string, deps map[string]string) {
+	for _, dep := range sortedKeys(deps) {
+		if dep == r.root {
+			continue // A dependency cycle back to the root is satisfied by the root itself
+		}
+		if r.requirements[dep] == nil {
+			r.requirements[dep] = make(map[string]string)
+		}
+		r.requirements[dep][requirer] = deps[dep]
+		r.queue = append(r.queue, dep)
+	}
+}
+
+// release removes the requirements declared by requirer.
+func (r *resolution) release(requirer string, deps map[string]string) {
+	for _, dep := range sortedKeys(deps) {
+		delete(r.requirements[dep], requirer)
+		r.queue = append(r.queue, dep)
+	}
+}
+
+func (r *resolution) visit(module string) error {
+	current := r.selected[module]
+	delete(r.conflicts, module)
+	if len(r.requirements[module]) == 0 {
+		// No longer required by anything: release its own requirements
+		if current != nil {
+			delete(r.selected, module)
+			r.release(module, current.Requires)
+		}
+		return nil
+	}
+
+	version, fixed := r.fixed[module]
+	if !fixed {
+		versions, err := r.src.Versions(module)
+		if err != nil {
+			return err
+		}
+		r.versions[module] = versions
+
+		constraints := distinctConstraints(r.requirements[module])
+		version, err = Select(versions, constraints, r.strategy)
+		var invalid *ConstraintError
+		if errors.As(err, &invalid) {
+			return r.constraintError(module, invalid)
+		}
+		if err != nil {
+			r.conflicts[module] = true
+			return nil
+		}
+	}
+
+	if current != nil && current.Version == version {
+		return nil
+	}
+
+	requires, err := r.src.Requirements(module, version)
+	if err != nil {
+		return fmt.Errorf("failed to read dependencies of %s@%s: %w", module, version, err)
+	}
+
+	if current != nil {
+		r.release(module, current.Requires)
+	}
+	r.selected[module] = &Selection{
+		Module:   module,
+		Version:  version,
+		Requires: requires,
+	}
+	r.require(module, requires)
+	return nil
+}
+
+// constraintError attributes err, for a constraint on module that cannot be
+// parsed, to the module that requires it.
+func (r *resolution) constraintError(module string, err *ConstraintError) *ConstraintError {
+	e := *err
+	e.Module = module
+	for _, requirer := range sortedKeys(r.requirements[module]) {
+		if r.requirements[module][requirer] == e.Constraint {
+			e.Requirer = requirer
+			break
+		}
+	}
+	return &e
+}
+
+// settleConflicts re-evaluates the modules that had unsatisfiable constraints.
+// Modules that have become satisfiable are queued again; if any remain in
+// conflict, a *ConflictError is returned for the first one.
+func (r *resolution) settleConflicts() error {
+	for _, module := range sortedKeys(r.conflicts) {
+		if len(r.requirements[module]) == 0 {
+			delete(r.conflicts, module)
+			continue
+		}
+		constraints := distinctConstraints(r.requirements[module])
+		if _, err := Select(r.versions[module], constraints, r.strategy); err == nil {
+			delete(r.conflicts, module)
+			r.queue = append(r.queue, module)
+		}
+	}
+	if len(r.queue) > 0 || len(r.conflicts) == 0 {
+		return nil
+	}
+	return r.conflictError(sortedKeys(r.conflicts)[0])
+}
+
+// conflictError describes the requirements on module, with the chain of
+// modules through which each requirer was reached from the root.
+func (r *resolution) conflictError(module string) *ConflictError {
+	// Shortest paths from the root over the currently selected graph
+	parents := make(map[string]string)
+	seen := map[string]bool{r.root: true}
+	queue := []string{r.root}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		for _, dep := range sortedKeys(r.requirements) {
+			if _, ok := r.requirements[dep][m]; ok && !seen[dep] {
+				seen[dep] = true
+				parents[dep] = m
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	conflict := &ConflictError{Module: module}
+	for _, requirer := range sortedKeys(r.requirements[module]) {
+		constraint := r.requirements[module][requirer]
+		req := Requirement{
+			Requirer:   requirer,
+			Constraint: constraint,
+		}
+		if sel, ok := r.selected[requirer]; ok {
+			req.RequirerVersion = sel.Version
+		}
+
+		// Walk back to the root to build the chain, e.g. [root, a@v1.0.0]
+		for m := requirer; m != ""; m = parents[m] {
+			step := m
+			if sel, ok := r.selected[m]; ok && m != r.root {
+				step = m + "@" + sel.Version
+			}
+			req.Path = append([]string{step}, req.Path...)
+			if m == r.root {
+				break
+			}
+		}
+
+		if _, err := Select(r.versions[module], []string{constraint}, r.strategy); err != nil {
+			req.Unsatisfiable = true
+		}
+		conflict.Requirements = append(conflict.Requirements, req)
+	}
+	return conflict
+}
+
+// Requirement is a constraint placed on a module by one of its requirers.
+type Requirement struct {
+	Requirer        string   `json:"requirer" yaml:"requirer"`
+	RequirerVersion string   `json:"requirer_version,omitempty" yaml:"requirer_version,omitempty"`
+	Constraint      string   `json:"constraint" yaml:"constraint"`
+	Path            []string `json:"path" yaml:"path"`                                       // Chain from the root to the requirer, e.g. [root, a@v1.0.0]
+	Unsatisfiable   bool     `json:"unsatisfiable,omitempty" yaml:"unsatisfiable,omitempty"` // No version matches this constraint on its own
+}
+
+// ConflictError is returned by Resolve when no version of a module satisfies
+// all of the constraints placed on it.
+type ConflictError struct {
+	Module       string        `json:"module" yaml:"module"`
+	Requirements []Requirement `json:"requirements" yaml:"requirements"`
+}
+
+func (e *ConflictError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "no version of %s satisfies all requirements:", e.Module)
+	for _, req := range e.Requirements {
+		fmt.Fprintf(&b, "\n  %s requires %s", strings.Join(req.Path, " → "), req.Constraint)
+		if req.Unsatisfiable {
+			b.WriteString(" (no matching version)")
+		}
+	}
+	return b.String()
+}
+
+// ConstraintError is returned by Select and Resolve for a constraint that
+// cannot be parsed. Resolve also names the module the constraint is placed on
+// and the module that requires it.
+type ConstraintError struct {
+	Module     string
+	Requirer   string
+	Constraint string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Requirer != "" {
+		return fmt.Sprintf("%s requires %s with invalid constraint %q: %v", e.Requirer, e.Module, e.Constraint, e.Err)
+	}
+	return fmt.Sprintf("invalid constraint %q: %v", e.Constraint, e.Err)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNoVersion is returned by Select when no version satisfies the
+// constraints.
+var ErrNoVersion = errors.New("no compatible version found")
+
+// reachable returns the selections reachable from the root requirements.
+func (r *resolution) reachable(requirements map[string]string) map[string]*Selection {
+	modules := make(map[string]*Selection)
+	queue := sortedKeys(requirements)
+	for len(queue) > 0 {
+		module := queue[0]
+		queue = queue[1:]
+		sel, ok := r.selected[module]
+		if !ok || modules[module] != nil {
+			continue
+		}
+		modules[module] = sel
+		queue = append(queue, sortedKeys(sel.Requires)...)
+	}
+	return modules
+}
+
+// Select returns the version from versions that satisfies every constraint,
+// choosing the highest or lowest match according to strategy. The returned
+// string is the original entry from versions (e.g. with its "v" prefix). The
+// error is a *ConstraintError if a constraint cannot be parsed, and wraps
+// ErrNoVersion if no version satisfies them.
+func Select(versions []string, constraints []string, strategy Strategy) (string, error) {
+	combined, err := intersect(constraints)
+	if err != nil {
+		return "", err
+	}
+	if combined.IsEmpty() {
+		return "", fmt.Errorf("%w: constraints %s do not overlap", ErrNoVersion, strings.Join(constraints, ", "))
+	}
+
+	var selected *semver.Version
+	var selectedStr string
+
+	for _, version := range versions {
+		v, err := semver.Parse(version)
+		if err != nil {
+			continue // Skip invalid versions
+		}
+		if !combined.Check(v) {
+			continue
+		}
+
+		if selected == nil ||
+			(strategy == StrategyMinimal && v.Compare(selected) < 0) ||
+			(strategy != StrategyMinimal && v.Compare(selected) > 0) {
+			selected = v
+			selectedStr = version
+		}
+	}
+
+	if selected == nil {
+		return "", ErrNoVersion
+	}
+	return selectedStr, nil
+}
+
+// intersect parses constraints and combines them into the single constraint
+// that every selected version must satisfy.
+func intersect(constraints []string) (*semver.Constraint, error) {
+	var combined *semver.Constraint
+	for _, constraint := range constraints {
+		c, err := semver.ParseConstraint(constraint)
+		if err != nil {
+			return nil, &ConstraintError{Constraint: constraint, Err: err}
+		}
+		if combined == nil {
+			combined = c
+		} else {
+			combined = combined.Intersect(c)
+		}
+	}
+	if combined == nil {
+		return semver.MustParseConstraint(""), nil
+	}
+	return combined, nil
+}
+
+// distinctConstraints returns the distinct constraints in reqs in a stable order.
+func distinctConstraints(reqs map[string]string) []string {
+	seen := make(map[string]bool)
+	var constraints []string
+	for _, requirer := range sortedKeys(reqs) {
+		c := reqs[requirer]
+		if !seen[c] {
+			seen[c] = true
+			constraints = append(constraints, c)
+		}
+	}
+	return constraints
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,343 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeSource is an in-memory Source: module -> version -> requirements.
+type fakeSource map[string]map[string]map[string]string
+
+func (s fakeSource) Versions(module string) ([]string, error) {
+	versions, ok := s[module]
+	if !ok {
+		return nil, fmt.Errorf("unknown module %s", module)
+	}
+	var list []string
+	for v := range versions {
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (s fakeSource) Requirements(module, version string) (map[string]string, error) {
+	return s[module][version], nil
+}
+
+func TestResolve(t *testing.T) {
+	src := fakeSource{
+		"a": {
+			"v1.0.0": {"c": "^1.0.0"},
+			"v1.1.0": {"c": "^1.2.0"},
+		},
+		"b": {
+			"v1.0.0": {"c": "~1.2.0"},
+		},
+		"c": {
+			"v1.0.0": nil,
+			"v1.2.0": nil,
+			"v1.2.5": nil,
+			"v1.3.0": nil,
+			"v2.0.0": nil,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		strategy Strategy
+		want     map[string]string
+	}{
+		{"highest", StrategyHighest, map[string]string{"a": "v1.1.0", "b": "v1.0.0", "c": "v1.2.5"}},
+		{"minimal", StrategyMinimal, map[string]string{"a": "v1.0.0", "b": "v1.0.0", "c": "v1.2.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Resolve("root", map[string]string{"a": "^1.0.0", "b": "^1.0.0"}, src, Options{Strategy: tt.strategy})
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if len(result.Modules) != len(tt.want) {
+				t.Fatalf("Resolve() selected %d modules, want %d", len(result.Modules), len(tt.want))
+			}
+			for module, version := range tt.want {
+				sel, ok := result.Modules[module]
+				if !ok {
+					t.Errorf("%s not selected", module)
+					continue
+				}
+				if sel.Version != version {
+					t.Errorf("%s = %s, want %s", module, sel.Version, version)
+				}
+			}
+		})
+	}
+}
+
+func TestResolve_MinimalIgnoresNewReleases(t *testing.T) {
+	src := fakeSource{
+		"a": {"v1.0.0": {"b": "^1.1.0"}},
+		"b": {"v1.1.0": nil, "v1.2.0": nil},
+	}
+	reqs := map[string]string{"a": "^1.0.0"}
+
+	before, err := Resolve("root", reqs, src, Options{Strategy: StrategyMinimal})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	src["a"]["v1.0.1"] = nil
+	src["b"]["v1.9.0"] = nil
+
+	after, err := Resolve("root", reqs, src, Options{Strategy: StrategyMinimal})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	for module, sel := range before.Modules {
+		if after.Modules[module].Version != sel.Version {
+			t.Errorf("%s changed from %s to %s after new releases", module, sel.Version, after.Modules[module].Version)
+		}
+	}
+}
+
+func TestResolve_DropsUnreachableModules(t *testing.T) {
+	// a@v1.1.0 no longer needs d, which only a@v1.0.0 required.
+	src := fakeSource{
+		"a": {
+			"v1.0.0": {"d": "^1.0.0"},
+			"v1.1.0": nil,
+		},
+		"b": {"v1.0.0": {"a": "^1.1.0"}},
+		"d": {"v1.0.0": nil},
+	}
+
+	result, err := Resolve("root", map[string]string{"a": "^1.0.0", "b": "^1.0.0"}, src, Options{Strategy: StrategyMinimal})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if result.Modules["a"].Version != "v1.1.0" {
+		t.Errorf("a = %s, want v1.1.0", result.Modules["a"].Version)
+	}
+	if _, ok := result.Modules["d"]; ok {
+		t.Error("d should not be selected once a@v1.0.0 is no longer in the graph")
+	}
+}
+
+func TestResolve_Unsatisfiable(t *testing.T) {
+	src := fakeSource{
+		"a": {"v1.0.0": {"c": "^2.0.0"}},
+		"c": {"v1.0.0": nil, "v2.0.0": nil},
+	}
+
+	_, err := Resolve("root", map[string]string{"a": "^1.0.0", "c": "^1.0.0"}, src, Options{})
+	if err == nil {
+		t.Fatal("expected an error for incompatible constraints")
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Strategy
+		wantErr bool
+	}{
+		{"", StrategyHighest, false},
+		{"highest", StrategyHighest, false},
+		{"minimal", StrategyMinimal, false},
+		{"lowest", StrategyHighest, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseStrategy(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseStrategy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseStrategy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	versions := []string{"v1.0.0", "v1.4.2", "v1.2.0", "v2.0.0", "not-a-version"}
+
+	if got, _ := Select(versions, []string{"^1.0.0"}, StrategyHighest); got != "v1.4.2" {
+		t.Errorf("Select(highest) = %s, want v1.4.2", got)
+	}
+	if got, _ := Select(versions, []string{"^1.1.0"}, StrategyMinimal); got != "v1.2.0" {
+		t.Errorf("Select(minimal) = %s, want v1.2.0", got)
+	}
+	if _, err := Select(versions, []string{"^3.0.0"}, StrategyHighest); !errors.Is(err, ErrNoVersion) {
+		t.Errorf("Select() error = %v, want ErrNoVersion when nothing satisfies the constraint", err)
+	}
+	if _, err := Select(versions, []string{"^1.0.0", "^2.0.0"}, StrategyHighest); !errors.Is(err, ErrNoVersion) {
+		t.Errorf("Select() error = %v, want ErrNoVersion when the constraints do not overlap", err)
+	}
+	var invalid *ConstraintError
+	if _, err := Select(versions, []string{"^1.0.0", "^one"}, StrategyHighest); !errors.As(err, &invalid) || invalid.Constraint != "^one" {
+		t.Errorf("Select() error = %v, want a *ConstraintError for ^one", err)
+	}
+
+	candidates := []string{"v2.0.0-rc.2", "v2.0.0-rc.10", "v2.0.0-rc.1", "v1.9.0"}
+	if got, _ := Select(candidates, []string{">=2.0.0-rc.1"}, StrategyHighest); got != "v2.0.0-rc.10" {
+		t.Errorf("Select(rc) = %s, want v2.0.0-rc.10", got)
+	}
+	if got, _ := Select(candidates, []string{">=1.0.0"}, StrategyHighest); got != "v1.9.0" {
+		t.Errorf("Select(release range) = %s, want v1.9.0 (pre-releases are not named)", got)
+	}
+}
+
+func TestResolve_ConflictReport(t *testing.T) {
+	src := fakeSource{
+		"a": {"v1.0.0": {"c": "~1.4.0"}},
+		"b": {"v2.1.0": {"c": "^2.0.0"}},
+		"c": {"v1.2.0": nil, "v1.4.3": nil, "v2.0.0": nil},
+	}
+
+	_, err := Resolve("root", map[string]string{"a": "^1.0.0", "b": "^2.0.0", "c": "^1.2.0"}, src, Options{})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Resolve() error = %v, want *ConflictError", err)
+	}
+
+	if conflict.Module != "c" {
+		t.Errorf("conflict module = %s, want c", conflict.Module)
+	}
+
+	want := []Requirement{
+		{Requirer: "a", RequirerVersion: "v1.0.0", Constraint: "~1.4.0", Path: []string{"root", "a@v1.0.0"}},
+		{Requirer: "b", RequirerVersion: "v2.1.0", Constraint: "^2.0.0", Path: []string{"root", "b@v2.1.0"}},
+		{Requirer: "root", Constraint: "^1.2.0", Path: []string{"root"}},
+	}
+	if len(conflict.Requirements) != len(want) {
+		t.Fatalf("got %d requirements, want %d: %+v", len(conflict.Requirements), len(want), conflict.Requirements)
+	}
+	for i, req := range conflict.Requirements {
+		if req.Requirer != want[i].Requirer || req.RequirerVersion != want[i].RequirerVersion ||
+			req.Constraint != want[i].Constraint || strings.Join(req.Path, " ") != strings.Join(want[i].Path, " ") {
+			t.Errorf("requirement %d = %+v, want %+v", i, req, want[i])
+		}
+	}
+
+	msg := err.Error()
+	for _, line := range []string{"root → a@v1.0.0 requires ~1.4.0", "root → b@v2.1.0 requires ^2.0.0", "root requires ^1.2.0"} {
+		if !strings.Contains(msg, line) {
+			t.Errorf("error message missing %q:\n%s", line, msg)
+		}
+	}
+}
+
+func TestResolve_InvalidConstraint(t *testing.T) {
+	src := fakeSource{
+		"a": {"v1.0.0": {"c": "^1.0.0"}},
+		"b": {"v1.0.0": {"c": ">=1.0 <<2"}},
+		"c": {"v1.0.0": nil},
+	}
+
+	_, err := Resolve("root", map[string]string{"a": "^1.0.0", "b": "^1.0.0"}, src, Options{})
+	var invalid *ConstraintError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Resolve() error = %v, want *ConstraintError", err)
+	}
+	if invalid.Module != "c" || invalid.Requirer != "b" || invalid.Constraint != ">=1.0 <<2" {
+		t.Errorf("ConstraintError = %+v, want b's constraint on c", invalid)
+	}
+	if want := `b requires c with invalid constraint ">=1.0 <<2"`; !strings.Contains(err.Error(), want) {
+		t.Errorf("Resolve() error = %q, want it to contain %q", err, want)
+	}
+}
+
+func TestResolve_TransientConflictIsNotReported(t *testing.T) {
+	// While a is still at v1.0.0, d's ^1.0.0 clashes with b's ^2.0.0. Once b
+	// pushes a to v1.1.0, d leaves the graph and the clash disappears.
+	src := fakeSource{
+		"a": {
+			"v1.0.0": {"d": "^1.0.0"},
+			"v1.1.0": nil,
+		},
+		"b": {"v1.0.0": {"a": "^1.1.0", "d": "^2.0.0"}},
+		"d": {"v1.0.0": nil, "v2.0.0": nil},
+	}
+
+	result, err := Resolve("root", map[string]string{"a": "^1.0.0", "b": "^1.0.0"}, src, Options{Strategy: StrategyMinimal})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if result.Modules["d"].Version != "v2.0.0" {
+		t.Errorf("d = %s, want v2.0.0", result.Modules["d"].Version)
+	}
+}
+
+func TestResolve_FixedVersionIgnoresConstraints(t *testing.T) {
+	// b is pinned to a version outside a's constraint and is never listed
+	src := fakeSource{
+		"a": {"v1.0.0": {"b": "^1.0.0"}},
+		"c": {"v1.0.0": nil},
+	}
+	fixedSrc := fixedRequirements{fakeSource: src, module: "b", version: "v2.0.0-fork", requires: map[string]string{"c": "^1.0.0"}}
+
+	result, err := Resolve("root", map[string]string{"a": "^1.0.0"}, fixedSrc, Options{
+		Fixed: map[string]string{"b": "v2.0.0-fork"},
+	})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got := result.Modules["b"].Version; got != "v2.0.0-fork" {
+		t.Errorf("b = %s, want v2.0.0-fork", got)
+	}
+	if _, ok := result.Modules["c"]; !ok {
+		t.Error("requirements of the fixed module were not resolved")
+	}
+}
+
+// fixedRequirements serves the requirements of one module that has no
+// listable versions.
+type fixedRequirements struct {
+	fakeSource
+	module, version string
+	requires        map[string]string
+}
+
+func (s fixedRequirements) Requirements(module, version string) (map[string]string, error) {
+	if module == s.module && version == s.version {
+		return s.requires, nil
+	}
+	return s.fakeSource.Requirements(module, version)
+}
+
+// prefetchingSource records the batches passed to Prefetch.
+type prefetchingSource struct {
+	fakeSource
+	batches [][]string
+}
+
+func (s *prefetchingSource) Prefetch(modules []string) {
+	s.batches = append(s.batches, modules)
+}
+
+func TestResolve_Prefetch(t *testing.T) {
+	src := &prefetchingSource{fakeSource: fakeSource{
+		"a": {"v1.0.0": {"c": "^1.0.0", "d": "^1.0.0"}},
+		"b": {"v1.0.0": {"c": "^1.0.0"}},
+		"c": {"v1.0.0": nil},
+		"d": {"v1.0.0": nil},
+	}}
+
+	_, err := Resolve("root", map[string]string{"a": "^1.0.0", "b": "^1.0.0", "f": "^1.0.0"}, src, Options{
+		Fixed: map[string]string{"f": "v1.0.0"},
+	})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	// Each level of the graph is prefetched as a batch, each module once
+	want := "[[a b] [c d]]"
+	if got := fmt.Sprint(src.batches); got != want {
+		t.Errorf("Prefetch batches = %s, want %s", got, want)
+	}
+}
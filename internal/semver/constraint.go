@@ -0,0 +1,468 @@
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Constraint is a parsed version constraint: a union ("||") of ranges, each of
+// which is the intersection of one or more comparators.
+//
+// Supported forms:
+//
+//	1.2.3, =1.2.3         exact version
+//	>1.2.3 >=1.2 <2 <=2.1 comparisons (partial versions are filled in)
+//	^1.2.3 ^0.2 ^1        compatible with (same left-most non-zero component)
+//	~1.2.3 ~1.2 ~>1.2.3   same major.minor (or same major for ~1)
+//	1.x 1.2.* * x         wildcards
+//	1.2.3 - 1.4.0         inclusive hyphen range
+//	>=1.2.0 <2.0.0        intersection (whitespace or comma separated)
+//	^1.2 || ^2.0          union
+//
+// A pre-release version only satisfies a range if one of the range's
+// comparators names a pre-release of the same major.minor.patch: 1.3.0-rc.1
+// satisfies ">=1.3.0-beta" and "^1.3.0-rc.0", but not "^1.2.0" or ">=1.0.0".
+type Constraint struct {
+	raw    string
+	ranges []Range
+}
+
+// Range is a contiguous interval of versions, the intersection of the
+// comparators it was built from. A nil bound is unbounded.
+type Range struct {
+	lower *bound
+	upper *bound
+
+	// prereleases are the pre-release versions named by the comparators.
+	// Other pre-release versions only match if they share major.minor.patch
+	// with one of them.
+	prereleases []*Version
+}
+
+type bound struct {
+	version   *Version
+	inclusive bool
+}
+
+// partial is a version in a constraint, with possibly missing or wildcard
+// components: n is the number of leading numeric components given.
+type partial struct {
+	major, minor, patch int
+	n                   int
+	pre, build          string
+}
+
+var partialRegex = regexp.MustCompile(`^v?(\d+|[xX*])(?:\.(\d+|[xX*])(?:\.(\d+|[xX*])(?:-([\w\.-]+))?(?:\+([\w\.-]+))?)?)?$`)
+
+var hyphenRegex = regexp.MustCompile(`^(\S+)\s+-\s+(\S+)$`)
+
+// operators in the order they must be tried, longest first.
+var operators = []string{">=", "<=", "~>", ">", "<", "=", "^", "~"}
+
+// ParseConstraint parses a constraint string. An empty string (or "*") matches
+// every version.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: strings.TrimSpace(s)}
+	for _, part := range strings.Split(c.raw, "||") {
+		r, err := parseRange(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+		}
+		if !r.IsEmpty() {
+			c.ranges = append(c.ranges, r)
+		}
+	}
+	return c, nil
+}
+
+// MustParseConstraint is like ParseConstraint but panics on error.
+func MustParseConstraint(s string) *Constraint {
+	c, err := ParseConstraint(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Check reports whether v satisfies the constraint.
+func (c *Constraint) Check(v *Version) bool {
+	for _, r := range c.ranges {
+		if r.Contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the constraint satisfied by versions that satisfy both c
+// and other.
+func (c *Constraint) Intersect(other *Constraint) *Constraint {
+	result := &Constraint{}
+	for _, a := range c.ranges {
+		for _, b := range other.ranges {
+			if r := a.Intersect(b); !r.IsEmpty() {
+				result.ranges = append(result.ranges, r)
+			}
+		}
+	}
+	return result
+}
+
+// IsEmpty reports whether no version can satisfy the constraint.
+func (c *Constraint) IsEmpty() bool {
+	return len(c.ranges) == 0
+}
+
+// Ranges returns the non-empty ranges whose union is the constraint.
+func (c *Constraint) Ranges() []Range {
+	return c.ranges
+}
+
+// String returns the constraint as written, or a normalized form for
+// constraints built with Intersect.
+func (c *Constraint) String() string {
+	if c.raw != "" {
+		return c.raw
+	}
+	if len(c.ranges) == 0 {
+		return "<0.0.0"
+	}
+	parts := make([]string, len(c.ranges))
+	for i, r := range c.ranges {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, " || ")
+}
+
+// Contains reports whether v lies within the range. A pre-release version must
+// also share major.minor.patch with a pre-release named by the range.
+func (r Range) Contains(v *Version) bool {
+	if v.Pre != "" && !r.allowsPrerelease(v) {
+		return false
+	}
+	if r.lower != nil {
+		cmp := v.Compare(r.lower.version)
+		if cmp < 0 || (cmp == 0 && !r.lower.inclusive) {
+			return false
+		}
+	}
+	if r.upper != nil {
+		cmp := v.Compare(r.upper.version)
+		if cmp > 0 || (cmp == 0 && !r.upper.inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// Intersect returns the range of versions contained in both r and other.
+func (r Range) Intersect(other Range) Range {
+	result := Range{
+		lower: tighterLower(r.lower, other.lower),
+		upper: tighterUpper(r.upper, other.upper),
+	}
+	// A pre-release must be allowed by both ranges
+	for _, pre := range r.prereleases {
+		if other.allowsPrerelease(pre) {
+			result.prereleases = append(result.prereleases, pre)
+		}
+	}
+	return result
+}
+
+// narrow adds the comparator c to the range: the bounds are intersected and
+// pre-releases named by either are allowed.
+func (r Range) narrow(c Range) Range {
+	return Range{
+		lower:       tighterLower(r.lower, c.lower),
+		upper:       tighterUpper(r.upper, c.upper),
+		prereleases: append(append([]*Version(nil), r.prereleases...), c.prereleases...),
+	}
+}
+
+func (r Range) allowsPrerelease(v *Version) bool {
+	for _, pre := range r.prereleases {
+		if pre.Major == v.Major && pre.Minor == v.Minor && pre.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEmpty reports whether the range contains no versions.
+func (r Range) IsEmpty() bool {
+	if r.lower == nil || r.upper == nil {
+		return false
+	}
+	cmp := r.lower.version.Compare(r.upper.version)
+	return cmp > 0 || (cmp == 0 && !(r.lower.inclusive && r.upper.inclusive))
+}
+
+func (r Range) String() string {
+	var parts []string
+	if r.lower != nil && r.upper != nil && r.lower.inclusive && r.upper.inclusive &&
+		r.lower.version.Compare(r.upper.version) == 0 {
+		return "=" + r.lower.version.String()
+	}
+	if r.lower != nil {
+		op := ">"
+		if r.lower.inclusive {
+			op = ">="
+		}
+		parts = append(parts, op+r.lower.version.String())
+	}
+	if r.upper != nil {
+		op := "<"
+		if r.upper.inclusive {
+			op = "<="
+		}
+		parts = append(parts, op+r.upper.version.String())
+	}
+	if len(parts) == 0 {
+		return "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+func tighterLower(a, b *bound) *bound {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	cmp := a.version.Compare(b.version)
+	if cmp > 0 || (cmp == 0 && !a.inclusive) {
+		return a
+	}
+	return b
+}
+
+func tighterUpper(a, b *bound) *bound {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	cmp := a.version.Compare(b.version)
+	if cmp < 0 || (cmp == 0 && !a.inclusive) {
+		return a
+	}
+	return b
+}
+
+// parseRange parses one side of a union: a hyphen range or a list of
+// comparators separated by whitespace or commas.
+func parseRange(s string) (Range, error) {
+	if s == "" {
+		return Range{}, nil
+	}
+
+	if m := hyphenRegex.FindStringSubmatch(s); m != nil {
+		from, err := parsePartial(m[1])
+		if err != nil {
+			return Range{}, err
+		}
+		to, err := parsePartial(m[2])
+		if err != nil {
+			return Range{}, err
+		}
+		r := Range{lower: from.lowerBound(), upper: to.upperBound(true)}
+		for _, p := range []partial{from, to} {
+			if p.pre != "" {
+				r.prereleases = append(r.prereleases, p.version())
+			}
+		}
+		return r, nil
+	}
+
+	tokens := strings.Fields(strings.ReplaceAll(s, ",", " "))
+	var r Range
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		// Allow whitespace between an operator and its version, e.g. ">= 1.2.0"
+		if isOperator(token) && i+1 < len(tokens) {
+			i++
+			token += tokens[i]
+		}
+		comparator, err := parseComparator(token)
+		if err != nil {
+			return Range{}, err
+		}
+		r = r.narrow(comparator)
+	}
+	return r, nil
+}
+
+// parseComparator turns a single operator and (partial) version into a range.
+func parseComparator(s string) (Range, error) {
+	op := ""
+	for _, candidate := range operators {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			break
+		}
+	}
+	p, err := parsePartial(strings.TrimSpace(strings.TrimPrefix(s, op)))
+	if err != nil {
+		return Range{}, err
+	}
+
+	r, err := comparatorRange(op, p)
+	if err != nil {
+		return Range{}, err
+	}
+	if p.pre != "" {
+		r.prereleases = []*Version{p.version()}
+	}
+	return r, nil
+}
+
+func comparatorRange(op string, p partial) (Range, error) {
+	switch op {
+	case "", "=":
+		if p.n == 3 {
+			v := p.version()
+			return Range{lower: &bound{v, true}, upper: &bound{v, true}}, nil
+		}
+		return Range{lower: p.lowerBound(), upper: p.upperBound(false)}, nil
+	case ">=":
+		return Range{lower: p.lowerBound()}, nil
+	case ">":
+		switch p.n {
+		case 0:
+			return emptyRange(), nil
+		case 3:
+			return Range{lower: &bound{p.version(), false}}, nil
+		}
+		// >1.2 is >=1.3.0: the first release past the wildcard
+		return Range{lower: &bound{p.upperBound(false).version, true}}, nil
+	case "<":
+		if p.n == 0 {
+			return emptyRange(), nil
+		}
+		return Range{upper: p.exactUpper()}, nil
+	case "<=":
+		if p.n == 3 {
+			return Range{upper: &bound{p.version(), true}}, nil
+		}
+		return Range{upper: p.upperBound(false)}, nil
+	case "~", "~>":
+		if p.n <= 1 {
+			return Range{lower: p.lowerBound(), upper: p.upperBound(false)}, nil
+		}
+		upper := &Version{Major: p.major, Minor: p.minor + 1}
+		return Range{lower: p.lowerBound(), upper: &bound{upper, false}}, nil
+	case "^":
+		return p.caret(), nil
+	}
+	return Range{}, fmt.Errorf("unsupported operator %q", op)
+}
+
+func isOperator(s string) bool {
+	for _, op := range operators {
+		if s == op {
+			return true
+		}
+	}
+	return false
+}
+
+func emptyRange() Range {
+	zero := &Version{}
+	return Range{lower: &bound{zero, false}, upper: &bound{zero, false}}
+}
+
+func parsePartial(s string) (partial, error) {
+	m := partialRegex.FindStringSubmatch(s)
+	if m == nil {
+		return partial{}, fmt.Errorf("invalid version %q", s)
+	}
+
+	var p partial
+	components := []*int{&p.major, &p.minor, &p.patch}
+	wildcard := false
+	for i, c := range m[1:4] {
+		if c == "" {
+			break
+		}
+		if c == "x" || c == "X" || c == "*" {
+			wildcard = true
+			continue
+		}
+		// 1.x.3 would silently mean 1.x
+		if wildcard {
+			return partial{}, fmt.Errorf("invalid version %q: a wildcard can only be followed by wildcards", s)
+		}
+		n, err := strconv.Atoi(c)
+		if err != nil {
+			return partial{}, fmt.Errorf("invalid version %q", s)
+		}
+		*components[i] = n
+		p.n = i + 1
+	}
+	if wildcard && (m[4] != "" || m[5] != "") {
+		return partial{}, fmt.Errorf("invalid version %q: a wildcard can only be followed by wildcards", s)
+	}
+	if p.n == 3 {
+		p.pre, p.build = m[4], m[5]
+	}
+	return p, nil
+}
+
+func (p partial) version() *Version {
+	return &Version{Major: p.major, Minor: p.minor, Patch: p.patch, Pre: p.pre, Build: p.build}
+}
+
+// lowerBound is the inclusive lower bound of a range starting at p: partial
+// versions are filled with zeros.
+func (p partial) lowerBound() *bound {
+	if p.n == 0 {
+		return nil
+	}
+	return &bound{p.version(), true}
+}
+
+// exactUpper is the upper bound of "<p": partial versions are filled with zeros.
+func (p partial) exactUpper() *bound {
+	return &bound{p.version(), false}
+}
+
+// upperBound is the upper bound of a range ending at p: p itself when all
+// components are given and inclusive is true, otherwise the exclusive bound
+// just past the wildcard (e.g. <1.3.0 for 1.2).
+func (p partial) upperBound(inclusive bool) *bound {
+	switch p.n {
+	case 0:
+		return nil
+	case 1:
+		return &bound{&Version{Major: p.major + 1}, false}
+	case 2:
+		return &bound{&Version{Major: p.major, Minor: p.minor + 1}, false}
+	}
+	if inclusive {
+		return &bound{p.version(), true}
+	}
+	return &bound{&Version{Major: p.major, Minor: p.minor, Patch: p.patch + 1}, false}
+}
+
+// caret returns the range of ^p: versions that do not change the left-most
+// non-zero component of p.
+func (p partial) caret() Range {
+	lower := p.lowerBound()
+	var upper *Version
+	switch {
+	case p.n == 0:
+		return Range{}
+	case p.major > 0 || p.n == 1:
+		upper = &Version{Major: p.major + 1}
+	case p.minor > 0 || p.n == 2:
+		upper = &Version{Minor: p.minor + 1}
+	default:
+		upper = &Version{Patch: p.patch + 1}
+	}
+	return Range{lower: lower, upper: &bound{upper, false}}
+}
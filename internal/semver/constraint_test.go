@@ -0,0 +1,113 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{">=1.2.0 <2.0.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{">=1.2.0, <2.0.0", []string{"1.5.0"}, []string{"2.0.0"}},
+		{">= 1.2.0 < 2.0.0", []string{"1.5.0"}, []string{"1.0.0", "2.0.0"}},
+		{">1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"<1.2", []string{"1.1.9"}, []string{"1.2.0"}},
+		{"1.x", []string{"1.0.0", "1.9.0"}, []string{"0.9.0", "2.0.0"}},
+		{"1.2.*", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"1.x.X", []string{"1.0.0", "1.9.9"}, []string{"2.0.0"}},
+		{"1.2", []string{"1.2.5"}, []string{"1.3.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, nil},
+		{"x", []string{"1.0.0"}, nil},
+		{"1.2.3 - 1.4.0", []string{"1.2.3", "1.4.0"}, []string{"1.2.2", "1.4.1"}},
+		{"1.2.3 - 1.4", []string{"1.4.9"}, []string{"1.5.0"}},
+		{"^1.2 || ^2.0", []string{"1.2.0", "2.5.0"}, []string{"1.1.0", "3.0.0"}},
+		{"~1.2 || >=3.0.0", []string{"1.2.5", "3.1.0"}, []string{"1.3.0", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"^0.2", []string{"0.2.0"}, []string{"0.3.0"}},
+		{"^1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"~1", []string{"1.9.0"}, []string{"2.0.0"}},
+		{"~>1.2.3", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"=1.2.3", []string{"1.2.3"}, []string{"1.2.4"}},
+		{"v1.2.3", []string{"1.2.3"}, []string{"1.2.4"}},
+		{">=2.0.0 <1.0.0", nil, []string{"0.5.0", "1.5.0", "2.5.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			c, err := ParseConstraint(tt.constraint)
+			if err != nil {
+				t.Fatalf("ParseConstraint() error = %v", err)
+			}
+			for _, version := range tt.matches {
+				if !c.Check(mustParse(t, version)) {
+					t.Errorf("%s should satisfy %s", version, tt.constraint)
+				}
+			}
+			for _, version := range tt.rejects {
+				if c.Check(mustParse(t, version)) {
+					t.Errorf("%s should not satisfy %s", version, tt.constraint)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConstraint_Invalid(t *testing.T) {
+	for _, constraint := range []string{"abc", ">=", "1.2.3.4", "^1.2 || foo", "!1.2.3",
+		"1.x.3", "^1.*.0", "x.2", "1.2.x-beta", ">=1.x.x-rc.1", "1.X.X+build"} {
+		if _, err := ParseConstraint(constraint); err == nil {
+			t.Errorf("ParseConstraint(%q) expected error", constraint)
+		}
+	}
+}
+
+func TestConstraintIntersect(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		empty   bool
+		matches []string
+		rejects []string
+	}{
+		{"^1.2.0", "~1.4.0", false, []string{"1.4.5"}, []string{"1.3.0", "1.5.0"}},
+		{"^1.2.0", "^2.0.0", true, nil, []string{"1.5.0", "2.0.0"}},
+		{">=1.0.0 <1.5.0", ">=1.5.0", true, nil, []string{"1.5.0"}},
+		{"<=1.5.0", ">=1.5.0", false, []string{"1.5.0"}, []string{"1.4.0", "1.6.0"}},
+		{"^1.0.0 || ^2.0.0", "~2.1.0", false, []string{"2.1.3"}, []string{"1.1.0", "2.2.0"}},
+		{"^1.3.0-rc.0", ">=1.3.0-beta", false, []string{"1.3.0-rc.1", "1.3.0"}, nil},
+		{"^1.3.0-rc.0", ">=1.0.0", false, []string{"1.3.0"}, []string{"1.3.0-rc.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" & "+tt.b, func(t *testing.T) {
+			c := MustParseConstraint(tt.a).Intersect(MustParseConstraint(tt.b))
+			if c.IsEmpty() != tt.empty {
+				t.Errorf("IsEmpty() = %v, want %v (%s)", c.IsEmpty(), tt.empty, c)
+			}
+			for _, version := range tt.matches {
+				if !c.Check(mustParse(t, version)) {
+					t.Errorf("%s should satisfy %s", version, c)
+				}
+			}
+			for _, version := range tt.rejects {
+				if c.Check(mustParse(t, version)) {
+					t.Errorf("%s should not satisfy %s", version, c)
+				}
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, version string) *Version {
+	t.Helper()
+	v, err := Parse(version)
+	if err != nil {
+		t.Fatalf("Parse(%q) error = %v", version, err)
+	}
+	return v
+}
@@ -0,0 +1,37 @@
+package semver
+
+import (
+	"regexp"
+	"time"
+)
+
+// pseudoTimeFormat is the UTC timestamp layout used in pseudo-versions.
+const pseudoTimeFormat = "20060102150405"
+
+var pseudoRegex = regexp.MustCompile(`^v0\.0\.0-(\d{14})-([0-9a-f]{12})$`)
+
+// PseudoVersion returns the Go-style pseudo-version for a commit made at t,
+// e.g. "v0.0.0-20260101120000-abcdef123456". Pseudo-versions are pre-releases
+// of v0.0.0 that sort by commit time, so untagged commits can be locked,
+// compared and displayed like any other version.
+func PseudoVersion(t time.Time, commit string) string {
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return "v0.0.0-" + t.UTC().Format(pseudoTimeFormat) + "-" + commit
+}
+
+// IsPseudoVersion reports whether v is a pseudo-version.
+func IsPseudoVersion(v string) bool {
+	return pseudoRegex.MatchString(v)
+}
+
+// PseudoVersionCommit returns the abbreviated commit of a pseudo-version, or
+// an empty string if v is not a pseudo-version.
+func PseudoVersionCommit(v string) string {
+	m := pseudoRegex.FindStringSubmatch(v)
+	if m == nil {
+		return ""
+	}
+	return m[2]
+}
@@ -0,0 +1,43 @@
+package semver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPseudoVersion(t *testing.T) {
+	commit := "abcdef1234567890abcdef1234567890abcdef12"
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+
+	got := PseudoVersion(at, commit)
+	if want := "v0.0.0-20260101110000-abcdef123456"; got != want {
+		t.Fatalf("PseudoVersion() = %s, want %s", got, want)
+	}
+	if !IsPseudoVersion(got) {
+		t.Errorf("IsPseudoVersion(%s) = false", got)
+	}
+	if c := PseudoVersionCommit(got); c != "abcdef123456" {
+		t.Errorf("PseudoVersionCommit() = %s", c)
+	}
+	if IsPseudoVersion("v1.2.3") || PseudoVersionCommit("v1.2.3") != "" {
+		t.Error("v1.2.3 is not a pseudo-version")
+	}
+
+	// Later commits sort higher, and below any release
+	older := mustParse(t, PseudoVersion(at.Add(-time.Hour), "ffffffffffff"))
+	newer := mustParse(t, got)
+	if older.Compare(newer) >= 0 {
+		t.Errorf("%s should sort before %s", older, newer)
+	}
+	if newer.Compare(mustParse(t, "v0.0.1")) >= 0 {
+		t.Errorf("%s should sort before v0.0.1", newer)
+	}
+
+	// Only an exact constraint matches a pseudo-version
+	if ok, _ := newer.Satisfies(got); !ok {
+		t.Errorf("%s should satisfy itself", got)
+	}
+	if ok, _ := newer.Satisfies(">=0.0.0"); ok {
+		t.Errorf("%s should not satisfy >=0.0.0", got)
+	}
+}
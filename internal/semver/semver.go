@@ -44,6 +44,11 @@ func Parse(version string) (*Version, error) {
 	return v, nil
 }
 
+// Compare returns a negative number, zero or a positive number when v has
+// lower, equal or higher precedence than other, following SemVer 2.0.0:
+// major, minor and patch are compared numerically, a pre-release has lower
+// precedence than the associated release, pre-release identifiers are
+// compared one by one, and build metadata is ignored.
 func (v *Version) Compare(other *Version) int {
 	if v.Major != other.Major {
 		return v.Major - other.Major
@@ -54,95 +59,85 @@ func (v *Version) Compare(other *Version) int {
 	if v.Patch != other.Patch {
 		return v.Patch - other.Patch
 	}
-	if v.Pre != "" && other.Pre == "" {
-		return -1
-	}
-	if v.Pre == "" && other.Pre != "" {
-		return 1
-	}
-	if v.Pre != "" && other.Pre != "" {
-		return strings.Compare(v.Pre, other.Pre)
-	}
-	return 0
+	return comparePrerelease(v.Pre, other.Pre)
 }
 
-func (v *Version) String() string {
-	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
-	if v.Pre != "" {
-		s += "-" + v.Pre
+// comparePrerelease compares two pre-release strings. An empty string (a
+// release) has higher precedence than any pre-release.
+func comparePrerelease(a, b string) int {
+	if a == b {
+		return 0
 	}
-	if v.Build != "" {
-		s += "+" + v.Build
+	if a == "" {
+		return 1
 	}
-	return s
-}
-
-func (v *Version) Satisfies(constraint string) (bool, error) {
-	if constraint == "" {
-		return true, nil
+	if b == "" {
+		return -1
 	}
 
-	constraint = strings.TrimSpace(constraint)
-
-	// Handle caret (^) constraint
-	if strings.HasPrefix(constraint, "^") {
-		baseVersion, err := Parse(strings.TrimPrefix(constraint, "^"))
-		if err != nil {
-			return false, err
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareIdentifier(as[i], bs[i]); c != 0 {
+			return c
 		}
-		return satisfiesCaret(v, baseVersion), nil
 	}
+	// A larger set of identifiers has higher precedence if all preceding
+	// identifiers are equal
+	return len(as) - len(bs)
+}
 
-	// Handle tilde (~) constraint
-	if strings.HasPrefix(constraint, "~") {
-		baseVersion, err := Parse(strings.TrimPrefix(constraint, "~"))
-		if err != nil {
-			return false, err
+// compareIdentifier compares pre-release identifiers: numeric identifiers
+// numerically, alphanumeric ones lexically in ASCII order, and numeric
+// identifiers always have lower precedence than alphanumeric ones.
+func compareIdentifier(a, b string) int {
+	aNum, bNum := isNumeric(a), isNumeric(b)
+	switch {
+	case aNum && bNum:
+		// Compare by length first so that arbitrarily large numbers work
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return len(a) - len(b)
 		}
-		return satisfiesTilde(v, baseVersion), nil
+		return strings.Compare(a, b)
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	default:
+		return strings.Compare(a, b)
 	}
+}
 
-	// Handle exact match
-	if strings.HasPrefix(constraint, "=") || !strings.ContainsAny(constraint, "^~><") {
-		exactVersion, err := Parse(strings.TrimPrefix(constraint, "="))
-		if err != nil {
-			return false, err
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
 		}
-		return v.Compare(exactVersion) == 0, nil
 	}
-
-	return false, fmt.Errorf("unsupported constraint: %s", constraint)
+	return true
 }
 
-func satisfiesCaret(v, base *Version) bool {
-	// ^1.2.3 allows >=1.2.3 <2.0.0
-	// Also allow pre-releases if base version matches (e.g., 1.2.3-alpha satisfies ^1.2.3)
-	if base.Major > 0 {
-		if v.Major != base.Major {
-			return false
-		}
-		// If base version matches exactly (including pre-release), allow it
-		if v.Major == base.Major && v.Minor == base.Minor && v.Patch == base.Patch {
-			return true
-		}
-		return v.Compare(base) >= 0
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
 	}
-	// ^0.2.3 allows >=0.2.3 <0.3.0
-	if base.Minor > 0 {
-		if v.Major != base.Major || v.Minor != base.Minor {
-			return false
-		}
-		// Allow pre-releases of the base version
-		if v.Patch == base.Patch {
-			return true
-		}
-		return v.Compare(base) >= 0
+	if v.Build != "" {
+		s += "+" + v.Build
 	}
-	// ^0.0.3 allows >=0.0.3 <0.0.4
-	return v.Major == base.Major && v.Minor == base.Minor && v.Patch == base.Patch
+	return s
 }
 
-func satisfiesTilde(v, base *Version) bool {
-	// ~1.2.3 allows >=1.2.3 <1.3.0
-	return v.Major == base.Major && v.Minor == base.Minor && v.Compare(base) >= 0
+// Satisfies reports whether v satisfies constraint. See Constraint for the
+// supported syntax.
+func (v *Version) Satisfies(constraint string) (bool, error) {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	return c.Check(v), nil
 }
@@ -78,8 +78,10 @@ func TestSatisfies(t *testing.T) {
 		{"1.2.3", "1.2.3", true},
 		{"1.2.4", "1.2.3", false},
 		{"1.2.3", "", true}, // empty constraint always satisfies
-		{"5.8.0-stable", "^5.8.0", true}, // pre-release should satisfy if base version matches
-		{"5.8.4-stable", "^5.8.0", true},
+		{"5.8.0-stable", "^5.8.0", false}, // pre-releases only match ranges that name one
+		{"5.8.4-stable", "^5.8.0", false},
+		{"5.8.0-stable", "^5.8.0-stable", true},
+		{"5.8.4-stable", "^5.8.0-stable", false}, // named pre-release is for 5.8.0 only
 		{"5.9.0", "^5.8.0", true},
 		{"6.0.0", "^5.8.0", false},
 	}
@@ -98,3 +100,96 @@ func TestSatisfies(t *testing.T) {
 		})
 	}
 }
+
+// TestComparePrecedence checks the precedence rules of SemVer 2.0.0 section 11.
+// Each version has lower precedence than the one after it.
+func TestComparePrecedence(t *testing.T) {
+	ordered := []string{
+		"1.0.0-0",
+		"1.0.0-1",
+		"1.0.0-2",
+		"1.0.0-10",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0-rc.2",
+		"1.0.0-rc.10",
+		"1.0.0",
+		"1.0.1-alpha",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+		"10.0.0",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, b := mustParse(t, ordered[i]), mustParse(t, ordered[j])
+			got := a.Compare(b)
+			switch {
+			case i < j && got >= 0:
+				t.Errorf("Compare(%s, %s) = %d, want < 0", ordered[i], ordered[j], got)
+			case i > j && got <= 0:
+				t.Errorf("Compare(%s, %s) = %d, want > 0", ordered[i], ordered[j], got)
+			case i == j && got != 0:
+				t.Errorf("Compare(%s, %s) = %d, want 0", ordered[i], ordered[j], got)
+			}
+		}
+	}
+}
+
+func TestCompareIgnoresBuildMetadata(t *testing.T) {
+	tests := [][2]string{
+		{"1.0.0+build.1", "1.0.0+build.2"},
+		{"1.0.0-rc.1+sha.abc", "1.0.0-rc.1"},
+		{"1.0.0+20130313144700", "1.0.0"},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt[0]).Compare(mustParse(t, tt[1])); got != 0 {
+			t.Errorf("Compare(%s, %s) = %d, want 0", tt[0], tt[1], got)
+		}
+	}
+}
+
+// TestPrereleasePolicy checks that pre-releases only satisfy ranges that name
+// a pre-release with the same major.minor.patch.
+func TestPrereleasePolicy(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		expected   bool
+	}{
+		{"1.3.0-rc.1", "^1.2.0", false},
+		{"1.3.0-rc.1", ">=1.0.0", false},
+		{"1.3.0-rc.1", "*", false},
+		{"1.3.0-rc.1", ">=1.3.0-beta", true},
+		{"1.3.0-rc.1", ">=1.3.0-rc.2", false},
+		{"1.3.0-rc.10", ">=1.3.0-rc.2", true},
+		{"1.3.0-rc.1", "^1.3.0-rc.0", true},
+		{"1.3.1-rc.1", "^1.3.0-rc.0", false},
+		{"1.3.0", "^1.3.0-rc.0", true},
+		{"1.3.0-rc.1", "~1.3.0-alpha", true},
+		{"1.3.0-rc.1", "1.3.0-rc.1", true},
+		{"1.3.0-rc.2", "1.3.0-rc.1", false},
+		{"1.3.0-rc.1", "1.2.0 - 1.3.0-rc.5", true},
+		{"1.3.0-rc.1", ">=1.2.0 <1.3.0-rc.5", true},
+		{"1.3.0-rc.1", "^1.2.0 || >=1.3.0-alpha", true},
+		{"2.0.0-alpha", "^1.0.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.constraint, func(t *testing.T) {
+			got, err := mustParse(t, tt.version).Satisfies(tt.constraint)
+			if err != nil {
+				t.Fatalf("Satisfies() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Satisfies() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -1,15 +1,37 @@
 package submodule
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/SCKelemen/cpkg/internal/git"
 )
 
+// AddSubmodule adds repoURL as a submodule at path. When the module cache
+// has a mirror of the repository, the clone borrows its objects instead of
+// downloading them, then dissociates so the submodule keeps working after
+// the cache is cleaned. Offline, the clone is made from the mirror alone,
+// while .gitmodules and the submodule's origin still record repoURL.
 func AddSubmodule(repoURL, path string) error {
-	cmd := exec.Command("git", "submodule", "add", repoURL, path)
+	var args []string
+	mirror, err := git.Mirror(repoURL)
+	if git.Offline() {
+		if err != nil {
+			return err
+		}
+		args = append(args, "-c", "protocol.file.allow=always", "-c", "url."+mirror+".insteadOf="+repoURL)
+	}
+	args = append(args, "submodule", "add")
+	if err == nil && mirror != "" {
+		args = append(args, "--reference", mirror, "--dissociate")
+	}
+	args = append(args, repoURL, path)
+	cmd := exec.Command("git", args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to add submodule: %w\noutput: %s", err, string(output))
@@ -53,6 +75,99 @@ func FetchCommit(path string) error {
 	return nil
 }
 
+// FetchLockedCommit makes commit available in the checkout at path. Nothing
+// is fetched if the checkout already has it; otherwise it comes from the
+// module cache mirror of repoURL when that has it, and from origin (tags and
+// branches) as a last resort, which is an error offline.
+func FetchLockedCommit(path, repoURL, commit string) error {
+	if git.HasCommit(path, commit) {
+		return nil
+	}
+	if mirror, err := git.Mirror(repoURL); err == nil && mirror != "" && git.HasCommit(mirror, commit) {
+		cmd := exec.Command("git", "-C", path, "fetch", "--quiet", "--tags", mirror, commit)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to fetch %s from the module cache: %w\noutput: %s", commit, err, string(output))
+		}
+		return nil
+	}
+	if git.Offline() {
+		return fmt.Errorf("commit %s of %s is not in the module cache: %w", commit, repoURL, git.ErrOffline)
+	}
+	if err := FetchTags(path); err != nil {
+		return err
+	}
+	return FetchCommit(path)
+}
+
+// rawAttributes turns off every conversion git may apply between the
+// repository and the working tree: line endings, clean/smudge filters (e.g.
+// Git LFS), $Id$ expansion and re-encoding.
+const rawAttributes = "* -text -eol -filter -ident -working-tree-encoding"
+
+// DisableFilters makes the checkout at path hold files exactly as committed,
+// whatever its .gitattributes or the user's core.autocrlf say, so that its
+// content hash matches the one computed from the commit. Files checked out
+// with conversions before are written again.
+func DisableFilters(path string) error {
+	output, err := exec.Command("git", "-C", path, "rev-parse", "--git-path", "info/attributes").Output()
+	if err != nil {
+		return fmt.Errorf("failed to locate git directory of %s: %w", path, err)
+	}
+	attributesPath := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(attributesPath) {
+		attributesPath = filepath.Join(path, attributesPath)
+	}
+	data, err := os.ReadFile(attributesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", attributesPath, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == rawAttributes {
+			return nil
+		}
+	}
+
+	// Rewriting the files below would discard changes to them
+	output, err = exec.Command("git", "-C", path, "status", "--porcelain", "--untracked-files=no").Output()
+	if err != nil {
+		return fmt.Errorf("failed to check %s for local changes: %w", path, err)
+	}
+	if len(bytes.TrimSpace(output)) > 0 {
+		return fmt.Errorf("%s has local changes; commit or discard them so that its files can be checked out without conversions", path)
+	}
+
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, rawAttributes+"\n"...)
+	if err := EnsureDir(attributesPath); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(attributesPath), err)
+	}
+	if err := os.WriteFile(attributesPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", attributesPath, err)
+	}
+	if output, err := exec.Command("git", "-C", path, "config", "core.autocrlf", "false").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to configure %s: %w\noutput: %s", path, err, string(output))
+	}
+
+	// Without an index, every file is written again
+	output, err = exec.Command("git", "-C", path, "rev-parse", "--git-path", "index").Output()
+	if err != nil {
+		return fmt.Errorf("failed to locate git directory of %s: %w", path, err)
+	}
+	indexPath := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(path, indexPath)
+	}
+	if err := os.Remove(indexPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to reset %s: %w", path, err)
+	}
+	if output, err := exec.Command("git", "-C", path, "reset", "--quiet", "--hard").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to reset %s: %w\noutput: %s", path, err, string(output))
+	}
+	return nil
+}
+
 func Checkout(path, commit string) error {
 	cmd := exec.Command("git", "-C", path, "checkout", commit)
 	output, err := cmd.CombinedOutput()
@@ -62,8 +177,10 @@ func Checkout(path, commit string) error {
 	return nil
 }
 
-func GetSubmoduleURL(path string) (string, error) {
-	cmd := exec.Command("git", "config", "--file", ".gitmodules", "--get", fmt.Sprintf("submodule.%s.url", path))
+// GetSubmoduleURL returns the URL of the submodule at path, relative to top,
+// the top of the work tree, or an empty string if there is none.
+func GetSubmoduleURL(top, path string) (string, error) {
+	cmd := exec.Command("git", "config", "--file", filepath.Join(top, ".gitmodules"), "--get", fmt.Sprintf("submodule.%s.url", filepath.ToSlash(path)))
 	output, err := cmd.Output()
 	if err != nil {
 		return "", nil // Submodule not in .gitmodules
@@ -71,13 +188,15 @@ func GetSubmoduleURL(path string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-func SubmoduleExists(path string) bool {
-	gitmodulesPath := ".gitmodules"
+// SubmoduleExists reports whether the .gitmodules at top, the top of the work
+// tree, declares a submodule at path, relative to top.
+func SubmoduleExists(top, path string) bool {
+	gitmodulesPath := filepath.Join(top, ".gitmodules")
 	if _, err := os.Stat(gitmodulesPath); os.IsNotExist(err) {
 		return false
 	}
 
-	cmd := exec.Command("git", "config", "--file", gitmodulesPath, "--get", fmt.Sprintf("submodule.%s.url", path))
+	cmd := exec.Command("git", "config", "--file", gitmodulesPath, "--get", fmt.Sprintf("submodule.%s.url", filepath.ToSlash(path)))
 	err := cmd.Run()
 	return err == nil
 }
@@ -91,16 +210,179 @@ func GetSubmoduleCommit(path string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+// IsSubmoduleDirty reports whether the checkout at path differs from its
+// HEAD: staged or unstaged changes, or untracked files that are not ignored.
 func IsSubmoduleDirty(path string) (bool, error) {
-	cmd := exec.Command("git", "-C", path, "diff", "--quiet")
-	err := cmd.Run()
-	if err == nil {
-		return false, nil
+	output, err := exec.Command("git", "-C", path, "status", "--porcelain", "--untracked-files=normal").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to get status of %s: %w", path, err)
+	}
+	return len(bytes.TrimSpace(output)) > 0, nil
+}
+
+// HasLocalWork reports whether the checkout at path has anything that
+// removing it would lose: uncommitted changes, untracked files, or commits
+// that are on no remote branch or tag.
+func HasLocalWork(path string) (bool, error) {
+	status, err := exec.Command("git", "-C", path, "status", "--porcelain").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to get status of %s: %w", path, err)
 	}
-	if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
+	if len(strings.TrimSpace(string(status))) > 0 {
 		return true, nil
 	}
-	return false, err
+	unpublished, err := exec.Command("git", "-C", path, "rev-list", "-n", "1", "HEAD", "--not", "--remotes", "--tags").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to list commits of %s: %w", path, err)
+	}
+	return len(strings.TrimSpace(string(unpublished))) > 0, nil
+}
+
+// Submodule is a submodule declared in .gitmodules.
+type Submodule struct {
+	Name   string // Section name, also the directory under .git/modules
+	Path   string // Relative to the top of the superproject
+	Module string // Module path recorded by MarkSubmodule, "" if it was not added by cpkg
+}
+
+// moduleKey is the .gitmodules variable, next to path and url, recording the
+// module a submodule holds.
+const moduleKey = "cpkg-module"
+
+// MarkSubmodule records in the .gitmodules at top that the submodule at path,
+// relative to top, holds module, and stages the change. cpkg marks the
+// submodules it manages so that it never removes one it did not add.
+func MarkSubmodule(top, path, module string) error {
+	gitmodules := filepath.Join(top, ".gitmodules")
+	key := fmt.Sprintf("submodule.%s.%s", filepath.ToSlash(path), moduleKey)
+	if output, err := exec.Command("git", "config", "--file", gitmodules, "--get", key).Output(); err == nil && strings.TrimSpace(string(output)) == module {
+		return nil
+	}
+	if output, err := exec.Command("git", "config", "--file", gitmodules, key, module).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mark submodule %s: %w\noutput: %s", path, err, string(output))
+	}
+	if output, err := exec.Command("git", "-C", top, "add", ".gitmodules").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to stage .gitmodules: %w\noutput: %s", err, string(output))
+	}
+	return nil
+}
+
+// Toplevel returns the top of the work tree of the repository containing
+// dir, where .gitmodules is and which submodule paths are relative to.
+func Toplevel(dir string) (string, error) {
+	output, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not in a git repository: %w", dir, err)
+	}
+	return filepath.FromSlash(strings.TrimSpace(string(output))), nil
+}
+
+// ListSubmodules returns the submodules declared in the .gitmodules of the
+// work tree whose top is top, sorted by path.
+func ListSubmodules(top string) ([]Submodule, error) {
+	gitmodules := filepath.Join(top, ".gitmodules")
+	if _, err := os.Stat(gitmodules); os.IsNotExist(err) {
+		return nil, nil
+	}
+	output, err := exec.Command("git", "config", "--file", gitmodules, "--get-regexp", `^submodule\..*\.(path|`+moduleKey+`)$`).Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
+			return nil, nil // No submodules
+		}
+		return nil, fmt.Errorf("failed to read .gitmodules: %w", err)
+	}
+
+	modules := make(map[string]string) // Name -> module
+	var submodules []Submodule
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if name, ok := strings.CutSuffix(key, "."+moduleKey); ok {
+			modules[strings.TrimPrefix(name, "submodule.")] = value
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "submodule."), ".path")
+		submodules = append(submodules, Submodule{Name: name, Path: value})
+	}
+	for i := range submodules {
+		submodules[i].Module = modules[submodules[i].Name]
+	}
+	sort.Slice(submodules, func(i, j int) bool { return submodules[i].Path < submodules[j].Path })
+	return submodules, nil
+}
+
+// RemoveSubmodule removes a submodule of the work tree whose top is top
+// completely: it is deinitialized, its entry is removed from .gitmodules and
+// the index, and both its working tree and its repository under .git/modules
+// are deleted. Local changes in the submodule are lost; check HasLocalWork
+// first. As .gitmodules may come from anyone, a submodule whose name or path
+// leads outside .git/modules or the work tree is refused.
+func RemoveSubmodule(top string, sub Submodule) error {
+	worktree, err := within(top, sub.Path)
+	if err != nil {
+		return fmt.Errorf("refusing to remove submodule %s: path %w", sub.Name, err)
+	}
+	output, err := exec.Command("git", "-C", top, "rev-parse", "--absolute-git-dir").Output()
+	if err != nil {
+		return fmt.Errorf("failed to locate git directory: %w", err)
+	}
+	modulesDir, err := within(filepath.Join(strings.TrimSpace(string(output)), "modules"), sub.Name)
+	if err != nil {
+		return fmt.Errorf("refusing to remove submodule %s: name %w", sub.Name, err)
+	}
+
+	// Fails harmlessly if the submodule was never initialized
+	exec.Command("git", "-C", top, "submodule", "deinit", "--force", "--quiet", "--", sub.Path).Run()
+
+	// git rm also removes the .gitmodules entry; if the submodule is not in
+	// the index, do both by hand
+	if err := exec.Command("git", "-C", top, "rm", "--quiet", "--force", "--", sub.Path).Run(); err != nil {
+		cmd := exec.Command("git", "config", "--file", filepath.Join(top, ".gitmodules"), "--remove-section", "submodule."+sub.Name)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove submodule %s from .gitmodules: %w\noutput: %s", sub.Path, err, string(output))
+		}
+		exec.Command("git", "-C", top, "add", ".gitmodules").Run()
+		if err := os.RemoveAll(worktree); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", sub.Path, err)
+		}
+	}
+
+	if err := os.RemoveAll(modulesDir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", modulesDir, err)
+	}
+	return nil
+}
+
+// within returns the path of rel, a slash-separated path from .gitmodules,
+// inside dir. It is an error for rel to be empty or absolute, to contain
+// "..", or to lead out of dir through a symlink.
+func within(dir, rel string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if rel == "" || clean == "." || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" {
+		return "", fmt.Errorf("%q must be a relative path", rel)
+	}
+	for _, part := range strings.Split(clean, string(filepath.Separator)) {
+		if part == ".." {
+			return "", fmt.Errorf("%q must not contain ..", rel)
+		}
+	}
+
+	path := filepath.Join(dir, clean)
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		realDir = dir
+	}
+	// The path itself may be a symlink, which is removed, not followed
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return path, nil // Nothing there to follow
+	}
+	if r, err := filepath.Rel(realDir, realParent); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q leads outside %s", rel, dir)
+	}
+	return path, nil
 }
 
 func EnsureDir(path string) error {
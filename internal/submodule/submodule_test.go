@@ -2,7 +2,9 @@ package submodule
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -10,7 +12,7 @@ func TestSubmoduleExists(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	// Test: no .gitmodules
-	if SubmoduleExists("some/path") {
+	if SubmoduleExists(tmpDir, "some/path") {
 		t.Error("expected false when .gitmodules doesn't exist")
 	}
 
@@ -24,25 +26,57 @@ func TestSubmoduleExists(t *testing.T) {
 		t.Fatalf("failed to write .gitmodules: %v", err)
 	}
 
-	// Change to tmpDir to test
-	originalDir, _ := os.Getwd()
-	defer os.Chdir(originalDir)
-
-	if err := os.Chdir(tmpDir); err != nil {
-		t.Fatalf("failed to chdir: %v", err)
-	}
-
 	// Test: submodule exists
-	if !SubmoduleExists("test/path") {
+	if !SubmoduleExists(tmpDir, "test/path") {
 		t.Error("expected true when submodule exists in .gitmodules")
 	}
 
 	// Test: submodule doesn't exist
-	if SubmoduleExists("other/path") {
+	if SubmoduleExists(tmpDir, "other/path") {
 		t.Error("expected false when submodule doesn't exist")
 	}
 }
 
+func TestRemoveSubmodule_RejectsUnsafeEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	top := filepath.Join(tmpDir, "repo")
+	outside := filepath.Join(tmpDir, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if output, err := exec.Command("git", "init", "--quiet", top).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v\n%s", err, output)
+	}
+	if err := os.Symlink(tmpDir, filepath.Join(top, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		sub  Submodule
+		want string
+	}{
+		{"parent path", Submodule{Name: "deps/x", Path: "../outside"}, "must not contain .."},
+		{"nested parent path", Submodule{Name: "deps/x", Path: "deps/../../outside"}, "must not contain .."},
+		{"absolute path", Submodule{Name: "deps/x", Path: outside}, "must be a relative path"},
+		{"empty path", Submodule{Name: "deps/x", Path: ""}, "must be a relative path"},
+		{"symlinked path", Submodule{Name: "deps/x", Path: "link/outside"}, "leads outside"},
+		{"parent name", Submodule{Name: "../../../outside", Path: "deps/x"}, "must not contain .."},
+		{"absolute name", Submodule{Name: outside, Path: "deps/x"}, "must be a relative path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RemoveSubmodule(top, tt.sub)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("RemoveSubmodule() error = %v, want one containing %q", err, tt.want)
+			}
+			if _, err := os.Stat(outside); err != nil {
+				t.Errorf("directory outside the work tree was removed: %v", err)
+			}
+		})
+	}
+}
+
 func TestEnsureDir(t *testing.T) {
 	tmpDir := t.TempDir()
 	testPath := filepath.Join(tmpDir, "deep", "nested", "path", "file.txt")
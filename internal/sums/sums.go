@@ -0,0 +1,194 @@
+// Package sums implements the shared sums store: a file of trusted content
+// hashes, one per module@version, that is consulted and appended to whenever
+// a lockfile is written. Projects sharing a store (on one machine, or across a
+// team when the file is kept in a shared repository) must agree on the hash of
+// every version they lock, so a moved tag or a tampered mirror is detected even
+// by a project that has never seen the module before.
+//
+// The file holds one "<module> <version> <sum>" line per entry, sorted, with
+// blank lines and lines starting with # ignored.
+package sums
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EnvVar overrides the store location. It may name a file or a directory
+// (which then holds FileName); the value "off" disables the store.
+const EnvVar = "CPKG_SUMS"
+
+// FileName is the name of the store file inside a store directory.
+const FileName = "cpkg.sum"
+
+// Path returns the store location: $CPKG_SUMS, or cpkg/cpkg.sum under the
+// user's configuration directory. It returns "" if the store is disabled.
+func Path() (string, error) {
+	path := os.Getenv(EnvVar)
+	if path == "off" {
+		return "", nil
+	}
+	if path == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate the sums store, set %s: %w", EnvVar, err)
+		}
+		return filepath.Join(configDir, "cpkg", FileName), nil
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return filepath.Join(path, FileName), nil
+	}
+	return path, nil
+}
+
+// Store is an open sums store.
+type Store struct {
+	path  string
+	sums  map[string]string // module@version -> sum
+	added map[string]string // Entries recorded since Open
+}
+
+// Open reads the store at path. A missing file is an empty store.
+func Open(path string) (*Store, error) {
+	sums, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: path, sums: sums, added: make(map[string]string)}, nil
+}
+
+// Path returns the file the store is read from and saved to.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Lookup returns the trusted sum for module@version, if any.
+func (s *Store) Lookup(module, version string) (string, bool) {
+	sum, ok := s.sums[key(module, version)]
+	return sum, ok
+}
+
+// Check compares sum with the trusted sum for module@version. Unknown
+// versions are recorded, to be written by Save; a different sum returns a
+// *MismatchError.
+func (s *Store) Check(module, version, sum string) error {
+	if trusted, ok := s.Lookup(module, version); ok {
+		if trusted != sum {
+			return &MismatchError{Module: module, Version: version, Trusted: trusted, Got: sum, Store: s.path}
+		}
+		return nil
+	}
+	s.sums[key(module, version)] = sum
+	s.added[key(module, version)] = sum
+	return nil
+}
+
+// Save writes the entries recorded since Open. The file is re-read first so
+// that entries written concurrently by other projects are kept; it is an error
+// if one of them disagrees with a recorded entry.
+func (s *Store) Save() error {
+	if len(s.added) == 0 {
+		return nil
+	}
+
+	current, err := readFile(s.path)
+	if err != nil {
+		return err
+	}
+	for k, sum := range s.added {
+		if trusted, ok := current[k]; ok && trusted != sum {
+			module, version, _ := strings.Cut(k, " ")
+			return &MismatchError{Module: module, Version: version, Trusted: trusted, Got: sum, Store: s.path}
+		}
+		current[k] = sum
+	}
+
+	lines := make([]string, 0, len(current))
+	for k, sum := range current {
+		lines = append(lines, k+" "+sum+"\n")
+	}
+	sort.Strings(lines)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create sums store directory: %w", err)
+	}
+	// Write atomically so a concurrent reader never sees a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), FileName+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write sums store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "")); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write sums store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write sums store: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write sums store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write sums store: %w", err)
+	}
+
+	s.added = make(map[string]string)
+	return nil
+}
+
+// MismatchError reports a content hash that differs from the trusted one.
+type MismatchError struct {
+	Module  string
+	Version string
+	Trusted string // Sum in the store
+	Got     string // Sum just computed
+	Store   string // Path of the store
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s@%s:\n  sums store: %s (%s)\n  resolved:   %s\n"+
+		"the content differs from what was previously locked for this version; the tag may have been moved or a mirror may serve different content",
+		e.Module, e.Version, e.Trusted, e.Store, e.Got)
+}
+
+func key(module, version string) string {
+	return module + " " + version
+}
+
+func readFile(path string) (map[string]string, error) {
+	sums := make(map[string]string)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sums store: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed entry %q", path, lineNum, line)
+		}
+		k := key(fields[0], fields[1])
+		if existing, ok := sums[k]; ok && existing != fields[2] {
+			return nil, fmt.Errorf("%s:%d: conflicting sums for %s@%s", path, lineNum, fields[0], fields[1])
+		}
+		sums[k] = fields[2]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sums store: %w", err)
+	}
+	return sums, nil
+}
@@ -0,0 +1,98 @@
+package sums
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared", FileName)
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := s.Check("example.com/a", "v1.0.0", "h1:aaa"); err != nil {
+		t.Fatalf("Check() of a new entry error = %v", err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// Another project writes its own entry in the meantime
+	other, _ := Open(path)
+	other.Check("example.com/b", "v2.0.0", "h1:bbb")
+	if err := other.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	s.Check("example.com/c", "v0.1.0", "h1:ccc")
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read store: %v", err)
+	}
+	want := "example.com/a v1.0.0 h1:aaa\nexample.com/b v2.0.0 h1:bbb\nexample.com/c v0.1.0 h1:ccc\n"
+	if string(data) != want {
+		t.Errorf("store = %q, want %q", data, want)
+	}
+
+	reopened, _ := Open(path)
+	if err := reopened.Check("example.com/b", "v2.0.0", "h1:bbb"); err != nil {
+		t.Errorf("Check() of a matching entry error = %v", err)
+	}
+	err = reopened.Check("example.com/a", "v1.0.0", "h1:evil")
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) || mismatch.Trusted != "h1:aaa" || mismatch.Got != "h1:evil" {
+		t.Errorf("Check() error = %v, want a mismatch against h1:aaa", err)
+	}
+}
+
+func TestSaveDetectsConcurrentMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+
+	a, _ := Open(path)
+	b, _ := Open(path)
+	a.Check("example.com/a", "v1.0.0", "h1:aaa")
+	b.Check("example.com/a", "v1.0.0", "h1:bbb")
+	if err := a.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	var mismatch *MismatchError
+	if err := b.Save(); !errors.As(err, &mismatch) {
+		t.Errorf("Save() error = %v, want a mismatch", err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv(EnvVar, "off")
+	if path, err := Path(); err != nil || path != "" {
+		t.Errorf("Path() = %q, %v; want disabled", path, err)
+	}
+
+	t.Setenv(EnvVar, dir)
+	if path, _ := Path(); path != filepath.Join(dir, FileName) {
+		t.Errorf("Path() = %q for a directory", path)
+	}
+
+	file := filepath.Join(dir, "team.sum")
+	t.Setenv(EnvVar, file)
+	if path, _ := Path(); path != file {
+		t.Errorf("Path() = %q, want %q", path, file)
+	}
+}
+
+func TestOpenMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	os.WriteFile(path, []byte("# comment\nexample.com/a v1.0.0\n"), 0644)
+	if _, err := Open(path); err == nil {
+		t.Error("expected an error for a malformed entry")
+	}
+}